@@ -4,128 +4,424 @@ package main
 // Command rtorrent-exporter provides a Prometheus exporter for rTorrent.
 
 import (
-	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"net"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
-	"github.com/aauren/rtorrent/rtorrent"
+	"github.com/aauren/rtorrent-exporter/pkg/config"
+	"github.com/aauren/rtorrent-exporter/pkg/reload"
+	"github.com/aauren/rtorrent-exporter/pkg/web"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
+	configFile = flag.String("config.file", "",
+		"[optional] path to a YAML configuration file, flags set on the command line override its settings")
+
 	telemetryAddr    = flag.String("telemetry.addr", ":9135", "host:port for rTorrent exporter")
 	metricsPath      = flag.String("telemetry.path", "/metrics", "URL path for surfacing collected metrics")
 	telemetryTimeout = flag.Duration("telemetry.timeout", 10*time.Second,
 		"[optional] duration of how long to wait to receive http headers on telemetry addr (defaults: 10s)")
 
-	rtorrentAddr     = flag.String("rtorrent.addr", "", "address of rTorrent XML-RPC server")
+	webConfigFile = flag.String("web.config.file", "",
+		"[optional] path to a web configuration file, in the format of the Prometheus exporter-toolkit, enabling TLS and "+
+			"basic authentication on the telemetry addr, it is read again whenever it or its certificates change")
+	webEnableLifecycle = flag.Bool("web.enable-lifecycle", false,
+		"[optional] enable reloading the configuration with POST or PUT requests to /-/reload (defaults: false)")
+
+	rtorrentAddr = flag.String("rtorrent.addr", "",
+		"address of rTorrent XML-RPC server, use scgi://host:port or scgi+unix:///path/to/rpc.socket to talk to rTorrent's SCGI "+
+			"socket directly")
+	rtorrentSessionDir = flag.String("rtorrent.session.dir", "",
+		"[optional] read download metrics from rTorrent's session directory instead of its XML-RPC server, for when XML-RPC is "+
+			"disabled or rTorrent is hung, the session files lag behind by up to rTorrent's session save interval, requires "+
+			"'-rtorrent.downloads.collect.details=false'")
+	rtorrentAuthScheme = flag.String("rtorrent.auth-scheme", "",
+		"[optional] authentication scheme used with rTorrent XML-RPC server, one of basic, digest or bearer (defaults: basic "+
+			"when '-rtorrent.username' is given, none otherwise)")
 	rtorrentUsername = flag.String("rtorrent.username", "",
-		"[optional] username used for HTTP Basic authentication with rTorrent XML-RPC server")
+		"[optional] username used for HTTP Basic or Digest authentication with rTorrent XML-RPC server")
 	rtorrentPassword = flag.String("rtorrent.password", "",
-		"[optional] password used for HTTP Basic authentication with rTorrent XML-RPC server")
+		"[optional] password used for HTTP Basic or Digest authentication with rTorrent XML-RPC server, prefer "+
+			"'-rtorrent.password-file' as command line flags are visible to other users")
+	rtorrentPasswordFile = flag.String("rtorrent.password-file", "",
+		"[optional] path to a file holding the password used for HTTP Basic or Digest authentication with rTorrent XML-RPC "+
+			"server, it is read again whenever it changes")
+	rtorrentBearerTokenFile = flag.String("rtorrent.bearer-token-file", "",
+		"[optional] path to a file holding the token used for bearer authentication with rTorrent XML-RPC server, requires "+
+			"'-rtorrent.auth-scheme=bearer', it is read again whenever it changes")
+	rtorrentHeaders = headerVar("rtorrent.header",
+		"[optional] header added to every request to rTorrent XML-RPC server, as 'Name: value', e.g. a shared secret expected "+
+			"by a reverse proxy, may be repeated")
 	rtorrentInsecure = flag.Bool("rtorrent.insecure", false,
 		"[optional] allow using XML-RPC with a non-CA signed certificat (defaults: false)")
+	rtorrentTLSCAFile = flag.String("rtorrent.tls.ca-file", "",
+		"[optional] path to the CA certificates the rTorrent XML-RPC server's certificate is verified against, instead of the "+
+			"system's")
+	rtorrentTLSCertFile = flag.String("rtorrent.tls.cert-file", "",
+		"[optional] path to the client certificate presented to the rTorrent XML-RPC server, requires '-rtorrent.tls.key-file'")
+	rtorrentTLSKeyFile = flag.String("rtorrent.tls.key-file", "",
+		"[optional] path to the key of the client certificate presented to the rTorrent XML-RPC server")
+	rtorrentTLSServerName = flag.String("rtorrent.tls.server-name", "",
+		"[optional] host name the rTorrent XML-RPC server's certificate is verified for (defaults: the host of '-rtorrent.addr')")
+	rtorrentTLSPinnedFingerprints = flag.String("rtorrent.tls.pinned-fingerprints", "",
+		"[optional] comma separated SHA-256 fingerprints the rTorrent XML-RPC server's certificate is pinned to, they replace "+
+			"the verification against CAs unless '-rtorrent.tls.ca-file' is given")
 	rtorrentTimeout = flag.Duration("rtorrent.timeout", 10*time.Second,
 		"[optional] duration of how long to wait before timing out rtorrent request (defaults: 10s)")
+	rtorrentRetryAttempts = flag.Int("rtorrent.retry.attempts", 3,
+		"[optional] number of times an XML-RPC call which only reads from rTorrent is attempted when it fails with a transport "+
+			"error or a 5xx response, 1 disables retries (defaults: 3)")
+	rtorrentRetryInitialBackoff = flag.Duration("rtorrent.retry.initial-backoff", 100*time.Millisecond,
+		"[optional] wait before the first retry of a failed XML-RPC call, doubled for each following retry and jittered "+
+			"(defaults: 100ms)")
+	rtorrentRetryMaxBackoff = flag.Duration("rtorrent.retry.max-backoff", time.Second,
+		"[optional] bound of the wait before a retry of a failed XML-RPC call (defaults: 1s)")
+	rtorrentRetryScrapeTimeout = flag.Duration("rtorrent.retry.scrape-timeout", 10*time.Second,
+		"[optional] no retry is attempted once a collection from rTorrent ran this long, set it to Prometheus' scrape_timeout "+
+			"(defaults: 10s)")
+	rtorrentCircuitBreakerFailureThreshold = flag.Int("rtorrent.circuit-breaker.failure-threshold", 0,
+		"[optional] number of consecutive failed XML-RPC calls after which rTorrent isn't called for "+
+			"'-rtorrent.circuit-breaker.cooldown' and reported down right away (defaults: 0, disabled)")
+	rtorrentCircuitBreakerCooldown = flag.Duration("rtorrent.circuit-breaker.cooldown", 30*time.Second,
+		"[optional] how long the circuit breaker stops calling rTorrent before a single call checks on it (defaults: 30s)")
 	rtorrentDownloadsCollectDetails = flag.Bool("rtorrent.downloads.collect.details", true,
 		"[optional] collect rate and total bytes for each torrent (greatly increases metric cardinality) (defaults: true)")
+	rtorrentDownloadsCollectPeers = flag.Bool("rtorrent.downloads.collect.peers", false,
+		"[optional] collect peer connection and tracker seeder/leecher counts for each torrent, requires "+
+			"'-rtorrent.downloads.collect.details' (increases metric cardinality) (defaults: false)")
+	rtorrentDownloadsTop = flag.Int("rtorrent.downloads.top", 0,
+		"[optional] only collect details for this many downloads ranked by '-rtorrent.downloads.top.by', the others are "+
+			"summed in a single download labeled other (defaults: 0, all downloads)")
+	rtorrentDownloadsTopBy = flag.String("rtorrent.downloads.top.by", "upload_rate",
+		"[optional] ranking of the downloads collected with '-rtorrent.downloads.top', one of upload_rate, download_rate or "+
+			"activity (both rates combined)")
+	rtorrentDownloadsLegacyTotalBytes = flag.Bool("rtorrent.downloads.legacy-total-bytes", true,
+		"[optional] also report the deprecated rtorrent_downloads_download_total_bytes and rtorrent_downloads_upload_total_bytes "+
+			"gauges alongside the _bytes_total counters, for dashboards which weren't migrated yet, they will be removed in a "+
+			"future release (defaults: true)")
+	rtorrentDownloadsAggregateBy = flag.String("rtorrent.downloads.aggregate.by", "",
+		"[optional] report download counts, rates, totals and sizes aggregated by label (ruTorrent label), directory, tracker "+
+			"or view, a low cardinality alternative to '-rtorrent.downloads.collect.details' (defaults: disabled)")
+	rtorrentPollInterval = flag.Duration("rtorrent.poll.interval", 0,
+		"[optional] poll rTorrent in the background on this interval and serve scrapes the last polled snapshot, "+
+			"instead of reaching rTorrent on every scrape (defaults: 0s, disabled)")
+	rtorrentPollMaxAge = flag.Duration("rtorrent.poll.max-age", 0,
+		"[optional] age past which the polled snapshot is considered invalid and rTorrent reported as down "+
+			"(defaults: 3 times '-rtorrent.poll.interval')")
+	privacyNames = flag.String("privacy.names", "",
+		"[optional] hide download names from the metrics by replacing them with a keyed hash (hmac), their first characters "+
+			"(truncate), or dropping the name label (drop) (defaults: names are reported)")
+	privacyNamesKey = flag.String("privacy.names.key", "",
+		"[optional] secret key download names are hashed with, required by '-privacy.names=hmac'")
+	privacyNamesLength = flag.Int("privacy.names.length", 0,
+		"[optional] number of characters of the download name pseudonyms (defaults: 16 for hmac, 8 for truncate)")
+	rtorrentTrackersCollect = flag.Bool("rtorrent.trackers.collect", false,
+		"[optional] collect announce health for each tracker hostname (retrieves every tracker of every torrent) (defaults: false)")
 )
 
+// A headerFlag collects the headers of a repeated flag given as "Name: value".
+type headerFlag map[string]string
+
+// headerVar defines a headerFlag with the specified name and usage.
+func headerVar(name, usage string) headerFlag {
+	h := headerFlag{}
+	flag.Var(h, name, usage)
+	return h
+}
+
+func (h headerFlag) String() string {
+	// Values are left out, as they could be secrets
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (h headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return errors.New("must be given as 'Name: value'")
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	return nil
+}
+
+// envPrefix prefixes the environment variables flags can be set with.
+const envPrefix = "RTORRENT_EXPORTER_"
+
 func main() {
 	flag.Parse()
-
-	validateFlags()
-
-	// Optionally enable HTTP Basic authentication
-	var rt http.RoundTripper
-	authEnabled := false
-	if u, p := *rtorrentUsername, *rtorrentPassword; u != "" && p != "" {
-		rt = &authRoundTripper{
-			Username: u,
-			Password: p,
-			Transport: &http.Transport{
-				Dial: dialTimeout,
-				TLSClientConfig: &tls.Config{
-					//nolint:gosec // we don't care that this may be true, that's the point
-					InsecureSkipVerify: *rtorrentInsecure,
-				},
-			},
-		}
-		authEnabled = true
-	} else {
-		rt = &authRoundTripper{
-			Transport: &http.Transport{
-				Dial: dialTimeout,
-				TLSClientConfig: &tls.Config{
-					//nolint:gosec // we don't care that this may be true, that's the point
-					InsecureSkipVerify: *rtorrentInsecure,
-				},
-			},
-		}
+	if err := applyEnv(); err != nil {
+		log.Fatalf("invalid environment: %v", err)
 	}
 
-	c, err := rtorrent.New(*rtorrentAddr, rt)
+	r, err := reload.New(loadConfig)
 	if err != nil {
-		log.Fatalf("cannot create rTorrent client: %v", err)
+		log.Fatalf("cannot load configuration: %v", err)
 	}
+	prometheus.MustRegister(r)
+
+	cfg := r.Config()
+	logConfig(cfg)
+
+	go reloadOnSIGHUP(r)
 
-	colOpts := rtorrentexporter.CollectorOpts{
-		DownloadDetails: *rtorrentDownloadsCollectDetails,
+	ws, err := web.New(cfg.Telemetry.WebConfigFile)
+	if err != nil {
+		log.Fatalf("cannot load web configuration: %v", err)
+	}
+	log.Printf("serving telemetry (tls: %v) (basic authentication: %v)", ws.TLSEnabled(), ws.AuthEnabled())
+	if len(cfg.Privacy.Lookup.Users) > 0 && !ws.AuthEnabled() {
+		log.Printf("[WARN] privacy.lookup.users authenticate with the basic_auth_users of -web.config.file, " +
+			"/names refuses every request until they are set")
 	}
 
-	prometheus.MustRegister(rtorrentexporter.New(c, colOpts))
+	http.Handle("/probe", r.ProbeHandler())
+	http.Handle("/-/reload", r.LifecycleHandler(*webEnableLifecycle))
+	http.Handle("/names", r.NamesHandler(ws.Authenticate))
+	http.Handle(cfg.Telemetry.Path, promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, r}, promhttp.HandlerOpts{}),
+	))
 
-	http.Handle(*metricsPath, promhttp.Handler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, *metricsPath, http.StatusMovedPermanently)
+		http.Redirect(w, r, cfg.Telemetry.Path, http.StatusMovedPermanently)
 	})
 
-	log.Printf("starting rTorrent exporter on %q for server %q (telemetry timeout: %v) "+
-		"(authentication: %v) (insecure: %v) (timeout: %v) (collect download details: %v)",
-		*telemetryAddr, *rtorrentAddr, *telemetryTimeout,
-		authEnabled, *rtorrentInsecure, *rtorrentTimeout, *rtorrentDownloadsCollectDetails)
-
 	server := &http.Server{
-		Addr:              *telemetryAddr,
-		ReadHeaderTimeout: *telemetryTimeout,
+		Addr:              cfg.Telemetry.Address,
+		ReadHeaderTimeout: cfg.Telemetry.Timeout,
 	}
-	if err := server.ListenAndServe(); err != nil {
+	if err := ws.ListenAndServe(server); err != nil {
 		log.Fatalf("cannot start rTorrent exporter: %s", err)
 	}
 }
 
-func validateFlags() {
-	if *rtorrentAddr == "" {
-		log.Fatal("address of rTorrent XML-RPC server must be specified with '-rtorrent.addr' flag")
+// reloadOnSIGHUP reloads the configuration each time the process receives a
+// SIGHUP.
+func reloadOnSIGHUP(r *reload.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if err := r.Reload(); err != nil {
+			log.Printf("[ERROR] failed reloading configuration, keeping the current one: %v", err)
+			continue
+		}
+		log.Printf("reloaded configuration")
 	}
-	if *rtorrentTimeout <= 0 {
-		log.Fatal("timeout for rTorrent request must be greater than 0")
+}
+
+// applyEnv sets each flag which wasn't given on the command line from its
+// environment variable, if set. The variable of a flag is its name in upper
+// case with dots and dashes replaced by underscores, prefixed with envPrefix,
+// e.g. RTORRENT_EXPORTER_RTORRENT_PASSWORD_FILE for -rtorrent.password-file.
+func applyEnv() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+
+		name := envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(f.Name))
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			// The value isn't quoted, as it could be a secret
+			err = fmt.Errorf("%s isn't a valid value for -%s", name, f.Name)
+		}
+	})
+	return err
+}
+
+// loadConfig builds the configuration from -config.file, if given, with any
+// flags set on the command line overriding the settings of the file. Without a
+// configuration file every flag applies, defaults included, exactly as before
+// configuration files were supported. It is called again on every reload.
+func loadConfig() (*config.Config, error) {
+	cfg := config.Default()
+	visit := flag.VisitAll
+
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			return nil, fmt.Errorf("cannot load configuration file %q: %w", *configFile, err)
+		}
+		visit = flag.Visit
 	}
-	if *telemetryTimeout <= 0 {
-		log.Fatal("timeout for telemetry request must be greater than 0")
+
+	visit(func(f *flag.Flag) {
+		applyFlag(cfg, f.Name)
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	for _, w := range cfg.Warnings() {
+		log.Printf("[WARN] %s", w)
+	}
+
+	if cfg.RTorrent.Address == "" && cfg.RTorrent.SessionDirectory == "" && len(cfg.Instances) == 0 && *configFile == "" {
+		return nil, errors.New("address of rTorrent XML-RPC server must be specified with '-rtorrent.addr' flag, or its session " +
+			"directory with '-rtorrent.session.dir', or '-config.file' must be given to collect from its rtorrent address or " +
+			"instances, or only serve /probe requests")
 	}
-}
 
-var _ http.RoundTripper = &authRoundTripper{}
+	return cfg, nil
+}
 
-// An authRoundTripper is a http.RoundTripper which adds HTTP Basic authentication
-// to each HTTP request.
-type authRoundTripper struct {
-	Username  string
-	Password  string
-	Transport *http.Transport
+// applyFlag overrides the setting of cfg which corresponds to the named flag.
+func applyFlag(cfg *config.Config, name string) {
+	switch name {
+	case "telemetry.addr":
+		cfg.Telemetry.Address = *telemetryAddr
+	case "telemetry.path":
+		cfg.Telemetry.Path = *metricsPath
+	case "telemetry.timeout":
+		cfg.Telemetry.Timeout = *telemetryTimeout
+	case "web.config.file":
+		cfg.Telemetry.WebConfigFile = *webConfigFile
+	case "rtorrent.addr":
+		cfg.RTorrent.Address = *rtorrentAddr
+	case "rtorrent.session.dir":
+		cfg.RTorrent.SessionDirectory = *rtorrentSessionDir
+	case "rtorrent.auth-scheme":
+		cfg.RTorrent.AuthScheme = *rtorrentAuthScheme
+	case "rtorrent.username":
+		cfg.RTorrent.Username = *rtorrentUsername
+	case "rtorrent.password":
+		cfg.RTorrent.Password = *rtorrentPassword
+	case "rtorrent.password-file":
+		cfg.RTorrent.PasswordFile = *rtorrentPasswordFile
+	case "rtorrent.bearer-token-file":
+		cfg.RTorrent.BearerTokenFile = *rtorrentBearerTokenFile
+	case "rtorrent.header":
+		cfg.RTorrent.Headers = maps.Clone(rtorrentHeaders)
+	case "rtorrent.insecure":
+		cfg.RTorrent.TLS.InsecureSkipVerify = *rtorrentInsecure
+	case "rtorrent.tls.ca-file":
+		cfg.RTorrent.TLS.CAFile = *rtorrentTLSCAFile
+	case "rtorrent.tls.cert-file":
+		cfg.RTorrent.TLS.CertFile = *rtorrentTLSCertFile
+	case "rtorrent.tls.key-file":
+		cfg.RTorrent.TLS.KeyFile = *rtorrentTLSKeyFile
+	case "rtorrent.tls.server-name":
+		cfg.RTorrent.TLS.ServerName = *rtorrentTLSServerName
+	case "rtorrent.tls.pinned-fingerprints":
+		cfg.RTorrent.TLS.PinnedFingerprints = nil
+		if *rtorrentTLSPinnedFingerprints != "" {
+			cfg.RTorrent.TLS.PinnedFingerprints = strings.Split(*rtorrentTLSPinnedFingerprints, ",")
+		}
+	case "rtorrent.timeout":
+		cfg.RTorrent.Timeout = *rtorrentTimeout
+	case "rtorrent.retry.attempts":
+		cfg.RTorrent.Retry.Attempts = *rtorrentRetryAttempts
+	case "rtorrent.retry.initial-backoff":
+		cfg.RTorrent.Retry.InitialBackoff = *rtorrentRetryInitialBackoff
+	case "rtorrent.retry.max-backoff":
+		cfg.RTorrent.Retry.MaxBackoff = *rtorrentRetryMaxBackoff
+	case "rtorrent.retry.scrape-timeout":
+		cfg.RTorrent.Retry.ScrapeTimeout = *rtorrentRetryScrapeTimeout
+	case "rtorrent.circuit-breaker.failure-threshold":
+		cfg.RTorrent.Retry.CircuitBreaker.FailureThreshold = *rtorrentCircuitBreakerFailureThreshold
+	case "rtorrent.circuit-breaker.cooldown":
+		cfg.RTorrent.Retry.CircuitBreaker.Cooldown = *rtorrentCircuitBreakerCooldown
+	case "rtorrent.downloads.collect.details":
+		cfg.RTorrent.Collectors.DownloadDetails = rtorrentDownloadsCollectDetails
+	case "rtorrent.downloads.collect.peers":
+		cfg.RTorrent.Collectors.DownloadPeers = *rtorrentDownloadsCollectPeers
+	case "rtorrent.downloads.top":
+		cfg.RTorrent.Collectors.DownloadTop.Count = *rtorrentDownloadsTop
+	case "rtorrent.downloads.top.by":
+		cfg.RTorrent.Collectors.DownloadTop.By = *rtorrentDownloadsTopBy
+	case "rtorrent.downloads.legacy-total-bytes":
+		cfg.RTorrent.Collectors.LegacyTotalBytes = rtorrentDownloadsLegacyTotalBytes
+	case "rtorrent.downloads.aggregate.by":
+		cfg.RTorrent.Collectors.Aggregate.By = *rtorrentDownloadsAggregateBy
+	case "rtorrent.trackers.collect":
+		cfg.RTorrent.Collectors.Trackers = *rtorrentTrackersCollect
+	case "rtorrent.poll.interval":
+		cfg.Polling.Interval = *rtorrentPollInterval
+	case "rtorrent.poll.max-age":
+		cfg.Polling.MaxAge = *rtorrentPollMaxAge
+	case "privacy.names":
+		cfg.Privacy.Names = *privacyNames
+	case "privacy.names.key":
+		cfg.Privacy.Key = *privacyNamesKey
+	case "privacy.names.length":
+		cfg.Privacy.Length = *privacyNamesLength
+	}
 }
 
-func dialTimeout(network, addr string) (net.Conn, error) {
-	return net.DialTimeout(network, addr, *rtorrentTimeout)
+// logConfig logs what the exporter collects from with the provided
+// configuration. Secrets, such as the password of an address or
+// privacy.names.key, are never logged.
+func logConfig(cfg *config.Config) {
+	switch {
+	case cfg.RTorrent.Address != "":
+		target := cfg.RTorrent
+		colOpts := target.CollectorOpts()
+
+		log.Printf("starting rTorrent exporter on %q for server %q (telemetry timeout: %v) "+
+			"(authentication: %q) (headers: %d) (insecure: %v) (ca file: %q) (client certificate: %v) (pinned fingerprints: %d) "+
+			"(timeout: %v) (retry attempts: %d) (circuit breaker threshold: %d) "+
+			"(collect download details: %v) (collect download peers: %v) (collect trackers: %v) (poll interval: %v) (name privacy: %q)",
+			cfg.Telemetry.Address, redactAddress(target.Address), cfg.Telemetry.Timeout,
+			target.TransportOptions().AuthScheme(), len(target.Headers), target.TLS.InsecureSkipVerify, target.TLS.CAFile,
+			target.TLS.CertFile != "", len(target.TLS.PinnedFingerprints),
+			target.Timeout, target.Retry.Attempts, target.Retry.CircuitBreaker.FailureThreshold,
+			colOpts.DownloadDetails, colOpts.DownloadPeers, colOpts.Trackers, cfg.Polling.Interval, cfg.Privacy.Names)
+	case cfg.RTorrent.SessionDirectory != "":
+		colOpts := cfg.RTorrent.CollectorOpts()
+
+		log.Printf("starting rTorrent exporter on %q for session directory %q (telemetry timeout: %v) "+
+			"(collect download details: %v) (poll interval: %v) (name privacy: %q)",
+			cfg.Telemetry.Address, cfg.RTorrent.SessionDirectory, cfg.Telemetry.Timeout, colOpts.DownloadDetails,
+			cfg.Polling.Interval, cfg.Privacy.Names)
+	case len(cfg.Instances) > 0:
+		for _, inst := range cfg.Instances {
+			if inst.SessionDirectory != "" {
+				log.Printf("collecting from instance %q in session directory %q with module %q", inst.Name, inst.SessionDirectory, inst.Module)
+				continue
+			}
+			log.Printf("collecting from instance %q at %q with module %q", inst.Name, redactAddress(inst.Address), inst.Module)
+		}
+
+		log.Printf("starting rTorrent exporter on %q for %d instances (telemetry timeout: %v) (config file: %q)",
+			cfg.Telemetry.Address, len(cfg.Instances), cfg.Telemetry.Timeout, *configFile)
+	default:
+		log.Printf("starting rTorrent exporter on %q serving only /probe requests (telemetry timeout: %v) (config file: %q)",
+			cfg.Telemetry.Address, cfg.Telemetry.Timeout, *configFile)
+	}
 }
 
-func (rt *authRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	r.SetBasicAuth(rt.Username, rt.Password)
-	return rt.Transport.RoundTrip(r)
+// redactAddress returns addr with the password of its user information, if
+// any, replaced by "xxxxx".
+func redactAddress(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.User == nil {
+		return addr
+	}
+	return u.Redacted()
 }
@@ -0,0 +1,193 @@
+// Package bencode provides a decoder for the bencoding used by torrent files
+// and rTorrent's session files.
+package bencode
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of lists and dictionaries, so that corrupt files
+// can't exhaust the stack.
+const maxDepth = 64
+
+// A SyntaxError is a description of a bencoding syntax error along with the
+// offset it was found at.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+// Error implements error.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+// Decode decodes the single bencoded value b holds. Integers are decoded as
+// int64, byte strings as string, lists as []any and dictionaries as
+// map[string]any, the same types as rTorrent's XML-RPC values.
+func Decode(b []byte) (any, error) {
+	d := &decoder{b: b}
+
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(b) {
+		return nil, d.errorf("trailing data after value")
+	}
+
+	return v, nil
+}
+
+// DecodeDict decodes the single bencoded dictionary b holds.
+func DecodeDict(b []byte) (map[string]any, error) {
+	v, err := Decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return nil, &SyntaxError{Offset: 0, Msg: fmt.Sprintf("expected a dictionary, got %T", v)}
+	}
+	return dict, nil
+}
+
+// A decoder decodes values from b, starting at pos.
+type decoder struct {
+	b   []byte
+	pos int
+}
+
+// errorf returns a SyntaxError at the current offset.
+func (d *decoder) errorf(format string, args ...any) error {
+	return &SyntaxError{Offset: d.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// value decodes the value at the current offset, depth is the number of lists
+// and dictionaries it is nested in.
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, d.errorf("values nested deeper than %d", maxDepth)
+	}
+	if d.pos >= len(d.b) {
+		return nil, d.errorf("unexpected end of data")
+	}
+
+	switch c := d.b[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c == 'l':
+		return d.list(depth)
+	case c == 'd':
+		return d.dict(depth)
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, d.errorf("invalid character %q", c)
+	}
+}
+
+// integer decodes an integer, e.g. i42e.
+func (d *decoder) integer() (int64, error) {
+	d.pos++
+	end := d.pos
+	for end < len(d.b) && d.b[end] != 'e' {
+		end++
+	}
+	if end == len(d.b) {
+		return 0, d.errorf("unterminated integer")
+	}
+
+	s := string(d.b[d.pos:end])
+	digits := s
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if digits == "" || (digits[0] == '0' && len(s) > 1) {
+		return 0, d.errorf("invalid integer %q", s)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, d.errorf("invalid integer %q", s)
+	}
+
+	d.pos = end + 1
+	return n, nil
+}
+
+// string decodes a byte string, e.g. 4:spam.
+func (d *decoder) string() (string, error) {
+	colon := d.pos
+	for colon < len(d.b) && d.b[colon] != ':' {
+		if d.b[colon] < '0' || d.b[colon] > '9' {
+			return "", d.errorf("invalid string length")
+		}
+		colon++
+	}
+	if colon == len(d.b) {
+		return "", d.errorf("unterminated string length")
+	}
+
+	n, err := strconv.Atoi(string(d.b[d.pos:colon]))
+	if err != nil || n > len(d.b)-colon-1 {
+		return "", d.errorf("invalid string length %q", d.b[d.pos:colon])
+	}
+
+	d.pos = colon + 1 + n
+	return string(d.b[colon+1 : d.pos]), nil
+}
+
+// list decodes a list, e.g. l4:spami42ee.
+func (d *decoder) list(depth int) ([]any, error) {
+	d.pos++
+
+	l := []any{}
+	for {
+		if d.pos >= len(d.b) {
+			return nil, d.errorf("unterminated list")
+		}
+		if d.b[d.pos] == 'e' {
+			d.pos++
+			return l, nil
+		}
+
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+}
+
+// dict decodes a dictionary, e.g. d3:bar4:spam3:fooi42ee.
+func (d *decoder) dict(depth int) (map[string]any, error) {
+	d.pos++
+
+	m := make(map[string]any)
+	for {
+		if d.pos >= len(d.b) {
+			return nil, d.errorf("unterminated dictionary")
+		}
+		if d.b[d.pos] == 'e' {
+			d.pos++
+			return m, nil
+		}
+
+		if c := d.b[d.pos]; c < '0' || c > '9' {
+			return nil, d.errorf("dictionary key must be a string")
+		}
+		k, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+}
@@ -0,0 +1,77 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"i42e", int64(42)},
+		{"i-42e", int64(-42)},
+		{"i0e", int64(0)},
+		{"4:spam", "spam"},
+		{"0:", ""},
+		{"3:\x00\xff:", "\x00\xff:"},
+		{"le", []any{}},
+		{"l4:spami42ee", []any{"spam", int64(42)}},
+		{"de", map[string]any{}},
+		{"d3:bar4:spam3:fooi42ee", map[string]any{"bar": "spam", "foo": int64(42)}},
+		{"d4:listl1:a1:be4:dictd1:ki1eee", map[string]any{
+			"list": []any{"a", "b"},
+			"dict": map[string]any{"k": int64(1)},
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := Decode([]byte(tt.in))
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":                 "",
+		"unterminated integer":  "i42",
+		"empty integer":         "ie",
+		"leading zero":          "i042e",
+		"negative zero":         "i-0e",
+		"invalid integer":       "i4x2e",
+		"overflowing integer":   "i99999999999999999999e",
+		"string too long":       "5:spam",
+		"unterminated length":   "4",
+		"invalid length":        "4x:spam",
+		"unterminated list":     "l4:spam",
+		"unterminated dict":     "d3:foo",
+		"dict missing value":    "d3:fooe",
+		"non-string key":        "di1ei2ee",
+		"invalid character":     "x",
+		"trailing data":         "i42ei43e",
+		"nested too deep":       strings.Repeat("l", maxDepth+2) + strings.Repeat("e", maxDepth+2),
+		"dict with invalid val": "d3:fooxe",
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode([]byte(in))
+			var se *SyntaxError
+			assert.True(t, errors.As(err, &se), err)
+		})
+	}
+}
+
+func TestDecodeDict(t *testing.T) {
+	d, err := DecodeDict([]byte("d5:statei1ee"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"state": int64(1)}, d)
+
+	_, err = DecodeDict([]byte("l5:statee"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,705 @@
+// Package config provides the configuration file format of the
+// rtorrent_exporter.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/retry"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/aauren/rtorrent-exporter/pkg/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultModule is the module used by probes which don't specify one.
+	DefaultModule = "default"
+
+	// defaultTimeout is the default timeout of requests to rTorrent.
+	defaultTimeout = 10 * time.Second
+
+	// defaultTelemetryAddress is the default address the exporter listens on.
+	defaultTelemetryAddress = ":9135"
+
+	// defaultTelemetryPath is the default URL path metrics are served on.
+	defaultTelemetryPath = "/metrics"
+
+	// defaultTelemetryTimeout is the default time to wait for HTTP headers on
+	// the telemetry address.
+	defaultTelemetryTimeout = 10 * time.Second
+
+	// defaultRetryAttempts, defaultInitialBackoff and defaultMaxBackoff are the
+	// default retry settings of the calls to rTorrent.
+	defaultRetryAttempts  = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = time.Second
+
+	// defaultScrapeTimeout is Prometheus' default scrape timeout.
+	defaultScrapeTimeout = 10 * time.Second
+
+	// defaultBreakerCooldown is the default time the circuit breaker stays
+	// open.
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// Config is the root of the configuration file.
+type Config struct {
+	// Telemetry holds the settings of the exporter's own HTTP listener.
+	Telemetry Telemetry `yaml:"telemetry"`
+
+	// RTorrent is the single rTorrent instance collected from on each scrape of
+	// the telemetry path, the same as the -rtorrent.* flags.
+	RTorrent Target `yaml:"rtorrent"`
+
+	// Modules are the named settings which /probe requests can select with the
+	// module parameter.
+	Modules map[string]Module `yaml:"modules"`
+
+	// Instances are the rTorrent instances which are all collected from on
+	// each scrape of the telemetry path.
+	Instances []Instance `yaml:"instances"`
+
+	// Polling configures the background polling of the rtorrent address and
+	// instances, it doesn't apply to /probe requests.
+	Polling Polling `yaml:"polling"`
+
+	// Privacy hides the names of the downloads from the metrics of every
+	// target, instance and probe.
+	Privacy Privacy `yaml:"privacy"`
+}
+
+// Telemetry holds the settings of the exporter's own HTTP listener.
+type Telemetry struct {
+	Address string        `yaml:"address"`
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	// WebConfigFile is the path of a web configuration file, in the format of
+	// the Prometheus exporter-toolkit, enabling TLS and authentication.
+	WebConfigFile string `yaml:"web_config_file"`
+}
+
+// Polling configures the background polling of rTorrent. When the interval is
+// zero, the default, each scrape reaches rTorrent itself.
+type Polling struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxAge   time.Duration `yaml:"max_age"`
+}
+
+// PollOpts returns the options used to start polling with the Exporter.
+func (p Polling) PollOpts() rtorrentexporter.PollOpts {
+	return rtorrentexporter.PollOpts{
+		Interval: p.Interval,
+		MaxAge:   p.MaxAge,
+	}
+}
+
+// Privacy selects how download names are hidden from the metrics, along with
+// the credentials of the /names endpoint mapping pseudonyms back to names.
+type Privacy struct {
+	// Names is hmac, truncate or drop, names are reported as is when empty.
+	Names string `yaml:"names"`
+
+	// Key is the secret key names are hashed with.
+	Key string `yaml:"key"`
+
+	// Length is the number of characters of the pseudonyms.
+	Length int `yaml:"length"`
+
+	// Lookup selects who may use the /names endpoint.
+	Lookup Lookup `yaml:"lookup"`
+}
+
+// Lookup holds the settings of the /names endpoint.
+type Lookup struct {
+	// Users are the basic_auth_users of the web configuration file allowed to
+	// look names up, /names is disabled when empty.
+	Users []string `yaml:"users"`
+}
+
+// NamePrivacy returns the settings used to pseudonymize download names.
+func (p Privacy) NamePrivacy() rtorrentexporter.NamePrivacy {
+	return rtorrentexporter.NamePrivacy{
+		Mode:   p.Names,
+		Key:    []byte(p.Key),
+		Length: p.Length,
+	}
+}
+
+// A Target is an rTorrent address along with the settings used to connect to
+// and collect from it.
+type Target struct {
+	Address string `yaml:"address"`
+	// SessionDirectory is rTorrent's session directory, read in place of the
+	// XML-RPC address.
+	SessionDirectory string `yaml:"session_directory"`
+	Module           `yaml:",inline"`
+}
+
+// An Instance is an rTorrent instance which is collected from on each scrape of
+// the telemetry path, its metrics are labeled with its name.
+type Instance struct {
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+	// SessionDirectory is rTorrent's session directory, read in place of the
+	// XML-RPC address.
+	SessionDirectory string `yaml:"session_directory"`
+	// Module names the settings used to connect to the instance, it defaults
+	// to DefaultModule.
+	Module string `yaml:"module"`
+}
+
+// A Module holds the settings used to connect to and collect from an rTorrent
+// instance.
+type Module struct {
+	// AuthScheme is the authentication scheme, basic, digest or bearer. It
+	// defaults to basic when a username is set.
+	AuthScheme string `yaml:"auth_scheme"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	// PasswordFile holds the password in place of Password, such as a Docker
+	// or Kubernetes secret, it is read again whenever it changes.
+	PasswordFile string `yaml:"password_file"`
+	// BearerTokenFile holds the token of bearer authentication, it is read
+	// again whenever it changes.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	// Headers are added to every request, e.g. a shared secret expected by a
+	// reverse proxy.
+	Headers    map[string]string `yaml:"headers"`
+	TLS        TLSConfig         `yaml:"tls"`
+	Timeout    time.Duration     `yaml:"timeout"`
+	Retry      RetryConfig       `yaml:"retry"`
+	Collectors Collectors        `yaml:"collectors"`
+	// ProbeTargets are the only addresses /probe requests may reach with the
+	// module. They are required for /probe to use a module which sends
+	// credentials, a client certificate or headers, any target can be probed
+	// with other modules when empty.
+	ProbeTargets []string `yaml:"probe_targets"`
+}
+
+// RetryConfig holds the retry and circuit breaker settings of the XML-RPC
+// calls to rTorrent.
+type RetryConfig struct {
+	// Attempts is the number of times a call which only reads from rTorrent
+	// is attempted, 1 disables retries.
+	Attempts int `yaml:"attempts"`
+
+	// InitialBackoff is the wait before the first retry, it doubles with each
+	// following retry. MaxBackoff bounds every wait.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+
+	// ScrapeTimeout bounds the retries of a collection, no retry is attempted
+	// past it. /probe requests use Prometheus' scrape timeout instead.
+	ScrapeTimeout time.Duration `yaml:"scrape_timeout"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig holds the settings of the circuit breaker which stops
+// calling an unresponsive rTorrent for a while.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed calls which open
+	// the circuit breaker, it is disabled when zero.
+	FailureThreshold int           `yaml:"failure_threshold"`
+	Cooldown         time.Duration `yaml:"cooldown"`
+}
+
+// Opts returns the options of the retrying transport to rTorrent.
+func (r RetryConfig) Opts() retry.Opts {
+	return retry.Opts{
+		Attempts:         r.Attempts,
+		InitialBackoff:   r.InitialBackoff,
+		MaxBackoff:       r.MaxBackoff,
+		BreakerThreshold: r.CircuitBreaker.FailureThreshold,
+		BreakerCooldown:  r.CircuitBreaker.Cooldown,
+	}
+}
+
+// TLSConfig holds the TLS settings of the connection to rTorrent.
+type TLSConfig struct {
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// CAFile holds the CAs rTorrent's certificate is verified against, in
+	// place of the system's.
+	CAFile string `yaml:"ca_file"`
+
+	// CertFile and KeyFile hold the client certificate presented to rTorrent.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ServerName overrides the host name rTorrent's certificate is verified
+	// for.
+	ServerName string `yaml:"server_name"`
+
+	// PinnedFingerprints are the SHA-256 fingerprints rTorrent's certificate
+	// is pinned to, they replace the verification against CAs unless a CA file
+	// is given.
+	PinnedFingerprints []string `yaml:"pinned_fingerprints"`
+}
+
+// Collectors selects which metrics are collected from rTorrent.
+type Collectors struct {
+	// DownloadDetails defaults to true when omitted, matching the
+	// -rtorrent.downloads.collect.details flag.
+	DownloadDetails *bool `yaml:"download_details"`
+	DownloadPeers   bool  `yaml:"download_peers"`
+	Trackers        bool  `yaml:"trackers"`
+
+	// DownloadColumns are extra metrics reported for every download, they
+	// require DownloadDetails.
+	DownloadColumns []DownloadColumn `yaml:"download_columns"`
+
+	// DownloadLabels are extra labels added to the metrics of every download,
+	// they require DownloadDetails.
+	DownloadLabels []DownloadLabel `yaml:"download_labels"`
+
+	// DownloadTop limits the download details to the top downloads, it
+	// requires DownloadDetails.
+	DownloadTop DownloadTop `yaml:"download_top"`
+
+	// Aggregate reports download metrics grouped by a single dimension, as a
+	// low cardinality alternative to DownloadDetails.
+	Aggregate Aggregate `yaml:"aggregate"`
+
+	// DownloadFilters include or exclude downloads from the download details,
+	// the first matching filter decides.
+	DownloadFilters []DownloadFilter `yaml:"download_filters"`
+
+	// FilterCounts applies DownloadFilters to the download counts as well.
+	FilterCounts bool `yaml:"filter_counts"`
+
+	// LegacyTotalBytes also reports the deprecated download_total_bytes and
+	// upload_total_bytes gauges alongside their _bytes_total counters. It
+	// defaults to true until the gauges are removed, matching the
+	// -rtorrent.downloads.legacy-total-bytes flag.
+	LegacyTotalBytes *bool `yaml:"legacy_total_bytes"`
+}
+
+// A DownloadFilter includes or excludes the downloads whose field matches
+// either a regex or a glob.
+type DownloadFilter struct {
+	// Name is the rule label of the filtered downloads metric.
+	Name string `yaml:"name"`
+
+	// Action is include or exclude.
+	Action string `yaml:"action"`
+
+	// Field is name, hash, label, tracker or view.
+	Field string `yaml:"field"`
+
+	Regex string `yaml:"regex"`
+	Glob  string `yaml:"glob"`
+}
+
+// Filter returns the filter evaluated by the DownloadsCollector.
+func (f DownloadFilter) Filter() (rtorrentexporter.DownloadFilter, error) {
+	df := rtorrentexporter.DownloadFilter{
+		Rule:    f.Name,
+		Exclude: f.Action == "exclude",
+		Field:   f.Field,
+	}
+
+	switch {
+	case f.Glob != "":
+		df.Pattern = rtorrentexporter.GlobPattern(f.Glob)
+	case f.Regex != "":
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return df, err
+		}
+		df.Pattern = re
+	}
+
+	return df, nil
+}
+
+// DownloadTop limits the download details to the downloads ranked highest,
+// the others are summed in a single download labeled other.
+type DownloadTop struct {
+	// Count is the number of downloads reported, all of them when zero.
+	Count int `yaml:"count"`
+
+	// By is upload_rate, the default, download_rate or activity.
+	By string `yaml:"by"`
+}
+
+// Aggregate selects how downloads are grouped for the aggregated metrics.
+type Aggregate struct {
+	// By is label (the ruTorrent label in d.custom1), directory, command,
+	// tracker or view. Downloads aren't aggregated when it is empty.
+	By string `yaml:"by"`
+
+	// Command is the d.* command grouping the downloads when By is command.
+	Command string `yaml:"command"`
+
+	// Regex, if set, captures the group from the value of the command.
+	Regex string `yaml:"regex"`
+
+	// Label is the name of the label the groups are reported under, it
+	// defaults to By, or category when By is command.
+	Label string `yaml:"label"`
+}
+
+// Aggregation returns the aggregation collected by the AggregateCollector, it
+// is nil when downloads aren't aggregated.
+func (a Aggregate) Aggregation() (*rtorrentexporter.Aggregation, error) {
+	if a.By == "" {
+		return nil, nil
+	}
+
+	agg := &rtorrentexporter.Aggregation{
+		By: a.By,
+		Label: rtorrentexporter.DetailLabel{
+			Name:    a.By,
+			Command: a.Command,
+		},
+	}
+
+	switch a.By {
+	case "label":
+		agg.By = rtorrentexporter.AggregateByCommand
+		agg.Label.Command = "d.custom1="
+	case "directory":
+		agg.By = rtorrentexporter.AggregateByCommand
+		agg.Label.Command = "d.directory="
+	case rtorrentexporter.AggregateByCommand:
+		agg.Label.Name = "category"
+	}
+
+	if a.Label != "" {
+		agg.Label.Name = a.Label
+	}
+
+	if a.Regex != "" {
+		re, err := regexp.Compile(a.Regex)
+		if err != nil {
+			return nil, err
+		}
+		agg.Label.Regex = re
+	}
+
+	return agg, nil
+}
+
+// A DownloadLabel is a label added to the metrics of every download, sourced
+// from a d.* command and optionally a regex capture of its value.
+type DownloadLabel struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	Regex   string `yaml:"regex"`
+}
+
+// DetailLabel returns the label collected by the DownloadsCollector.
+func (l DownloadLabel) DetailLabel() (rtorrentexporter.DetailLabel, error) {
+	dl := rtorrentexporter.DetailLabel{
+		Name:    l.Name,
+		Command: l.Command,
+	}
+
+	if l.Regex != "" {
+		re, err := regexp.Compile(l.Regex)
+		if err != nil {
+			return dl, err
+		}
+		dl.Regex = re
+	}
+
+	return dl, nil
+}
+
+// A DownloadColumn is a metric reported for every download from the value of an
+// arbitrary d.* command.
+type DownloadColumn struct {
+	Command string  `yaml:"command"`
+	Name    string  `yaml:"name"`
+	Help    string  `yaml:"help"`
+	Type    string  `yaml:"type"`
+	Scale   float64 `yaml:"scale"`
+	Parser  string  `yaml:"parser"`
+}
+
+// DetailColumn returns the column collected by the DownloadsCollector.
+func (c DownloadColumn) DetailColumn() rtorrentexporter.DetailColumn {
+	col := rtorrentexporter.DetailColumn{
+		Command: c.Command,
+		Name:    c.Name,
+		Help:    c.Help,
+		Scale:   c.Scale,
+		Parser:  c.Parser,
+	}
+
+	switch c.Type {
+	case "counter":
+		col.Type = prometheus.CounterValue
+	case "gauge":
+		col.Type = prometheus.GaugeValue
+	}
+
+	return col
+}
+
+// Default returns a configuration with every setting at its default value,
+// matching the defaults of the command-line flags.
+func Default() *Config {
+	cfg := &Config{}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// DefaultModuleConfig returns the settings used for a module when none are
+// configured, they match the defaults of the command-line flags.
+func DefaultModuleConfig() Module {
+	m := Module{}
+	m.applyDefaults()
+	return m
+}
+
+// applyDefaults fills in the settings which were omitted from the
+// configuration.
+func (c *Config) applyDefaults() {
+	if c.Telemetry.Address == "" {
+		c.Telemetry.Address = defaultTelemetryAddress
+	}
+	if c.Telemetry.Path == "" {
+		c.Telemetry.Path = defaultTelemetryPath
+	}
+	if c.Telemetry.Timeout == 0 {
+		c.Telemetry.Timeout = defaultTelemetryTimeout
+	}
+
+	c.RTorrent.applyDefaults()
+
+	for name, m := range c.Modules {
+		m.applyDefaults()
+		c.Modules[name] = m
+	}
+
+	for i := range c.Instances {
+		if c.Instances[i].Module == "" {
+			c.Instances[i].Module = DefaultModule
+		}
+	}
+}
+
+// applyDefaults fills in the settings which were omitted from the module.
+func (m *Module) applyDefaults() {
+	if m.Timeout == 0 {
+		m.Timeout = defaultTimeout
+	}
+	if m.Retry.Attempts == 0 {
+		m.Retry.Attempts = defaultRetryAttempts
+	}
+	if m.Retry.InitialBackoff == 0 {
+		m.Retry.InitialBackoff = defaultInitialBackoff
+	}
+	if m.Retry.MaxBackoff == 0 {
+		m.Retry.MaxBackoff = defaultMaxBackoff
+	}
+	if m.Retry.ScrapeTimeout == 0 {
+		m.Retry.ScrapeTimeout = defaultScrapeTimeout
+	}
+	if m.Retry.CircuitBreaker.Cooldown == 0 {
+		m.Retry.CircuitBreaker.Cooldown = defaultBreakerCooldown
+	}
+	if m.Collectors.DownloadDetails == nil {
+		details := true
+		m.Collectors.DownloadDetails = &details
+	}
+	if m.Collectors.LegacyTotalBytes == nil {
+		legacy := true
+		m.Collectors.LegacyTotalBytes = &legacy
+	}
+}
+
+// TransportOptions returns the options used to build the transport to rTorrent.
+// Authentication is skipped when only one of the username and password is set,
+// as earlier versions did.
+func (m Module) TransportOptions() transport.Options {
+	opts := transport.Options{
+		Auth:            m.AuthScheme,
+		Username:        m.Username,
+		Password:        m.Password,
+		PasswordFile:    m.PasswordFile,
+		BearerTokenFile: m.BearerTokenFile,
+		Headers:         m.Headers,
+		Insecure:        m.TLS.InsecureSkipVerify,
+		Timeout:         m.Timeout,
+
+		CAFile:       m.TLS.CAFile,
+		CertFile:     m.TLS.CertFile,
+		KeyFile:      m.TLS.KeyFile,
+		ServerName:   m.TLS.ServerName,
+		Fingerprints: m.TLS.PinnedFingerprints,
+	}
+	if m.partialCredentials() {
+		opts.Username, opts.Password = "", ""
+	}
+	return opts
+}
+
+// NewClient creates an rTorrent client for the XML-RPC server at addr using the
+// connection settings of the module.
+func (m Module) NewClient(addr string) (*rtorrentrpc.Client, error) {
+	rt, err := m.NewTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return rtorrentrpc.New(addr, rt)
+}
+
+// NewTransport creates the transport to the XML-RPC server at addr which
+// NewClient sends its calls with, retrying them with the module's settings.
+func (m Module) NewTransport(addr string) (*retry.Transport, error) {
+	rt, err := transport.New(addr, m.TransportOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return retry.New(rt, m.Retry.Opts()), nil
+}
+
+// ProbeAllowed reports whether /probe requests may reach target with the
+// module. A module which sends credentials, a client certificate or headers
+// only reaches the targets listed in its ProbeTargets, so that callers can't
+// have them sent to a target of their choosing.
+func (m Module) ProbeAllowed(target string) bool {
+	if len(m.ProbeTargets) == 0 {
+		return !m.authConfigured() && m.TLS.CertFile == ""
+	}
+	return slices.Contains(m.ProbeTargets, target)
+}
+
+// downloadDetails reports whether download details are collected.
+func (m Module) downloadDetails() bool {
+	return m.Collectors.DownloadDetails != nil && *m.Collectors.DownloadDetails
+}
+
+// CollectorOpts returns the options used to build the Exporter's collectors.
+// The module must have been validated, download labels and aggregations with
+// an invalid regex are otherwise skipped.
+func (m Module) CollectorOpts() rtorrentexporter.CollectorOpts {
+	opts := rtorrentexporter.CollectorOpts{
+		DownloadDetails: m.downloadDetails(),
+		DownloadPeers:   m.Collectors.DownloadPeers,
+		Trackers:        m.Collectors.Trackers,
+		TopDownloads:    m.Collectors.DownloadTop.Count,
+		TopDownloadsBy:  m.Collectors.DownloadTop.By,
+		ScrapeTimeout:   m.Retry.ScrapeTimeout,
+	}
+
+	for _, c := range m.Collectors.DownloadColumns {
+		opts.DetailColumns = append(opts.DetailColumns, c.DetailColumn())
+	}
+
+	for _, l := range m.Collectors.DownloadLabels {
+		if dl, err := l.DetailLabel(); err == nil {
+			opts.DetailLabels = append(opts.DetailLabels, dl)
+		}
+	}
+
+	if agg, err := m.Collectors.Aggregate.Aggregation(); err == nil {
+		opts.Aggregation = agg
+	}
+
+	for _, f := range m.Collectors.DownloadFilters {
+		if df, err := f.Filter(); err == nil {
+			opts.Filters = append(opts.Filters, df)
+		}
+	}
+	opts.FilterCounts = m.Collectors.FilterCounts
+	opts.LegacyTotalBytes = m.Collectors.LegacyTotalBytes != nil && *m.Collectors.LegacyTotalBytes
+
+	return opts
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates the provided configuration. Unknown fields are
+// treated as errors in order to catch typos, and every problem found is
+// reported in the returned *ValidationError along with its line number.
+func Parse(b []byte) (*Config, error) {
+	// The raw document is kept so that problems found after decoding can still
+	// be reported with their line numbers
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	v := &validator{root: &root}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	// An empty file is a valid, empty, configuration
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		var te *yaml.TypeError
+		if !errors.As(err, &te) {
+			return nil, err
+		}
+		// Type errors don't stop decoding, so keep going in order to report
+		// them along with any other problems
+		v.problems = append(v.problems, te.Errors...)
+	}
+
+	cfg.applyDefaults()
+	cfg.validate(v)
+
+	if len(v.problems) > 0 {
+		return nil, &ValidationError{Problems: v.problems}
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration for problems which can't be tied to a line
+// of a configuration file, such as those introduced by command-line overrides.
+func (c *Config) Validate() error {
+	v := &validator{}
+	c.validate(v)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// Warnings returns the settings which are still accepted but will be refused by
+// a future release.
+func (c *Config) Warnings() []string {
+	v := &validator{}
+	c.validate(v)
+
+	return v.warnings
+}
+
+// Module returns the settings of the named module. The default module falls
+// back to DefaultModuleConfig when it isn't configured.
+func (c *Config) Module(name string) (Module, bool) {
+	if c != nil {
+		if m, ok := c.Modules[name]; ok {
+			return m, true
+		}
+	}
+	if name == DefaultModule {
+		return DefaultModuleConfig(), true
+	}
+	return Module{}, false
+}
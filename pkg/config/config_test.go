@@ -0,0 +1,638 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/retry"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+modules:
+  seedbox:
+    username: admin
+    password: secret
+    tls:
+      insecure_skip_verify: true
+    timeout: 5s
+    collectors:
+      download_peers: true
+      trackers: true
+  minimal:
+    collectors:
+      download_details: false
+`))
+	assert.Nil(t, err)
+
+	seedbox, ok := cfg.Module("seedbox")
+	assert.True(t, ok)
+	assert.Equal(t, "admin", seedbox.TransportOptions().Username)
+	assert.True(t, seedbox.TransportOptions().Insecure)
+	assert.Equal(t, 5*time.Second, seedbox.TransportOptions().Timeout)
+	assert.Equal(t, rtorrentexporter.CollectorOpts{
+		DownloadDetails: true, DownloadPeers: true, Trackers: true, ScrapeTimeout: defaultScrapeTimeout, LegacyTotalBytes: true,
+	}, seedbox.CollectorOpts())
+
+	minimal, ok := cfg.Module("minimal")
+	assert.True(t, ok)
+	assert.Equal(t, defaultTimeout, minimal.Timeout)
+	assert.Equal(t, rtorrentexporter.CollectorOpts{ScrapeTimeout: defaultScrapeTimeout, LegacyTotalBytes: true}, minimal.CollectorOpts())
+}
+
+func TestParseDownloadColumns(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  collectors:
+    download_columns:
+      - command: d.ratio=
+        name: ratio
+        help: Upload ratio.
+        scale: 0.001
+      - command: d.custom=seedtime
+        name: seedtime_seconds
+        type: counter
+`))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []rtorrentexporter.DetailColumn{
+		{Command: "d.ratio=", Name: "ratio", Help: "Upload ratio.", Scale: 0.001},
+		{Command: "d.custom=seedtime", Name: "seedtime_seconds", Type: prometheus.CounterValue},
+	}, cfg.RTorrent.CollectorOpts().DetailColumns)
+}
+
+func TestParseDownloadLabels(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  collectors:
+    download_labels:
+      - name: label
+        command: d.custom1=
+      - name: category
+        command: d.directory=
+        regex: ^/data/([^/]+)/
+`))
+	assert.Nil(t, err)
+
+	labels := cfg.RTorrent.CollectorOpts().DetailLabels
+	assert.Len(t, labels, 2)
+	assert.Equal(t, rtorrentexporter.DetailLabel{Name: "label", Command: "d.custom1="}, labels[0])
+	assert.Equal(t, "d.directory=", labels[1].Command)
+	assert.Equal(t, "^/data/([^/]+)/", labels[1].Regex.String())
+}
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want rtorrentexporter.Aggregation
+	}{
+		{"by: label", rtorrentexporter.Aggregation{
+			By: rtorrentexporter.AggregateByCommand, Label: rtorrentexporter.DetailLabel{Name: "label", Command: "d.custom1="},
+		}},
+		{"by: tracker", rtorrentexporter.Aggregation{
+			By: rtorrentexporter.AggregateByTracker, Label: rtorrentexporter.DetailLabel{Name: "tracker"},
+		}},
+		{"by: view\n      label: rtorrent_view", rtorrentexporter.Aggregation{
+			By: rtorrentexporter.AggregateByView, Label: rtorrentexporter.DetailLabel{Name: "rtorrent_view"},
+		}},
+		{"by: command\n      command: d.custom=category", rtorrentexporter.Aggregation{
+			By: rtorrentexporter.AggregateByCommand, Label: rtorrentexporter.DetailLabel{Name: "category", Command: "d.custom=category"},
+		}},
+	}
+
+	for _, tt := range tests {
+		cfg, err := Parse([]byte("rtorrent:\n  collectors:\n    aggregate:\n      " + tt.in + "\n"))
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, &tt.want, cfg.RTorrent.CollectorOpts().Aggregation, tt.in)
+	}
+
+	cfg, err := Parse([]byte("rtorrent:\n  collectors:\n    aggregate:\n      by: directory\n      regex: ^/data/([^/]+)\n"))
+	assert.Nil(t, err)
+	agg := cfg.RTorrent.CollectorOpts().Aggregation
+	assert.Equal(t, "d.directory=", agg.Label.Command)
+	assert.Equal(t, "directory", agg.Label.Name)
+	assert.Equal(t, "^/data/([^/]+)", agg.Label.Regex.String())
+
+	assert.Nil(t, DefaultModuleConfig().CollectorOpts().Aggregation)
+}
+
+func TestParseDownloadTop(t *testing.T) {
+	cfg, err := Parse([]byte("rtorrent:\n  collectors:\n    download_top:\n      count: 20\n      by: activity\n"))
+	assert.Nil(t, err)
+
+	opts := cfg.RTorrent.CollectorOpts()
+	assert.Equal(t, 20, opts.TopDownloads)
+	assert.True(t, opts.LegacyTotalBytes)
+	assert.Equal(t, rtorrentexporter.TopByActivity, opts.TopDownloadsBy)
+}
+
+func TestParseDownloadFilters(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  collectors:
+    filter_counts: true
+    download_filters:
+      - name: no-linux
+        action: exclude
+        field: label
+        glob: linux*
+      - name: private
+        action: include
+        field: tracker
+        regex: \.example\.org$
+`))
+	assert.Nil(t, err)
+
+	opts := cfg.RTorrent.CollectorOpts()
+	assert.True(t, opts.FilterCounts)
+	if assert.Len(t, opts.Filters, 2) {
+		assert.Equal(t, "no-linux", opts.Filters[0].Rule)
+		assert.True(t, opts.Filters[0].Exclude)
+		assert.Equal(t, rtorrentexporter.FilterByLabel, opts.Filters[0].Field)
+		assert.True(t, opts.Filters[0].Pattern.MatchString("linux-isos"))
+		assert.False(t, opts.Filters[1].Exclude)
+		assert.Equal(t, `\.example\.org$`, opts.Filters[1].Pattern.String())
+	}
+}
+
+func TestParsePrivacy(t *testing.T) {
+	cfg, err := Parse([]byte("privacy:\n  names: hmac\n  key: secret\n  length: 12\n  lookup:\n    users: [admin]\n"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, rtorrentexporter.NamePrivacy{Mode: rtorrentexporter.NamesHMAC, Key: []byte("secret"), Length: 12},
+		cfg.Privacy.NamePrivacy())
+	assert.Equal(t, Lookup{Users: []string{"admin"}}, cfg.Privacy.Lookup)
+
+	// Part of the directory doesn't hold download names
+	_, err = Parse([]byte("privacy:\n  names: drop\nrtorrent:\n  collectors:\n    aggregate:\n      by: directory\n      regex: ^/data/([^/]+)\n"))
+	assert.Nil(t, err)
+}
+
+func TestParseEmpty(t *testing.T) {
+	cfg, err := Parse(nil)
+	assert.Nil(t, err)
+
+	m, ok := cfg.Module(DefaultModule)
+	assert.True(t, ok)
+	assert.Equal(t, DefaultModuleConfig(), m)
+
+	_, ok = cfg.Module("missing")
+	assert.False(t, ok)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":     "modules:\n  a:\n    usrename: admin\n",
+		"invalid timeout":   "modules:\n  a:\n    timeout: soon\n",
+		"negative timeout":  "modules:\n  a:\n    timeout: -1s\n",
+		"peers w/o details": "modules:\n  a:\n    collectors:\n      download_details: false\n      download_peers: true\n",
+		"partial auth":      "modules:\n  a:\n    username: admin\n    auth_scheme: digest\n",
+		"negative interval": "polling:\n  interval: -1s\n",
+		"max age too short": "polling:\n  interval: 30s\n  max_age: 10s\n",
+		"column w/o details": "modules:\n  a:\n    collectors:\n      download_details: false\n" +
+			"      download_columns:\n        - {command: d.size_bytes=, name: size_bytes}\n",
+		"column bad type":    "modules:\n  a:\n    collectors:\n      download_columns:\n        - {command: d.ratio=, name: r, type: histogram}\n",
+		"column bad command": "modules:\n  a:\n    collectors:\n      download_columns:\n        - {command: size, name: size}\n",
+		"column built-in":    "modules:\n  a:\n    collectors:\n      download_columns:\n        - {command: d.down.rate=, name: download_rate_bytes}\n",
+		"label bad regex":    "modules:\n  a:\n    collectors:\n      download_labels:\n        - {name: c, command: d.directory=, regex: '('}\n",
+		"label bad command":  "modules:\n  a:\n    collectors:\n      download_labels:\n        - {name: c, command: d.ratio=}\n",
+		"label duplicate": "modules:\n  a:\n    collectors:\n      download_labels:\n" +
+			"        - {name: c, command: d.custom1=}\n        - {name: c, command: d.custom2=}\n",
+		"aggregate unknown": "rtorrent:\n  collectors:\n    aggregate:\n      by: ratio\n",
+		"aggregate command": "rtorrent:\n  collectors:\n    aggregate:\n      by: label\n      command: d.custom2=\n",
+		"aggregate no cmd":  "rtorrent:\n  collectors:\n    aggregate:\n      by: command\n",
+		"aggregate regex":   "rtorrent:\n  collectors:\n    aggregate:\n      by: tracker\n      regex: x\n",
+		"top negative":      "rtorrent:\n  collectors:\n    download_top:\n      count: -1\n",
+		"top by unknown":    "rtorrent:\n  collectors:\n    download_top:\n      count: 5\n      by: ratio\n",
+		"top w/o details":   "rtorrent:\n  collectors:\n    download_details: false\n    download_top:\n      count: 5\n",
+		"column duplicate": "modules:\n  a:\n    collectors:\n      download_columns:\n" +
+			"        - {command: d.ratio=, name: r}\n        - {command: d.size_bytes=, name: r}\n",
+		"filter bad action": "rtorrent:\n  collectors:\n    download_filters:\n      - {name: a, action: drop, field: name, glob: x}\n",
+		"filter bad field":  "rtorrent:\n  collectors:\n    download_filters:\n      - {name: a, action: exclude, field: ratio, glob: x}\n",
+		"filter no pattern": "rtorrent:\n  collectors:\n    download_filters:\n      - {name: a, action: exclude, field: name}\n",
+		"filter both":       "rtorrent:\n  collectors:\n    download_filters:\n      - {name: a, action: exclude, field: name, glob: x, regex: x}\n",
+		"filter bad regex":  "rtorrent:\n  collectors:\n    download_filters:\n      - {name: a, action: exclude, field: name, regex: '('}\n",
+		"filter no name":    "rtorrent:\n  collectors:\n    download_filters:\n      - {action: exclude, field: name, glob: x}\n",
+		"filter counts":     "rtorrent:\n  collectors:\n    filter_counts: true\n",
+		"privacy unknown":   "privacy:\n  names: rot13\n",
+		"privacy no key":    "privacy:\n  names: hmac\n",
+		"privacy length":    "privacy:\n  names: truncate\n  length: -1\n",
+		"privacy base path": "privacy:\n  names: drop\nrtorrent:\n  collectors:\n    download_labels:\n      - {name: p, command: d.base_path=}\n",
+		"privacy directory": "privacy:\n  names: drop\nmodules:\n  a:\n    collectors:\n      aggregate:\n        by: directory\n",
+		"lookup empty user": "privacy:\n  names: truncate\n  lookup:\n    users: ['']\n",
+		"lookup w/o names":  "privacy:\n  names: drop\n  lookup:\n    users: [admin]\n",
+		"names path":        "telemetry:\n  path: /names\n",
+		"probe target":      "modules:\n  a:\n    probe_targets: [ftp://seedbox.example.org/RPC2]\n",
+		"filter duplicate": "rtorrent:\n  collectors:\n    download_filters:\n" +
+			"      - {name: a, action: exclude, field: name, glob: x}\n      - {name: a, action: include, field: hash, glob: y}\n",
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(in))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParseInstances(t *testing.T) {
+	cfg, err := Parse([]byte(`
+modules:
+  seedbox:
+    timeout: 5s
+instances:
+  - name: seedbox1
+    address: https://seedbox1.example.org/RPC2
+    module: seedbox
+  - name: seedbox2
+    address: scgi://seedbox2.example.org:5000
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Instance{
+		{Name: "seedbox1", Address: "https://seedbox1.example.org/RPC2", Module: "seedbox"},
+		{Name: "seedbox2", Address: "scgi://seedbox2.example.org:5000", Module: DefaultModule},
+	}, cfg.Instances)
+}
+
+func TestParseInstancesErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing name":    "instances:\n  - address: http://a/RPC2\n",
+		"missing address": "instances:\n  - name: a\n",
+		"duplicate name":  "instances:\n  - name: a\n    address: http://a/RPC2\n  - name: a\n    address: http://b/RPC2\n",
+		"unknown module":  "instances:\n  - name: a\n    address: http://a/RPC2\n    module: missing\n",
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(in))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParseTLS(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  address: https://rtorrent.internal/RPC2
+  tls:
+    ca_file: /etc/rtorrent-exporter/ca.crt
+    cert_file: /etc/rtorrent-exporter/client.crt
+    key_file: /etc/rtorrent-exporter/client.key
+    server_name: rtorrent.example.org
+    pinned_fingerprints:
+      - ` + strings.Repeat("AB:", 31) + `AB
+`))
+	assert.Nil(t, err)
+
+	opts := cfg.RTorrent.TransportOptions()
+	assert.Equal(t, "/etc/rtorrent-exporter/ca.crt", opts.CAFile)
+	assert.Equal(t, "/etc/rtorrent-exporter/client.crt", opts.CertFile)
+	assert.Equal(t, "/etc/rtorrent-exporter/client.key", opts.KeyFile)
+	assert.Equal(t, "rtorrent.example.org", opts.ServerName)
+	assert.Len(t, opts.Fingerprints, 1)
+	assert.True(t, opts.TLSEnabled())
+}
+
+func TestParseTLSErrors(t *testing.T) {
+	_, err := Parse([]byte(`rtorrent:
+  address: https://rtorrent.internal/RPC2
+  tls:
+    insecure_skip_verify: true
+    cert_file: client.crt
+    pinned_fingerprints: [abcd]
+`))
+	var ve *ValidationError
+	assert.ErrorAs(t, err, &ve)
+	assert.ElementsMatch(t, []string{
+		"line 5: rtorrent.tls.cert_file: cert_file and key_file must be set together",
+		"line 4: rtorrent.tls.insecure_skip_verify: cannot be combined with ca_file or pinned_fingerprints",
+		`line 6: rtorrent.tls.pinned_fingerprints[0]: invalid SHA-256 fingerprint "abcd"`,
+	}, ve.Problems)
+
+	_, err = Parse([]byte(`rtorrent:
+  address: http://rtorrent.internal/RPC2
+  tls:
+    ca_file: ca.crt
+`))
+	assert.ErrorContains(t, err, `line 4: rtorrent.tls: only applies to https addresses, not "http://rtorrent.internal/RPC2"`)
+
+	_, err = Parse([]byte(`rtorrent:
+  address: scgi://127.0.0.1:5000
+  tls:
+    server_name: rtorrent.example.org
+`))
+	assert.ErrorContains(t, err, "authentication, headers and tls only apply to HTTP(S) addresses")
+
+	// Insecure was always accepted with plain HTTP addresses
+	_, err = Parse([]byte(`rtorrent:
+  address: http://rtorrent.internal/RPC2
+  tls:
+    insecure_skip_verify: true
+`))
+	assert.Nil(t, err)
+}
+
+func TestParsePasswordFile(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  address: https://rtorrent.internal/RPC2
+  username: admin
+  password_file: /run/secrets/rtorrent_password
+`))
+	assert.Nil(t, err)
+
+	opts := cfg.RTorrent.TransportOptions()
+	assert.Equal(t, "/run/secrets/rtorrent_password", opts.PasswordFile)
+	assert.True(t, opts.AuthEnabled())
+
+	tests := map[string]string{
+		"password and file":  "rtorrent:\n  username: admin\n  password: secret\n  password_file: /run/secrets/password\n",
+		"file without user":  "rtorrent:\n  password_file: /run/secrets/password\n",
+		"file with SCGI":     "rtorrent:\n  address: scgi://127.0.0.1:5000\n  username: admin\n  password_file: /run/secrets/password\n",
+		"file with sessions": "rtorrent:\n  session_directory: /session\n  username: admin\n  password_file: /run/secrets/password\n",
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(in))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParsePartialCredentials(t *testing.T) {
+	// Earlier versions skipped authentication when only one of the username
+	// and password was set, which is only warned about for now
+	for _, in := range []string{"rtorrent:\n  username: admin\n", "rtorrent:\n  password: secret\n"} {
+		cfg, err := Parse([]byte(in))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"rtorrent.username: username and password must be set together, authentication is skipped and a future release will " +
+				"refuse to start",
+		}, cfg.Warnings())
+		assert.False(t, cfg.RTorrent.TransportOptions().AuthEnabled())
+	}
+
+	cfg, err := Parse([]byte("rtorrent:\n  username: admin\n  password: secret\n"))
+	assert.Nil(t, err)
+	assert.Empty(t, cfg.Warnings())
+	assert.True(t, cfg.RTorrent.TransportOptions().AuthEnabled())
+}
+
+func TestParseAuthScheme(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  address: https://rtorrent.internal/RPC2
+  auth_scheme: bearer
+  bearer_token_file: /run/secrets/token
+  headers:
+    X-Shared-Secret: hunter2
+`))
+	assert.Nil(t, err)
+
+	opts := cfg.RTorrent.TransportOptions()
+	assert.Equal(t, "bearer", opts.AuthScheme())
+	assert.Equal(t, "/run/secrets/token", opts.BearerTokenFile)
+	assert.Equal(t, map[string]string{"X-Shared-Secret": "hunter2"}, opts.Headers)
+
+	cfg, err = Parse([]byte("rtorrent:\n  auth_scheme: digest\n  username: admin\n  password: secret\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "digest", cfg.RTorrent.TransportOptions().AuthScheme())
+
+	_, err = Parse([]byte("rtorrent:\n  headers:\n    Authorization: Bearer static\n"))
+	assert.Nil(t, err)
+
+	tests := map[string]struct {
+		in, want string
+	}{
+		"unknown scheme": {
+			"rtorrent:\n  auth_scheme: ntlm\n",
+			`line 2: rtorrent.auth_scheme: must be basic, digest or bearer, not "ntlm"`,
+		},
+		"digest without credentials": {
+			"rtorrent:\n  auth_scheme: digest\n",
+			"line 2: rtorrent.auth_scheme: digest requires username and password or password_file",
+		},
+		"bearer without file": {
+			"rtorrent:\n  auth_scheme: bearer\n",
+			"line 2: rtorrent.auth_scheme: bearer requires bearer_token_file",
+		},
+		"bearer with username": {
+			"rtorrent:\n  auth_scheme: bearer\n  bearer_token_file: /token\n  username: admin\n  password: secret\n",
+			"line 2: rtorrent.auth_scheme: bearer doesn't use username, password or password_file",
+		},
+		"token file without bearer": {
+			"rtorrent:\n  bearer_token_file: /token\n",
+			"line 2: rtorrent.bearer_token_file: requires auth_scheme bearer",
+		},
+		"invalid header name": {
+			"rtorrent:\n  headers:\n    X Secret: hunter2\n",
+			`line 3: rtorrent.headers.X Secret: invalid header name "X Secret"`,
+		},
+		"authorization header with scheme": {
+			"rtorrent:\n  username: admin\n  password: secret\n  headers:\n    authorization: Bearer static\n",
+			"line 5: rtorrent.headers.authorization: the Authorization header can't be set along with an authentication scheme",
+		},
+		"headers with SCGI": {
+			"rtorrent:\n  address: scgi://127.0.0.1:5000\n  headers:\n    X-Shared-Secret: hunter2\n",
+			"line 2: rtorrent: authentication, headers and tls only apply to HTTP(S) addresses",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.in))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestParseRetry(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  retry:
+    attempts: 5
+    initial_backoff: 50ms
+    scrape_timeout: 25s
+    circuit_breaker:
+      failure_threshold: 3
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, retry.Opts{
+		Attempts:         5,
+		InitialBackoff:   50 * time.Millisecond,
+		MaxBackoff:       defaultMaxBackoff,
+		BreakerThreshold: 3,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}, cfg.RTorrent.Retry.Opts())
+	assert.Equal(t, 25*time.Second, cfg.RTorrent.CollectorOpts().ScrapeTimeout)
+
+	// Retries and the circuit breaker are respectively enabled and disabled
+	// by default
+	m := DefaultModuleConfig()
+	assert.Equal(t, defaultRetryAttempts, m.Retry.Attempts)
+	assert.Zero(t, m.Retry.CircuitBreaker.FailureThreshold)
+
+	_, err = Parse([]byte(`
+rtorrent:
+  retry:
+    attempts: -1
+    initial_backoff: 2s
+    max_backoff: -1s
+    circuit_breaker:
+      failure_threshold: -2
+      cooldown: -1s
+`))
+	assert.ErrorContains(t, err, "line 4: rtorrent.retry.attempts: must be at least 1")
+	assert.ErrorContains(t, err, "line 6: rtorrent.retry.max_backoff: must be greater than 0")
+	assert.ErrorContains(t, err, "line 8: rtorrent.retry.circuit_breaker.failure_threshold: must not be negative")
+	assert.ErrorContains(t, err, "line 9: rtorrent.retry.circuit_breaker.cooldown: must be greater than 0")
+}
+
+func TestParseSessionDirectory(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rtorrent:
+  session_directory: /var/lib/rtorrent/session
+  collectors:
+    download_details: false
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/lib/rtorrent/session", cfg.RTorrent.SessionDirectory)
+
+	cfg, err = Parse([]byte(`
+modules:
+  session:
+    collectors:
+      download_details: false
+instances:
+  - name: seedbox1
+    address: https://seedbox1.example.org/RPC2
+  - name: seedbox2
+    module: session
+    session_directory: /srv/seedbox2/session
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/srv/seedbox2/session", cfg.Instances[1].SessionDirectory)
+}
+
+func TestParseSessionDirectoryErrors(t *testing.T) {
+	_, err := Parse([]byte(`rtorrent:
+  address: http://127.0.0.1/RPC2
+  session_directory: /var/lib/rtorrent/session
+`))
+	assert.ErrorContains(t, err, "line 3: rtorrent.session_directory: cannot be combined with address")
+
+	_, err = Parse([]byte(`rtorrent:
+  session_directory: /var/lib/rtorrent/session
+  username: admin
+  password: secret
+  collectors:
+    download_peers: true
+    trackers: true
+`))
+	var ve *ValidationError
+	assert.ErrorAs(t, err, &ve)
+	assert.ElementsMatch(t, []string{
+		"line 2: rtorrent: authentication, headers and tls don't apply to a session directory",
+		"line 6: rtorrent.collectors.download_details: rates aren't saved in a session directory, so no download is ever active, set to false",
+		"line 6: rtorrent.collectors.download_peers: peers aren't saved in a session directory",
+		"line 7: rtorrent.collectors.trackers: tracker announces aren't saved in a session directory",
+	}, ve.Problems)
+
+	_, err = Parse([]byte(`rtorrent:
+  session_directory: /var/lib/rtorrent/session
+instances:
+  - name: a
+    address: http://a/RPC2
+`))
+	assert.ErrorContains(t, err, "rtorrent.session_directory: cannot be combined with instances")
+
+	// Download details are enabled by default
+	_, err = Parse([]byte(`instances:
+  - name: a
+    session_directory: /srv/a/session
+`))
+	assert.ErrorContains(t, err, "line 2: instances[0].collectors.download_details: rates aren't saved in a session directory")
+
+	_, err = Parse([]byte(`instances:
+  - name: a
+    address: http://a/RPC2
+    session_directory: /srv/a/session
+`))
+	assert.ErrorContains(t, err, "line 4: instances[0].session_directory: cannot be combined with address")
+}
+
+func TestNilConfigModule(t *testing.T) {
+	var cfg *Config
+	m, ok := cfg.Module(DefaultModule)
+	assert.True(t, ok)
+	assert.Equal(t, DefaultModuleConfig(), m)
+}
+
+func TestParseReportsEveryProblemWithLine(t *testing.T) {
+	_, err := Parse([]byte(`telemetry:
+  path: metrics
+  timeout: -1s
+rtorrent:
+  address: ftp://127.0.0.1
+  usrename: admin
+modules:
+  seedbox:
+    timeout: 0s
+    collectors:
+      download_details: false
+      download_peers: true
+instances:
+  - name: seedbox1
+    address: scgi://127.0.0.1:5000
+    module: seedbox
+`))
+
+	var ve *ValidationError
+	assert.ErrorAs(t, err, &ve)
+	assert.ElementsMatch(t, []string{
+		"line 6: field usrename not found in type config.Target",
+		"line 2: telemetry.path: must start with /",
+		"line 3: telemetry.timeout: must be greater than 0",
+		`line 5: rtorrent.address: address "ftp://127.0.0.1" must use one of the http, https, scgi or scgi+unix schemes`,
+		"line 5: rtorrent.address: cannot be combined with instances",
+		"line 12: modules.seedbox.collectors.download_peers: requires collectors.download_details to be enabled",
+	}, ve.Problems)
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse([]byte("modules: [\n"))
+	assert.ErrorContains(t, err, "line")
+}
+
+func TestParseSCGIWithAuth(t *testing.T) {
+	_, err := Parse([]byte(`rtorrent:
+  address: scgi+unix:///run/rtorrent/rpc.socket
+  username: admin
+  password: secret
+`))
+	assert.ErrorContains(t, err, "line 2: rtorrent: authentication, headers and tls only apply to HTTP(S) addresses")
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Default()
+	assert.Nil(t, cfg.Validate())
+
+	cfg.RTorrent.Timeout = 0
+	assert.ErrorContains(t, cfg.Validate(), "rtorrent.timeout: must be greater than 0")
+}
+
+func TestModule_ProbeAllowed(t *testing.T) {
+	const target = "https://seedbox.example.org/RPC2"
+
+	assert.True(t, DefaultModuleConfig().ProbeAllowed(target))
+	assert.False(t, Module{Username: "admin", Password: "secret"}.ProbeAllowed(target))
+	assert.False(t, Module{BearerTokenFile: "/run/secrets/token"}.ProbeAllowed(target))
+	assert.False(t, Module{TLS: TLSConfig{CertFile: "client.crt", KeyFile: "client.key"}}.ProbeAllowed(target))
+
+	listed := Module{Username: "admin", Password: "secret", ProbeTargets: []string{target}}
+	assert.True(t, listed.ProbeAllowed(target))
+	assert.False(t, listed.ProbeAllowed("https://attacker.example.org/RPC2"))
+}
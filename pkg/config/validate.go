@@ -0,0 +1,522 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/aauren/rtorrent-exporter/pkg/scgi"
+	"github.com/aauren/rtorrent-exporter/pkg/transport"
+	"gopkg.in/yaml.v3"
+)
+
+// A ValidationError holds every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d problem(s) found in configuration:\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// ValidateAddress checks that addr is an address rTorrent can be reached at.
+func ValidateAddress(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", scgi.Scheme, scgi.UnixScheme:
+		return nil
+	default:
+		return fmt.Errorf("address %q must use one of the http, https, %s or %s schemes", addr, scgi.Scheme, scgi.UnixScheme)
+	}
+}
+
+// A validator collects the problems found in a configuration, along with the
+// warnings about settings which are still accepted but will be refused by a
+// future release. When the configuration was parsed from a file, root holds its
+// YAML document so that problems can be reported with the line they occur on.
+type validator struct {
+	root     *yaml.Node
+	problems []string
+	warnings []string
+}
+
+// errorf records a problem with the setting at path, which is made up of
+// mapping keys (strings) and sequence indexes (ints).
+func (v *validator) errorf(path []any, format string, args ...any) {
+	v.problems = append(v.problems, v.message(path, format, args...))
+}
+
+// warnf records a warning about the setting at path.
+func (v *validator) warnf(path []any, format string, args ...any) {
+	v.warnings = append(v.warnings, v.message(path, format, args...))
+}
+
+// message formats a problem or warning with the setting at path.
+func (v *validator) message(path []any, format string, args ...any) string {
+	msg := fmt.Sprintf("%s: %s", formatPath(path), fmt.Sprintf(format, args...))
+	if line := v.line(path); line > 0 {
+		msg = fmt.Sprintf("line %d: %s", line, msg)
+	}
+	return msg
+}
+
+// line returns the line of the setting at path. If the setting isn't present in
+// the document, for instance because it was left to its default, the line of
+// its closest parent is returned instead. Zero is returned when there is no
+// document.
+func (v *validator) line(path []any) int {
+	if v.root == nil || len(v.root.Content) == 0 {
+		return 0
+	}
+
+	n := v.root.Content[0]
+	line := n.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == p {
+						next = n.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && p < len(n.Content) {
+				next = n.Content[p]
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		n = next
+		line = n.Line
+	}
+
+	return line
+}
+
+// formatPath formats path the way it would be written in dotted notation, e.g.
+// instances[1].module
+func formatPath(path []any) string {
+	var b strings.Builder
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(p)
+		case int:
+			b.WriteString("[" + strconv.Itoa(p) + "]")
+		}
+	}
+	return b.String()
+}
+
+// with returns a copy of path with the provided elements appended, so that
+// sibling paths never share a backing array.
+func with(path []any, elems ...any) []any {
+	p := make([]any, 0, len(path)+len(elems))
+	p = append(p, path...)
+	return append(p, elems...)
+}
+
+// validate records every problem found in the configuration with v.
+func (c *Config) validate(v *validator) {
+	telemetry := []any{"telemetry"}
+	if !strings.HasPrefix(c.Telemetry.Path, "/") {
+		v.errorf(with(telemetry, "path"), "must start with /")
+	}
+	switch c.Telemetry.Path {
+	case "/probe":
+		v.errorf(with(telemetry, "path"), "/probe is reserved for probe requests")
+	case "/names":
+		v.errorf(with(telemetry, "path"), "/names is reserved for download name lookups")
+	}
+	if c.Telemetry.Timeout <= 0 {
+		v.errorf(with(telemetry, "timeout"), "must be greater than 0")
+	}
+
+	polling := []any{"polling"}
+	if c.Polling.Interval < 0 {
+		v.errorf(with(polling, "interval"), "must not be negative")
+	}
+	if c.Polling.MaxAge < 0 {
+		v.errorf(with(polling, "max_age"), "must not be negative")
+	} else if c.Polling.MaxAge > 0 && c.Polling.MaxAge < c.Polling.Interval {
+		v.errorf(with(polling, "max_age"), "must not be shorter than polling.interval")
+	}
+
+	privacy := []any{"privacy"}
+	if err := rtorrentexporter.ValidateNamePrivacy(c.Privacy.NamePrivacy()); err != nil {
+		v.errorf(privacy, "%v", err)
+	}
+	lookup := c.Privacy.Lookup
+	for i, user := range lookup.Users {
+		if user == "" {
+			v.errorf(with(privacy, "lookup", "users", i), "must not be empty")
+		}
+	}
+	if len(lookup.Users) > 0 && c.Privacy.Names != rtorrentexporter.NamesHMAC && c.Privacy.Names != rtorrentexporter.NamesTruncate {
+		v.errorf(with(privacy, "lookup"), "requires privacy.names to be %s or %s", rtorrentexporter.NamesHMAC, rtorrentexporter.NamesTruncate)
+	}
+
+	rtorrent := []any{"rtorrent"}
+	c.RTorrent.Module.validate(v, rtorrent)
+	switch {
+	case c.RTorrent.Address != "" && c.RTorrent.SessionDirectory != "":
+		v.errorf(with(rtorrent, "session_directory"), "cannot be combined with address")
+	case c.RTorrent.Address != "":
+		if err := ValidateAddress(c.RTorrent.Address); err != nil {
+			v.errorf(with(rtorrent, "address"), "%v", err)
+		} else {
+			c.RTorrent.Module.validateFor(v, rtorrent, c.RTorrent.Address)
+		}
+		if len(c.Instances) > 0 {
+			v.errorf(with(rtorrent, "address"), "cannot be combined with instances")
+		}
+	case c.RTorrent.SessionDirectory != "":
+		c.RTorrent.Module.validateSession(v, rtorrent)
+		if len(c.Instances) > 0 {
+			v.errorf(with(rtorrent, "session_directory"), "cannot be combined with instances")
+		}
+	}
+
+	names := make([]string, 0, len(c.Modules))
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.Modules[name].validate(v, []any{"modules", name})
+	}
+
+	if c.Privacy.Names != "" {
+		c.RTorrent.Module.validateNamePrivacy(v, rtorrent)
+		for _, name := range names {
+			c.Modules[name].validateNamePrivacy(v, []any{"modules", name})
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, inst := range c.Instances {
+		c.validateInstance(v, []any{"instances", i}, inst, seen)
+	}
+}
+
+// validate records every problem found in the module's settings with v, path
+// is the location of the module in the configuration.
+func (m Module) validate(v *validator, path []any) {
+	if m.Timeout <= 0 {
+		v.errorf(with(path, "timeout"), "must be greater than 0")
+	}
+	m.Retry.validate(v, with(path, "retry"))
+	if m.Collectors.DownloadPeers && !m.downloadDetails() {
+		v.errorf(with(path, "collectors", "download_peers"), "requires collectors.download_details to be enabled")
+	}
+	m.validateAuth(v, path)
+	m.TLS.validate(v, with(path, "tls"))
+	for i, target := range m.ProbeTargets {
+		if err := ValidateAddress(target); err != nil {
+			v.errorf(with(path, "probe_targets", i), "%v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, c := range m.Collectors.DownloadColumns {
+		colPath := with(path, "collectors", "download_columns", i)
+		if !m.downloadDetails() {
+			v.errorf(colPath, "requires collectors.download_details to be enabled")
+		}
+		switch c.Type {
+		case "", "gauge", "counter":
+		default:
+			v.errorf(with(colPath, "type"), "must be gauge or counter, not %q", c.Type)
+		}
+		if err := rtorrentexporter.ValidateDetailColumn(c.DetailColumn()); err != nil {
+			v.errorf(colPath, "%v", err)
+		}
+		if names[c.Name] {
+			v.errorf(with(colPath, "name"), "duplicate column name %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	labels := make(map[string]bool)
+	for i, l := range m.Collectors.DownloadLabels {
+		labelPath := with(path, "collectors", "download_labels", i)
+		if !m.downloadDetails() {
+			v.errorf(labelPath, "requires collectors.download_details to be enabled")
+		}
+		dl, err := l.DetailLabel()
+		if err != nil {
+			v.errorf(with(labelPath, "regex"), "%v", err)
+		}
+		if err := rtorrentexporter.ValidateDetailLabel(dl); err != nil {
+			v.errorf(labelPath, "%v", err)
+		}
+		if labels[l.Name] {
+			v.errorf(with(labelPath, "name"), "duplicate label name %q", l.Name)
+		}
+		labels[l.Name] = true
+	}
+
+	top := with(path, "collectors", "download_top")
+	if m.Collectors.DownloadTop.Count < 0 {
+		v.errorf(with(top, "count"), "must not be negative")
+	}
+	if m.Collectors.DownloadTop.Count > 0 && !m.downloadDetails() {
+		v.errorf(top, "requires collectors.download_details to be enabled")
+	}
+	if !rtorrentexporter.ValidTopDownloadsBy(m.Collectors.DownloadTop.By) {
+		v.errorf(with(top, "by"), "must be %s, %s or %s, not %q",
+			rtorrentexporter.TopByUploadRate, rtorrentexporter.TopByDownloadRate, rtorrentexporter.TopByActivity, m.Collectors.DownloadTop.By)
+	}
+
+	m.Collectors.Aggregate.validate(v, with(path, "collectors", "aggregate"))
+
+	rules := make(map[string]bool)
+	for i, f := range m.Collectors.DownloadFilters {
+		filterPath := with(path, "collectors", "download_filters", i)
+		if f.Action != "include" && f.Action != "exclude" {
+			v.errorf(with(filterPath, "action"), "must be include or exclude, not %q", f.Action)
+		}
+		if (f.Regex == "") == (f.Glob == "") {
+			v.errorf(filterPath, "exactly one of regex or glob must be set")
+		}
+		// A missing pattern is reported above
+		df, err := f.Filter()
+		if err != nil {
+			v.errorf(with(filterPath, "regex"), "%v", err)
+		} else if df.Pattern != nil {
+			if err := rtorrentexporter.ValidateDownloadFilter(df); err != nil {
+				v.errorf(filterPath, "%v", err)
+			}
+		}
+		if rules[f.Name] {
+			v.errorf(with(filterPath, "name"), "duplicate filter name %q", f.Name)
+		}
+		rules[f.Name] = true
+	}
+	if m.Collectors.FilterCounts && len(m.Collectors.DownloadFilters) == 0 {
+		v.errorf(with(path, "collectors", "filter_counts"), "requires collectors.download_filters")
+	}
+}
+
+// validateNamePrivacy records with v the download labels and aggregation of
+// the module whose values may hold download names, which privacy.names would
+// otherwise let through.
+func (m Module) validateNamePrivacy(v *validator, path []any) {
+	const msg = "%s values may hold download names, which privacy.names can't hide, capture part of d.directory= with a regex instead"
+
+	for i, l := range m.Collectors.DownloadLabels {
+		if dl, err := l.DetailLabel(); err == nil && dl.RevealsNames() {
+			v.errorf(with(path, "collectors", "download_labels", i), msg, dl.Command)
+		}
+	}
+
+	if agg, err := m.Collectors.Aggregate.Aggregation(); err == nil && agg != nil &&
+		agg.By == rtorrentexporter.AggregateByCommand && agg.Label.RevealsNames() {
+		v.errorf(with(path, "collectors", "aggregate"), msg, agg.Label.Command)
+	}
+}
+
+// validate records every problem found in the aggregation's settings with v,
+// path is the location of the aggregation in the configuration.
+func (a Aggregate) validate(v *validator, path []any) {
+	switch a.By {
+	case "":
+		return
+	case "label", "directory":
+		if a.Command != "" {
+			v.errorf(with(path, "command"), "only applies when aggregating by command")
+		}
+	case rtorrentexporter.AggregateByCommand, rtorrentexporter.AggregateByTracker, rtorrentexporter.AggregateByView:
+	default:
+		v.errorf(with(path, "by"), "must be label, directory, command, tracker or view, not %q", a.By)
+		return
+	}
+
+	agg, err := a.Aggregation()
+	if err != nil {
+		v.errorf(with(path, "regex"), "%v", err)
+		return
+	}
+	if err := rtorrentexporter.ValidateAggregation(*agg); err != nil {
+		v.errorf(path, "%v", err)
+	}
+}
+
+// validate records every problem found in the TLS settings with v, path is
+// their location in the configuration.
+func (t TLSConfig) validate(v *validator, path []any) {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		v.errorf(with(path, "cert_file"), "cert_file and key_file must be set together")
+	}
+	if t.InsecureSkipVerify && (t.CAFile != "" || len(t.PinnedFingerprints) > 0) {
+		v.errorf(with(path, "insecure_skip_verify"), "cannot be combined with ca_file or pinned_fingerprints")
+	}
+	for i, fp := range t.PinnedFingerprints {
+		if _, err := transport.ParseFingerprint(fp); err != nil {
+			v.errorf(with(path, "pinned_fingerprints", i), "%v", err)
+		}
+	}
+}
+
+// validate records every problem found in the retry settings with v.
+func (r RetryConfig) validate(v *validator, path []any) {
+	if r.Attempts < 1 {
+		v.errorf(with(path, "attempts"), "must be at least 1")
+	}
+	if r.InitialBackoff <= 0 {
+		v.errorf(with(path, "initial_backoff"), "must be greater than 0")
+	}
+	if r.MaxBackoff <= 0 {
+		v.errorf(with(path, "max_backoff"), "must be greater than 0")
+	}
+	if r.ScrapeTimeout <= 0 {
+		v.errorf(with(path, "scrape_timeout"), "must be greater than 0")
+	}
+	if r.CircuitBreaker.FailureThreshold < 0 {
+		v.errorf(with(path, "circuit_breaker", "failure_threshold"), "must not be negative")
+	}
+	if r.CircuitBreaker.Cooldown <= 0 {
+		v.errorf(with(path, "circuit_breaker", "cooldown"), "must be greater than 0")
+	}
+}
+
+// validateAuth records the problems found in the module's authentication
+// settings and headers.
+func (m Module) validateAuth(v *validator, path []any) {
+	switch m.AuthScheme {
+	case "", transport.AuthBasic, transport.AuthDigest:
+		switch {
+		case m.BearerTokenFile != "":
+			v.errorf(with(path, "bearer_token_file"), "requires auth_scheme %s", transport.AuthBearer)
+		case m.Password != "" && m.PasswordFile != "":
+			v.errorf(with(path, "password_file"), "cannot be combined with password")
+		case m.AuthScheme != "" && m.Username == "":
+			v.errorf(with(path, "auth_scheme"), "%s requires username and password or password_file", m.AuthScheme)
+		case m.partialCredentials():
+			v.warnf(with(path, "username"), "username and password must be set together, authentication is skipped "+
+				"and a future release will refuse to start")
+		case (m.Username == "") != (m.Password == "" && m.PasswordFile == ""):
+			v.errorf(with(path, "username"), "username and password or password_file must be set together")
+		}
+	case transport.AuthBearer:
+		switch {
+		case m.BearerTokenFile == "":
+			v.errorf(with(path, "auth_scheme"), "%s requires bearer_token_file", m.AuthScheme)
+		case m.Username != "" || m.Password != "" || m.PasswordFile != "":
+			v.errorf(with(path, "auth_scheme"), "%s doesn't use username, password or password_file", m.AuthScheme)
+		}
+	default:
+		v.errorf(with(path, "auth_scheme"), "must be %s, %s or %s, not %q",
+			transport.AuthBasic, transport.AuthDigest, transport.AuthBearer, m.AuthScheme)
+	}
+
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	auth := m.TransportOptions().AuthEnabled()
+	for _, name := range names {
+		if err := transport.ValidateHeader(name, m.Headers[name], auth); err != nil {
+			v.errorf(with(path, "headers", name), "%v", err)
+		}
+	}
+}
+
+// authConfigured reports whether any authentication setting or header is set.
+func (m Module) authConfigured() bool {
+	return m.AuthScheme != "" || m.Username != "" || m.Password != "" || m.PasswordFile != "" ||
+		m.BearerTokenFile != "" || len(m.Headers) > 0
+}
+
+// partialCredentials reports whether only one of the username and password is
+// set, which earlier versions accepted and skipped authentication for.
+func (m Module) partialCredentials() bool {
+	return m.AuthScheme == "" && m.PasswordFile == "" && m.BearerTokenFile == "" && (m.Username == "") != (m.Password == "")
+}
+
+// validateFor records the problems which arise from using the module with the
+// rTorrent server at addr.
+func (m Module) validateFor(v *validator, path []any, addr string) {
+	opts := m.TransportOptions()
+	switch {
+	case scgi.IsSCGI(addr) && (m.authConfigured() || opts.TLSEnabled()):
+		v.errorf(path, "authentication, headers and tls only apply to HTTP(S) addresses, not SCGI address %q", addr)
+	case strings.HasPrefix(addr, "http://") && opts.TLSEnabled() && !opts.Insecure:
+		// insecure_skip_verify is tolerated, as it always was
+		v.errorf(with(path, "tls"), "only applies to https addresses, not %q", addr)
+	}
+}
+
+// validateSession records the problems which arise from using the module with
+// a session directory, which holds neither rates, peers nor tracker announces
+// and isn't connected to.
+func (m Module) validateSession(v *validator, path []any) {
+	if m.authConfigured() || m.TransportOptions().TLSEnabled() {
+		v.errorf(path, "authentication, headers and tls don't apply to a session directory")
+	}
+	if m.downloadDetails() {
+		// Details are only reported for the downloads of the active view, which
+		// is always empty as rates aren't saved
+		v.errorf(with(path, "collectors", "download_details"), "rates aren't saved in a session directory, so no download is "+
+			"ever active, set to false")
+	}
+	if m.Collectors.DownloadPeers {
+		v.errorf(with(path, "collectors", "download_peers"), "peers aren't saved in a session directory")
+	}
+	if m.Collectors.Trackers {
+		v.errorf(with(path, "collectors", "trackers"), "tracker announces aren't saved in a session directory")
+	}
+}
+
+// validateInstance records every problem found in the instance's settings with
+// v, seen holds the names of the instances validated so far.
+func (c *Config) validateInstance(v *validator, path []any, inst Instance, seen map[string]bool) {
+	switch {
+	case inst.Name == "":
+		v.errorf(with(path, "name"), "must be set")
+	case seen[inst.Name]:
+		v.errorf(with(path, "name"), "duplicate instance name %q", inst.Name)
+	default:
+		seen[inst.Name] = true
+	}
+
+	m, ok := c.Module(inst.Module)
+	if !ok {
+		v.errorf(with(path, "module"), "unknown module %q", inst.Module)
+	}
+
+	switch {
+	case inst.Address != "" && inst.SessionDirectory != "":
+		v.errorf(with(path, "session_directory"), "cannot be combined with address")
+	case inst.SessionDirectory != "":
+		if ok {
+			m.validateSession(v, path)
+		}
+	case inst.Address == "":
+		v.errorf(with(path, "address"), "must be set, or session_directory")
+	default:
+		if err := ValidateAddress(inst.Address); err != nil {
+			v.errorf(with(path, "address"), "%v", err)
+		} else if ok {
+			m.validateFor(v, path, inst.Address)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package names provides the /names handler, which maps the pseudonyms
+// reported in place of download names back to the names for operators.
+package names
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+)
+
+// Verify that the Handler implements the http.Handler interface.
+var _ http.Handler = &Handler{}
+
+// A Handler serves /names?pseudonym=<pseudonym> requests with the names
+// reported as the pseudonym, or every pseudonym along with its names when none
+// is given, as a JSON object. It only answers requests from the loopback
+// interface authenticated as one of its users, and is disabled when it has
+// none.
+type Handler struct {
+	Names *rtorrentexporter.Names
+
+	// Users may look names up once authenticated by Authenticate, typically
+	// with the basic_auth_users of the web configuration file, so that their
+	// passwords are only ever stored hashed and a single Authorization header
+	// satisfies both.
+	Users        []string
+	Authenticate func(*http.Request) (string, bool)
+}
+
+// ServeHTTP writes the names behind the requested pseudonym.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(h.Users) == 0 || h.Authenticate == nil {
+		http.Error(w, "name lookups are disabled", http.StatusNotFound)
+		return
+	}
+
+	if !isLoopback(r.RemoteAddr) {
+		http.Error(w, "name lookups are only served locally", http.StatusForbidden)
+		return
+	}
+
+	user, ok := h.Authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rtorrent_exporter names"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !slices.Contains(h.Users, user) {
+		http.Error(w, "name lookups aren't allowed for this user", http.StatusForbidden)
+		return
+	}
+
+	names := h.Names.All()
+	if p := r.URL.Query().Get("pseudonym"); p != "" {
+		found := h.Names.Lookup(p)
+		if found == nil {
+			http.Error(w, "unknown pseudonym", http.StatusNotFound)
+			return
+		}
+		names = map[string][]string{p: found}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(names); err != nil {
+		log.Printf("[ERROR] failed writing name lookup: %v", err)
+	}
+}
+
+// isLoopback reports whether the remote address of a request is on the
+// loopback interface.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,85 @@
+package names
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/aauren/rtorrent-exporter/pkg/web"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testNames returns Names which have seen two downloads.
+func testNames() *rtorrentexporter.Names {
+	names := rtorrentexporter.NewNames(rtorrentexporter.NamePrivacy{Mode: rtorrentexporter.NamesTruncate, Length: 4})
+	names.Pseudonym("Some.Linux.ISO")
+	names.Pseudonym("Other.Linux.ISO")
+	return names
+}
+
+// lookup serves a /names request from remoteAddr with h.
+func lookup(h http.Handler, remoteAddr, query, username, password string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/names"+query, nil)
+	r.RemoteAddr = remoteAddr
+	if username != "" {
+		r.SetBasicAuth(username, password)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	h := &Handler{
+		Names: testNames(),
+		Users: []string{"admin"},
+		Authenticate: func(r *http.Request) (string, bool) {
+			u, p, ok := r.BasicAuth()
+			return u, ok && p == "secret"
+		},
+	}
+
+	w := lookup(h, "127.0.0.1:1234", "?pseudonym=Some", "admin", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got map[string][]string
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, map[string][]string{"Some": {"Some.Linux.ISO"}}, got)
+
+	w = lookup(h, "[::1]:1234", "", "admin", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, map[string][]string{"Some": {"Some.Linux.ISO"}, "Othe": {"Other.Linux.ISO"}}, got)
+
+	assert.Equal(t, http.StatusNotFound, lookup(h, "127.0.0.1:1234", "?pseudonym=None", "admin", "secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, lookup(h, "127.0.0.1:1234", "", "admin", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, lookup(h, "127.0.0.1:1234", "", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, lookup(h, "127.0.0.1:1234", "", "prometheus", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, lookup(h, "192.0.2.1:1234", "", "admin", "secret").Code)
+
+	disabled := &Handler{Names: testNames()}
+	assert.Equal(t, http.StatusNotFound, lookup(disabled, "127.0.0.1:1234", "", "admin", "secret").Code)
+}
+
+func TestHandler_WebAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "web.yml")
+	cfg := "basic_auth_users:\n  admin: " + string(hash) + "\n  prometheus: " + string(hash) + "\n"
+	assert.Nil(t, os.WriteFile(path, []byte(cfg), 0o600))
+
+	ws, err := web.New(path)
+	assert.Nil(t, err)
+
+	// The names of the web configuration authenticate both the listener and
+	// the lookup with a single Authorization header
+	h := ws.Handler(&Handler{Names: testNames(), Users: []string{"admin"}, Authenticate: ws.Authenticate})
+
+	assert.Equal(t, http.StatusOK, lookup(h, "127.0.0.1:1234", "?pseudonym=Some", "admin", "secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, lookup(h, "127.0.0.1:1234", "", "admin", "wrong").Code)
+	assert.Equal(t, http.StatusForbidden, lookup(h, "127.0.0.1:1234", "", "prometheus", "secret").Code)
+}
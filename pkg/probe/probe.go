@@ -0,0 +1,201 @@
+// Package probe provides a blackbox exporter style /probe handler, which
+// collects metrics from the rTorrent instance named by each request so that a
+// single exporter can serve many rTorrent instances.
+package probe
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/config"
+	"github.com/aauren/rtorrent-exporter/pkg/retry"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// scrapeTimeoutOffset is taken off the scrape timeout sent by Prometheus,
+	// so that retries end before Prometheus gives up on the probe.
+	scrapeTimeoutOffset = 500 * time.Millisecond
+
+	// transportIdleTimeout is how long the transport of a target which isn't
+	// probed anymore is kept by Transports.
+	transportIdleTimeout = 10 * time.Minute
+)
+
+// Verify that the Handler implements the http.Handler interface.
+var _ http.Handler = &Handler{}
+
+// A Handler serves /probe?target=<address>&module=<name> requests. For each
+// request a new Exporter is built against the target rTorrent address using the
+// settings of the named module, or of config.DefaultModule when no module is
+// given. Targets missing from the module's probe_targets are refused, as are
+// all targets of a module sending credentials without probe_targets.
+type Handler struct {
+	// Config holds the modules which probes can select, it may be nil in which
+	// case only the default module is available.
+	Config *config.Config
+
+	// Names, if set, pseudonymizes the download names of the probed metrics.
+	Names *rtorrentexporter.Names
+
+	// Transports, if set, keeps the transport of each module and target across
+	// probes, so that their retry counters and circuit breaker carry over from
+	// one probe to the next. Otherwise every probe starts afresh.
+	Transports *Transports
+}
+
+// ServeHTTP collects the metrics of the requested target and writes them in the
+// Prometheus exposition format.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if err := config.ValidateAddress(target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = config.DefaultModule
+	}
+	module, ok := h.Config.Module(moduleName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if !module.ProbeAllowed(target) {
+		http.Error(w, fmt.Sprintf("target isn't in the probe_targets of module %q, which are required for modules "+
+			"sending credentials, a client certificate or headers", moduleName), http.StatusForbidden)
+		return
+	}
+
+	c, err := h.newClient(moduleName, module, target)
+	if err != nil {
+		log.Printf("[ERROR] cannot create rTorrent client for probe of %q with module %q: %v", target, moduleName, err)
+		http.Error(w, fmt.Sprintf("cannot create rTorrent client for module %q: %v", moduleName, err), http.StatusBadRequest)
+		return
+	}
+	// Each probe builds its own client, so its connections would otherwise
+	// linger until the server closes them
+	defer c.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := module.CollectorOpts()
+	opts.Names = h.Names
+	opts.ScrapeTimeout = scrapeTimeout(r, opts.ScrapeTimeout)
+	reg.MustRegister(rtorrentexporter.New(c, opts))
+
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// newClient creates a client for the target using the module's settings, with
+// the transport kept by h.Transports if set.
+func (h *Handler) newClient(name string, module config.Module, target string) (*rtorrentrpc.Client, error) {
+	if h.Transports == nil {
+		return module.NewClient(target)
+	}
+
+	rt, err := h.Transports.get(name, module, target)
+	if err != nil {
+		return nil, err
+	}
+	return rtorrentrpc.New(target, rt)
+}
+
+// Transports keeps the transport of each module and target probed, along with
+// its retry counters and circuit breaker. The transports of targets which
+// weren't probed for a while are dropped, so that targets which come and go
+// don't accumulate.
+type Transports struct {
+	mu         sync.Mutex
+	transports map[transportKey]*probedTransport
+
+	now func() time.Time
+}
+
+// A transportKey identifies the transport of a target probed with a module.
+type transportKey struct {
+	module string
+	target string
+}
+
+// A probedTransport is a transport along with when its target was last probed.
+type probedTransport struct {
+	rt       *retry.Transport
+	lastUsed time.Time
+}
+
+// NewTransports creates an empty Transports.
+func NewTransports() *Transports {
+	return &Transports{
+		transports: make(map[transportKey]*probedTransport),
+		now:        time.Now,
+	}
+}
+
+// get returns the transport of the target probed with the named module,
+// creating it if needed, and drops the transports which weren't used for
+// transportIdleTimeout.
+func (t *Transports) get(name string, module config.Module, target string) (*retry.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for k, pt := range t.transports {
+		if now.Sub(pt.lastUsed) > transportIdleTimeout {
+			pt.rt.CloseIdleConnections()
+			delete(t.transports, k)
+		}
+	}
+
+	k := transportKey{module: name, target: target}
+	pt, ok := t.transports[k]
+	if !ok {
+		rt, err := module.NewTransport(target)
+		if err != nil {
+			return nil, err
+		}
+		pt = &probedTransport{rt: rt}
+		t.transports[k] = pt
+	}
+	pt.lastUsed = now
+
+	return pt.rt, nil
+}
+
+// Close closes the idle connections of every transport kept.
+func (t *Transports) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, pt := range t.transports {
+		pt.rt.CloseIdleConnections()
+	}
+}
+
+// scrapeTimeout returns the scrape timeout sent by Prometheus along with the
+// probe request less scrapeTimeoutOffset, or def if there is none.
+func scrapeTimeout(r *http.Request, def time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return def
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return timeout
+}
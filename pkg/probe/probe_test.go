@@ -0,0 +1,231 @@
+package probe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRTorrent starts an XML-RPC server which answers the calls made by the
+// default collectors, requiring the provided HTTP Basic credentials if set.
+func fakeRTorrent(t *testing.T, username, password string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username != "" {
+			if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var xr struct {
+			MethodName string `xml:"methodName"`
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, xml.Unmarshal(body, &xr))
+
+		var value string
+		switch {
+		case xr.MethodName == "system.client_version":
+			value = "<string>0.9.8</string>"
+		case xr.MethodName == "system.startup_time":
+			value = "<i8>1700000000</i8>"
+		case xr.MethodName == "system.multicall" && strings.Contains(string(body), "throttle."):
+			value = "<array><data>" + strings.Repeat("<value><array><data><value><i8>1024</i8></value></data></array></value>", 6) +
+				"</data></array>"
+		case xr.MethodName == "system.multicall":
+			value = "<array><data>" + strings.Repeat("<value><array><data><value><i8>2</i8></value></data></array></value>", 8) +
+				"</data></array>"
+		case xr.MethodName == "d.multicall2":
+			value = "<array><data></data></array>"
+		default:
+			t.Errorf("unexpected XML-RPC method %q", xr.MethodName)
+		}
+
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><methodResponse><params><param><value>%s</value></param></params></methodResponse>`,
+			value)
+	}))
+}
+
+func probe(h http.Handler, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+	return w
+}
+
+func TestHandler_DefaultModule(t *testing.T) {
+	s := fakeRTorrent(t, "", "")
+	defer s.Close()
+
+	w := probe(&Handler{}, "target="+s.URL)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 1")
+	assert.Contains(t, w.Body.String(), "rtorrent_start_time_seconds 1.7e+09")
+	assert.Contains(t, w.Body.String(), "rtorrent_downloads 2")
+	assert.Contains(t, w.Body.String(), "rtorrent_throttle_global_download_rate_bytes 1024")
+}
+
+func TestHandler_Module(t *testing.T) {
+	s := fakeRTorrent(t, "admin", "secret")
+	defer s.Close()
+
+	cfg, err := config.Parse([]byte(fmt.Sprintf(
+		"modules:\n  seedbox:\n    username: admin\n    password: secret\n    probe_targets: [%s]\n", s.URL)))
+	assert.Nil(t, err)
+	h := &Handler{Config: cfg}
+
+	w := probe(h, "module=seedbox&target="+s.URL)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 1")
+
+	// Without credentials rTorrent can't be reached, which is reported through
+	// rtorrent_up rather than failing the probe
+	w = probe(h, "target="+s.URL)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 0")
+}
+
+func TestHandler_ProbeTargets(t *testing.T) {
+	s := fakeRTorrent(t, "admin", "secret")
+	defer s.Close()
+
+	cfg, err := config.Parse([]byte(fmt.Sprintf(
+		"modules:\n  seedbox:\n    username: admin\n    password: secret\n    probe_targets: [%s]\n", s.URL)))
+	assert.Nil(t, err)
+	h := &Handler{Config: cfg}
+
+	w := probe(h, "module=seedbox&target="+s.URL)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 1")
+
+	// The credentials of the module must not reach other targets
+	other := fakeRTorrent(t, "", "")
+	defer other.Close()
+	var reached atomic.Bool
+	next := other.Config.Handler
+	other.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Store(true)
+		next.ServeHTTP(w, r)
+	})
+
+	w = probe(h, "module=seedbox&target="+other.URL)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, reached.Load())
+
+	// Modules sending credentials, a client certificate or headers can't probe
+	// at all without probe_targets, other modules can probe any target
+	cfg, err = config.Parse([]byte("modules:\n  seedbox:\n    username: admin\n    password: secret\n" +
+		"  proxied:\n    headers:\n      X-Shared-Secret: secret\n  open:\n    timeout: 5s\n"))
+	assert.Nil(t, err)
+	h = &Handler{Config: cfg}
+
+	assert.Equal(t, http.StatusForbidden, probe(h, "module=seedbox&target="+s.URL).Code)
+	assert.Equal(t, http.StatusForbidden, probe(h, "module=proxied&target="+other.URL).Code)
+	assert.False(t, reached.Load())
+	assert.Equal(t, http.StatusOK, probe(h, "module=open&target="+other.URL).Code)
+	assert.True(t, reached.Load())
+}
+
+func TestHandler_Retries(t *testing.T) {
+	s := fakeRTorrent(t, "", "")
+	defer s.Close()
+
+	// The first call fails as if rTorrent's front-end was restarting
+	next := s.Config.Handler
+	var failed atomic.Bool
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failed.CompareAndSwap(false, true) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	cfg, err := config.Parse([]byte("modules:\n  default:\n    retry:\n      initial_backoff: 1ms\n"))
+	assert.Nil(t, err)
+
+	w := probe(&Handler{Config: cfg}, "target="+s.URL)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 1")
+	assert.Contains(t, w.Body.String(), `rtorrent_exporter_xmlrpc_retries_total{method="system.client_version"} 1`)
+	assert.Contains(t, w.Body.String(), "rtorrent_exporter_circuit_breaker_state 0")
+}
+
+func TestHandler_Transports(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	cfg, err := config.Parse([]byte("modules:\n  default:\n    retry:\n      attempts: 1\n" +
+		"      circuit_breaker:\n        failure_threshold: 2\n        cooldown: 1m\n"))
+	assert.Nil(t, err)
+
+	h := &Handler{Config: cfg, Transports: NewTransports()}
+	for i := 0; i < 2; i++ {
+		w := probe(h, "target="+s.URL)
+		assert.Contains(t, w.Body.String(), "rtorrent_up 0")
+	}
+
+	// The breaker opened across probes, and the failures keep being counted
+	w := probe(h, "target="+s.URL)
+	assert.Contains(t, w.Body.String(), "rtorrent_up 0")
+	assert.Contains(t, w.Body.String(), "rtorrent_exporter_circuit_breaker_state 1")
+	assert.Contains(t, w.Body.String(), `rtorrent_exporter_xmlrpc_failures_total{method="system.client_version"} 2`)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Other targets have their own breaker
+	w = probe(h, "target="+s.URL+"/other")
+	assert.Contains(t, w.Body.String(), "rtorrent_exporter_circuit_breaker_state 0")
+
+	// Targets which aren't probed anymore are dropped
+	h.Transports.now = func() time.Time { return time.Now().Add(transportIdleTimeout + time.Minute) }
+	probe(h, "target="+s.URL+"/other")
+	assert.Len(t, h.Transports.transports, 1)
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":        10 * time.Second,
+		"invalid": 10 * time.Second,
+		"-1":      10 * time.Second,
+		"15":      14500 * time.Millisecond,
+		"0.25":    250 * time.Millisecond,
+	}
+
+	for header, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		}
+		assert.Equal(t, want, scrapeTimeout(r, 10*time.Second), header)
+	}
+}
+
+func TestHandler_BadRequests(t *testing.T) {
+	tests := map[string]string{
+		"missing target":     "module=default",
+		"unsupported scheme": "target=ftp://127.0.0.1/RPC2",
+		"unknown module":     "target=http://127.0.0.1/RPC2&module=missing",
+	}
+
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := probe(&Handler{}, query)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -0,0 +1,372 @@
+// Package reload provides live reloading of the rtorrent_exporter
+// configuration, rebuilding the rTorrent clients and Exporters it describes
+// without restarting the exporter.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/config"
+	"github.com/aauren/rtorrent-exporter/pkg/names"
+	"github.com/aauren/rtorrent-exporter/pkg/probe"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentexporter"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/aauren/rtorrent-exporter/pkg/session"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// namespace and subsystem match those of the Exporter's own metrics.
+	namespace = "rtorrent"
+	subsystem = "exporter"
+)
+
+// A Reloader holds the current configuration along with the Exporters built
+// from it, and replaces both at once when the configuration is reloaded. If the
+// new configuration can't be loaded, the current one keeps being served.
+//
+// A Reloader implements prometheus.Collector to report on reloads and
+// prometheus.Gatherer to serve the metrics of the current Exporters.
+type Reloader struct {
+	LastReloadSuccessful  *prometheus.Desc
+	LastReloadSuccessTime *prometheus.Desc
+
+	load func() (*config.Config, error)
+
+	// reloadMu serializes reloads so that a SIGHUP and a /-/reload request
+	// can't build their Exporters concurrently.
+	reloadMu sync.Mutex
+
+	mu          sync.RWMutex
+	state       *state
+	successful  bool
+	successTime time.Time
+}
+
+// A state is a configuration along with the Exporters built from it.
+type state struct {
+	cfg     *config.Config
+	reg     *prometheus.Registry
+	clients []*rtorrentrpc.Client
+
+	// names pseudonymizes the download names of every Exporter built from the
+	// configuration.
+	names *rtorrentexporter.Names
+
+	// transports keeps the transports of the probed targets, they start afresh
+	// with every reload as the modules may have changed.
+	transports *probe.Transports
+
+	// stop stops the polling of the Exporters, if enabled, and polling is
+	// closed by each of them once its last poll finished.
+	stop    context.CancelFunc
+	polling []<-chan struct{}
+
+	// gathers counts the gathers of the registry and the probes in flight.
+	gathers sync.WaitGroup
+}
+
+// close stops the polling of the state's Exporters and closes their clients
+// and probe transports once the polls, gathers and probes still in flight
+// finished, as calls made with a closed client fail.
+func (s *state) close() {
+	s.stop()
+	for _, done := range s.polling {
+		<-done
+	}
+	s.gathers.Wait()
+	closeClients(s.clients)
+	s.transports.Close()
+}
+
+// Verify that the Reloader implements the prometheus interfaces.
+var (
+	_ prometheus.Collector = &Reloader{}
+	_ prometheus.Gatherer  = &Reloader{}
+)
+
+// New creates a Reloader which loads its configuration with load, which is
+// called once now and again on every Reload. An error is returned if the
+// initial configuration can't be loaded.
+func New(load func() (*config.Config, error)) (*Reloader, error) {
+	r := &Reloader{
+		LastReloadSuccessful: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "config_last_reload_successful"),
+			"Whether the last configuration reload attempt was successful (1 for yes, 0 for no).",
+			nil,
+			nil,
+		),
+
+		LastReloadSuccessTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "config_last_reload_success_timestamp_seconds"),
+			"Timestamp of the last successful configuration reload.",
+			nil,
+			nil,
+		),
+
+		load: load,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the configuration again and replaces the current Exporters with
+// ones built from it. On error the current configuration and Exporters are
+// kept.
+func (r *Reloader) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	s, err := r.build()
+
+	r.mu.Lock()
+	r.successful = err == nil
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	old := r.state
+	r.state = s
+	r.successTime = time.Now()
+	r.mu.Unlock()
+
+	if old != nil {
+		if old.cfg.Telemetry != s.cfg.Telemetry {
+			log.Printf("[WARN] telemetry settings changed, restart the exporter to apply them")
+		}
+		// Scrapes still in flight keep using the old clients until they finish,
+		// the reload doesn't wait for them
+		go old.close()
+	}
+
+	return nil
+}
+
+// build loads the configuration and builds the Exporters it describes.
+func (r *Reloader) build() (*state, error) {
+	cfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	s := &state{
+		cfg:        cfg,
+		reg:        prometheus.NewRegistry(),
+		names:      rtorrentexporter.NewNames(cfg.Privacy.NamePrivacy()),
+		transports: probe.NewTransports(),
+		stop:       stop,
+	}
+
+	// Pseudonyms stay the same across reloads which don't change how names
+	// are pseudonymized, so the names seen so far can still be looked up
+	r.mu.RLock()
+	if r.state != nil && samePseudonyms(r.state.cfg.Privacy, cfg.Privacy) {
+		s.names = r.state.names
+	}
+	r.mu.RUnlock()
+
+	exporters, err := s.register()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	// Polling only starts once every Exporter was built, so that a failed
+	// reload doesn't poll alongside the current Exporters
+	if opts := cfg.Polling.PollOpts(); opts.Enabled() {
+		for _, e := range exporters {
+			s.polling = append(s.polling, e.StartPolling(ctx, opts))
+		}
+	}
+
+	return s, nil
+}
+
+// register builds the Exporters described by the state's configuration and
+// registers them with its registry.
+func (s *state) register() ([]*rtorrentexporter.Exporter, error) {
+	cfg := s.cfg
+
+	if cfg.RTorrent.Address != "" || cfg.RTorrent.SessionDirectory != "" {
+		e, err := s.exporter(cfg.RTorrent.Module, cfg.RTorrent.Address, cfg.RTorrent.SessionDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create rTorrent client: %w", err)
+		}
+		if err := s.reg.Register(e); err != nil {
+			return nil, err
+		}
+		return []*rtorrentexporter.Exporter{e}, nil
+	}
+
+	exporters := make([]*rtorrentexporter.Exporter, 0, len(cfg.Instances))
+	for _, inst := range cfg.Instances {
+		m, _ := cfg.Module(inst.Module)
+
+		e, err := s.exporter(m, inst.Address, inst.SessionDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create rTorrent client for instance %q: %w", inst.Name, err)
+		}
+		if err := rtorrentexporter.RegisterInstance(s.reg, inst.Name, e); err != nil {
+			return nil, fmt.Errorf("cannot register instance %q: %w", inst.Name, err)
+		}
+		exporters = append(exporters, e)
+	}
+
+	return exporters, nil
+}
+
+// exporter builds the Exporter collecting with the module from the rTorrent
+// server at addr, or from the session directory dir when set.
+func (s *state) exporter(m config.Module, addr, dir string) (*rtorrentexporter.Exporter, error) {
+	if dir != "" {
+		return rtorrentexporter.NewSession(session.New(dir), s.collectorOpts(m)), nil
+	}
+
+	c, err := m.NewClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	s.clients = append(s.clients, c)
+
+	return rtorrentexporter.New(c, s.collectorOpts(m)), nil
+}
+
+// collectorOpts returns the options of the Exporters built from the module.
+func (s *state) collectorOpts(m config.Module) rtorrentexporter.CollectorOpts {
+	opts := m.CollectorOpts()
+	opts.Names = s.names
+	return opts
+}
+
+// closeClients closes each of the provided clients, logging any failure.
+func closeClients(clients []*rtorrentrpc.Client) {
+	for _, c := range clients {
+		if err := c.Close(); err != nil {
+			log.Printf("[ERROR] failed closing rTorrent client: %v", err)
+		}
+	}
+}
+
+// Config returns the current configuration.
+func (r *Reloader) Config() *config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.state.cfg
+}
+
+// Gather collects the metrics of the Exporters built from the current
+// configuration.
+func (r *Reloader) Gather() ([]*dto.MetricFamily, error) {
+	// The gather is counted while holding the lock, so that a reload can't
+	// close the state's clients in between
+	r.mu.RLock()
+	s := r.state
+	s.gathers.Add(1)
+	r.mu.RUnlock()
+	defer s.gathers.Done()
+
+	return s.reg.Gather()
+}
+
+// ProbeHandler returns a probe.Handler which uses the modules of the current
+// configuration.
+func (r *Reloader) ProbeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The probe is counted the same way as gathers, so that a reload can't
+		// close the transports of the state in the middle of it
+		r.mu.RLock()
+		s := r.state
+		s.gathers.Add(1)
+		r.mu.RUnlock()
+		defer s.gathers.Done()
+
+		(&probe.Handler{Config: s.cfg, Names: s.names, Transports: s.transports}).ServeHTTP(w, req)
+	})
+}
+
+// samePseudonyms reports whether a and b pseudonymize names the same way.
+func samePseudonyms(a, b config.Privacy) bool {
+	return a.Names == b.Names && a.Key == b.Key && a.Length == b.Length
+}
+
+// NamesHandler returns a names.Handler which looks up the download names of
+// the current Exporters for the users of the current configuration, as
+// authenticated by authenticate.
+func (r *Reloader) NamesHandler(authenticate func(*http.Request) (string, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := r.current()
+		(&names.Handler{Names: s.names, Users: s.cfg.Privacy.Lookup.Users, Authenticate: authenticate}).ServeHTTP(w, req)
+	})
+}
+
+// current returns the current state.
+func (r *Reloader) current() *state {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.state
+}
+
+// LifecycleHandler returns the Reloader itself when enabled, or a handler
+// refusing every reload request otherwise, as anyone reaching the exporter can
+// otherwise make it reload its configuration.
+func (r *Reloader) LifecycleHandler(enabled bool) http.Handler {
+	if enabled {
+		return r
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "lifecycle API is not enabled, start the exporter with -web.enable-lifecycle", http.StatusForbidden)
+	})
+}
+
+// ServeHTTP reloads the configuration on POST or PUT requests, the same way
+// Prometheus' own /-/reload endpoint does.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.Reload(); err != nil {
+		log.Printf("[ERROR] failed reloading configuration: %v", err)
+		http.Error(w, fmt.Sprintf("failed reloading configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("reloaded configuration")
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.LastReloadSuccessful
+	ch <- r.LastReloadSuccessTime
+}
+
+// Collect sends the metric values of the last reload to the provided
+// prometheus Metric channel.
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	successful := 0.0
+	if r.successful {
+		successful = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(r.LastReloadSuccessful, prometheus.GaugeValue, successful)
+	ch <- prometheus.MustNewConstMetric(r.LastReloadSuccessTime, prometheus.GaugeValue, float64(r.successTime.UnixNano())/1e9)
+}
@@ -0,0 +1,250 @@
+package reload
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// loader returns a load function which parses each of the provided
+// configurations in turn, failing once they run out.
+func loader(t *testing.T, configs ...string) func() (*config.Config, error) {
+	t.Helper()
+
+	return func() (*config.Config, error) {
+		if len(configs) == 0 {
+			return nil, errors.New("no more configurations")
+		}
+		c := configs[0]
+		configs = configs[1:]
+		return config.Parse([]byte(c))
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	_, err := New(loader(t, "telemetry:\n  path: metrics\n"))
+	assert.NotNil(t, err)
+}
+
+func TestReloader_Reload(t *testing.T) {
+	r, err := New(loader(t,
+		"instances:\n  - name: a\n    address: http://127.0.0.1:1/RPC2\n",
+		"instances:\n  - name: b\n    address: http://127.0.0.1:1/RPC2\n",
+	))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", r.Config().Instances[0].Name)
+
+	assert.Nil(t, r.Reload())
+	assert.Equal(t, "b", r.Config().Instances[0].Name)
+
+	mfs, err := r.Gather()
+	assert.Nil(t, err)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "rtorrent_instance" {
+					assert.Equal(t, "b", l.GetValue())
+				}
+			}
+		}
+	}
+
+	assert.Nil(t, testutil.CollectAndCompare(r, strings.NewReader(`
+# HELP rtorrent_exporter_config_last_reload_successful Whether the last configuration reload attempt was successful (1 for yes, 0 for no).
+# TYPE rtorrent_exporter_config_last_reload_successful gauge
+rtorrent_exporter_config_last_reload_successful 1
+`), "rtorrent_exporter_config_last_reload_successful"))
+}
+
+func TestReloader_ReloadFailureKeepsConfig(t *testing.T) {
+	r, err := New(loader(t,
+		"instances:\n  - name: a\n    address: http://127.0.0.1:1/RPC2\n",
+		"instances:\n  - name: b\n    address: ftp://127.0.0.1/RPC2\n",
+	))
+	assert.Nil(t, err)
+	before := r.successTime
+
+	assert.NotNil(t, r.Reload())
+	assert.Equal(t, "a", r.Config().Instances[0].Name)
+	assert.Equal(t, before, r.successTime)
+
+	assert.Nil(t, testutil.CollectAndCompare(r, strings.NewReader(`
+# HELP rtorrent_exporter_config_last_reload_successful Whether the last configuration reload attempt was successful (1 for yes, 0 for no).
+# TYPE rtorrent_exporter_config_last_reload_successful gauge
+rtorrent_exporter_config_last_reload_successful 0
+`), "rtorrent_exporter_config_last_reload_successful"))
+}
+
+func TestReloader_ServeHTTP(t *testing.T) {
+	r, err := New(loader(t, "", "", ""))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		method string
+		code   int
+	}{
+		{http.MethodGet, http.StatusMethodNotAllowed},
+		{http.MethodPost, http.StatusOK},
+		{http.MethodPut, http.StatusOK},
+		{http.MethodPost, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, "/-/reload", nil))
+		assert.Equal(t, tt.code, w.Code, tt.method)
+	}
+}
+
+func TestReloader_LifecycleHandler(t *testing.T) {
+	r, err := New(loader(t, "", ""))
+	assert.Nil(t, err)
+
+	// Reloads are refused unless the lifecycle API is enabled
+	w := httptest.NewRecorder()
+	r.LifecycleHandler(false).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	r.LifecycleHandler(true).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReloader_KeepsNames(t *testing.T) {
+	const truncate = "privacy:\n  names: truncate\n  lookup:\n    users: [admin]\n"
+	r, err := New(loader(t, truncate, truncate, "privacy:\n  names: hmac\n  key: secret\n"))
+	assert.Nil(t, err)
+
+	names := r.current().names
+	names.Pseudonym("Some.Linux.ISO")
+
+	// Names seen so far can still be looked up after a reload
+	assert.Nil(t, r.Reload())
+	assert.Same(t, names, r.current().names)
+
+	req := httptest.NewRequest(http.MethodGet, "/names?pseudonym=Some.Lin", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	r.NamesHandler(func(*http.Request) (string, bool) { return "admin", true }).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Some.Lin": ["Some.Linux.ISO"]}`, w.Body.String())
+
+	// Other privacy settings start afresh
+	assert.Nil(t, r.Reload())
+	assert.NotSame(t, names, r.current().names)
+}
+
+func TestReloader_ReloadDuringScrape(t *testing.T) {
+	// The first call blocks until the configuration was reloaded
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><string>0.9.8</string></value></param></params></methodResponse>`)
+	}))
+	defer ts.Close()
+
+	cfg := fmt.Sprintf("rtorrent:\n  address: %s/RPC2\n", ts.URL)
+	r, err := New(loader(t, cfg, cfg))
+	assert.Nil(t, err)
+
+	gathered := make(chan float64)
+	go func() {
+		mfs, err := r.Gather()
+		assert.Nil(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() == "rtorrent_up" {
+				gathered <- mf.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		close(gathered)
+	}()
+
+	<-started
+	assert.Nil(t, r.Reload())
+	close(release)
+
+	// The scrape in flight still uses the old client, which isn't closed
+	// under it
+	select {
+	case up := <-gathered:
+		assert.Equal(t, float64(1), up)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scrape in flight during the reload never finished")
+	}
+}
+
+func TestReloader_ReloadDuringProbe(t *testing.T) {
+	// The first call blocks until the configuration was reloaded
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><string>0.9.8</string></value></param></params></methodResponse>`)
+	}))
+	defer ts.Close()
+	var released sync.Once
+	defer released.Do(func() { close(release) })
+
+	r, err := New(loader(t, "", ""))
+	assert.Nil(t, err)
+	old := r.current()
+
+	probed := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ProbeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?target="+ts.URL+"/RPC2", nil))
+		probed <- w.Code
+	}()
+
+	<-started
+	assert.Nil(t, r.Reload())
+
+	// The old state isn't closed while the probe is in flight
+	closed := make(chan struct{})
+	go func() {
+		old.gathers.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("old state closed while a probe was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	released.Do(func() { close(release) })
+	select {
+	case code := <-probed:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe in flight during the reload never finished")
+	}
+	<-closed
+}
+
+func TestReloader_SessionDirectory(t *testing.T) {
+	r, err := New(loader(t, "modules:\n  session:\n    collectors:\n      download_details: false\n"+
+		"instances:\n  - name: offline\n    module: session\n    session_directory: ../session/testdata/session\n"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, testutil.GatherAndCompare(r, strings.NewReader(`
+# HELP rtorrent_up Whether rTorrent could be reached (1 for yes, 0 for no).
+# TYPE rtorrent_up gauge
+rtorrent_up{rtorrent_instance="offline"} 1
+`), "rtorrent_up"))
+}
@@ -0,0 +1,405 @@
+// Package retry provides an http.RoundTripper which retries the XML-RPC calls
+// to rTorrent that failed transiently, and stops calling an unresponsive
+// rTorrent for a while with a circuit breaker.
+package retry
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// namespace and subsystem match those of the Exporter's own metrics.
+	namespace = "rtorrent"
+	subsystem = "exporter"
+)
+
+// Circuit breaker states, as reported by the BreakerState metric.
+const (
+	StateClosed   = 0
+	StateOpen     = 1
+	StateHalfOpen = 2
+)
+
+// ErrBreakerOpen is returned in place of calling rTorrent while the circuit
+// breaker is open.
+var ErrBreakerOpen = errors.New("circuit breaker is open after too many consecutive failed calls to rTorrent")
+
+// Opts configures the retries and the circuit breaker of a Transport.
+type Opts struct {
+	// Attempts is the number of times a call is attempted, retries are
+	// disabled when it is 1 or less.
+	Attempts int
+
+	// InitialBackoff is the wait before the first retry, it doubles with each
+	// following retry, and MaxBackoff bounds every wait when set. Half of each
+	// wait is drawn at random, so that exporters don't retry in lockstep.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// BreakerThreshold is the number of consecutive failed calls which open
+	// the circuit breaker, it is disabled when zero.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the open circuit breaker fails calls right
+	// away, before letting a single call through to check on rTorrent.
+	BreakerCooldown time.Duration
+}
+
+// A Transport is an http.RoundTripper which retries the XML-RPC calls that
+// only read from rTorrent when they fail with a transport error or a 5xx or 429
+// response. Calls which could modify rTorrent are attempted once.
+//
+// Retries stop at the deadline of the request context, typically the scrape's.
+// A retry whose backoff would end past the deadline isn't attempted. As the
+// deadline comes with each request, a Transport may be shared by concurrent
+// scrapes.
+//
+// A Transport implements prometheus.Collector to report its retries, failures
+// and circuit breaker state.
+type Transport struct {
+	Retries      *prometheus.Desc
+	Failures     *prometheus.Desc
+	BreakerState *prometheus.Desc
+
+	next http.RoundTripper
+	opts Opts
+
+	mu       sync.Mutex
+	retries  map[string]int
+	failures map[string]int
+
+	// state is the circuit breaker state, failed counts the consecutive
+	// failed calls and opened is when the breaker last opened.
+	state  int
+	failed int
+	opened time.Time
+}
+
+// Verify that the Transport implements the http.RoundTripper and
+// prometheus.Collector interfaces.
+var (
+	_ http.RoundTripper    = &Transport{}
+	_ prometheus.Collector = &Transport{}
+)
+
+// New creates a Transport which sends the calls with next.
+func New(next http.RoundTripper, opts Opts) *Transport {
+	return &Transport{
+		Retries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "xmlrpc_retries_total"),
+			"Number of XML-RPC calls to rTorrent retried after a failed attempt.",
+			[]string{"method"},
+			nil,
+		),
+
+		Failures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "xmlrpc_failures_total"),
+			"Number of failed XML-RPC call attempts to rTorrent, retried or not.",
+			[]string{"method"},
+			nil,
+		),
+
+		BreakerState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "circuit_breaker_state"),
+			"State of the circuit breaker of calls to rTorrent (0 for closed, 1 for open, 2 for half-open).",
+			nil,
+			nil,
+		),
+
+		next:     next,
+		opts:     opts,
+		retries:  make(map[string]int),
+		failures: make(map[string]int),
+	}
+}
+
+// CloseIdleConnections closes any idle connections held by the underlying
+// transport.
+func (t *Transport) CloseIdleConnections() {
+	if ic, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		ic.CloseIdleConnections()
+	}
+}
+
+// RoundTrip sends the call, retrying it if it failed and only reads from
+// rTorrent. It fails with ErrBreakerOpen without sending the call while the
+// circuit breaker is open.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	method, readOnly := parseCall(body)
+
+	probe, err := t.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if readOnly && !probe {
+		attempts = max(t.opts.Attempts, 1)
+	}
+	deadline, _ := r.Context().Deadline()
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(withBody(r, body))
+		if !failed(resp, err) {
+			t.done(probe, true)
+			return resp, err
+		}
+		t.fail(method)
+
+		wait := t.backoff(attempt)
+		if attempt >= attempts || r.Context().Err() != nil || (!deadline.IsZero() && time.Now().Add(wait).After(deadline)) {
+			t.done(probe, false)
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		t.retry(method)
+
+		if err := sleep(r.Context(), wait); err != nil {
+			t.done(probe, false)
+			return nil, err
+		}
+	}
+}
+
+// withBody returns a copy of r with its own reader of body, as the request
+// given to a RoundTripper mustn't be modified.
+func withBody(r *http.Request, body []byte) *http.Request {
+	req := r.Clone(r.Context())
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.ContentLength = int64(len(body))
+	}
+	return req
+}
+
+// failed reports whether an attempt failed in a way worth retrying: the
+// server couldn't be reached, it is overloaded or its backend is down. Other
+// responses, XML-RPC faults included, wouldn't change on a retry.
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// backoff returns the wait before the retry following the provided attempt.
+func (t *Transport) backoff(attempt int) time.Duration {
+	wait := t.opts.InitialBackoff
+	for i := 1; i < attempt && wait < t.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if t.opts.MaxBackoff > 0 {
+		wait = min(wait, t.opts.MaxBackoff)
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // jitter doesn't need a cryptographically secure source
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// allow reports whether a call may be sent, and whether it is the single call
+// let through by the half-open circuit breaker to check on rTorrent.
+func (t *Transport) allow() (bool, error) {
+	if t.opts.BreakerThreshold <= 0 {
+		return false, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.state {
+	case StateOpen:
+		if time.Since(t.opened) < t.opts.BreakerCooldown {
+			return false, ErrBreakerOpen
+		}
+		t.state = StateHalfOpen
+		return true, nil
+	case StateHalfOpen:
+		// Another call is already checking on rTorrent
+		return false, ErrBreakerOpen
+	default:
+		return false, nil
+	}
+}
+
+// done records the outcome of a call with the circuit breaker. A successful
+// call closes it, while the failed call of a half-open breaker, or one too many
+// consecutive failed calls, open it.
+func (t *Transport) done(probe, ok bool) {
+	if t.opts.BreakerThreshold <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ok {
+		t.state, t.failed = StateClosed, 0
+		return
+	}
+
+	t.failed++
+	if probe || (t.state == StateClosed && t.failed >= t.opts.BreakerThreshold) {
+		t.state, t.opened = StateOpen, time.Now()
+	}
+}
+
+// fail counts a failed attempt of the method.
+func (t *Transport) fail(method string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failures[method]++
+}
+
+// retry counts a retry of the method.
+func (t *Transport) retry(method string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.retries[method]++
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (t *Transport) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.Retries
+	ch <- t.Failures
+	ch <- t.BreakerState
+}
+
+// Collect sends the retry and failure counts of each method, along with the
+// circuit breaker state, to the provided prometheus Metric channel.
+func (t *Transport) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for method, n := range t.retries {
+		ch <- prometheus.MustNewConstMetric(t.Retries, prometheus.CounterValue, float64(n), method)
+	}
+	for method, n := range t.failures {
+		ch <- prometheus.MustNewConstMetric(t.Failures, prometheus.CounterValue, float64(n), method)
+	}
+
+	state := t.state
+	if state == StateOpen && time.Since(t.opened) >= t.opts.BreakerCooldown {
+		// The next call will check on rTorrent
+		state = StateHalfOpen
+	}
+	ch <- prometheus.MustNewConstMetric(t.BreakerState, prometheus.GaugeValue, float64(state))
+}
+
+// parseCall returns the method of an XML-RPC call, and whether none of the
+// commands it names, the methods of a system.multicall and the commands of a
+// d.multicall2 included, could modify rTorrent. Calls which can't be parsed
+// aren't considered read only.
+func parseCall(body []byte) (string, bool) {
+	var method, elem string
+	readOnly := true
+
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return method, false
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			elem = tok.Name.Local
+		case xml.EndElement:
+			elem = ""
+		case xml.CharData:
+			s := strings.TrimSpace(string(tok))
+			switch elem {
+			case "methodName":
+				if method == "" {
+					method = s
+				}
+				readOnly = readOnly && !modifies(s)
+			case "string", "value":
+				readOnly = readOnly && !modifies(s)
+			}
+		}
+	}
+
+	return method, readOnly && method != ""
+}
+
+var (
+	// modifyingCommands are the components of rTorrent command names which
+	// change its state, e.g. d.stop, d.custom.set or load.start.
+	modifyingCommands = map[string]bool{
+		"set": true, "start": true, "stop": true, "close": true, "open": true, "erase": true, "pause": true,
+		"resume": true, "load": true, "import": true, "insert": true, "remove": true, "shutdown": true,
+		"push_back": true, "add": true, "filter": true, "sort": true, "create": true,
+	}
+
+	// modifyingPrefixes prefix the components of rTorrent command names which
+	// change its state, e.g. execute.throw, d.save_full_session or the set_
+	// commands of older rTorrent versions.
+	modifyingPrefixes = []string{"set_", "execute", "schedule", "save", "delete", "check_hash"}
+)
+
+// modifies reports whether the command could change rTorrent's state. Values
+// which aren't commands, such as info hashes and view names, never do.
+func modifies(command string) bool {
+	name, _, _ := strings.Cut(command, "=")
+	if !strings.Contains(name, ".") {
+		return modifyingCommands[name]
+	}
+
+	for _, part := range strings.Split(strings.ToLower(name), ".") {
+		if modifyingCommands[part] {
+			return true
+		}
+		for _, prefix := range modifyingPrefixes {
+			if strings.HasPrefix(part, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	clientVersion = `<?xml version="1.0"?><methodCall><methodName>system.client_version</methodName><params></params></methodCall>`
+
+	stop = `<?xml version="1.0"?><methodCall><methodName>d.stop</methodName>` +
+		`<params><param><value><string>AAAA</string></value></param></params></methodCall>`
+)
+
+// flakyServer fails the first failures requests with status, and answers the
+// following ones. It counts the requests it received.
+func flakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Contains(t, string(body), "<methodCall>")
+
+		if requests.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><string>0.9.8</string></value></param></params></methodResponse>`)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &requests
+}
+
+// call sends the XML-RPC call through rt and returns the response status.
+func call(t *testing.T, rt http.RoundTripper, url, body string) (int, error) {
+	t.Helper()
+
+	return callContext(t, context.Background(), rt, url, body)
+}
+
+// callContext is call with the request sent with ctx.
+func callContext(t *testing.T, ctx context.Context, rt http.RoundTripper, url, body string) (int, error) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestTransport_Retries(t *testing.T) {
+	ts, requests := flakyServer(t, 2, http.StatusBadGateway)
+	rt := New(http.DefaultTransport, Opts{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+
+	status, err := call(t, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int32(3), requests.Load())
+
+	assert.Nil(t, testutil.CollectAndCompare(rt, strings.NewReader(`
+# HELP rtorrent_exporter_circuit_breaker_state State of the circuit breaker of calls to rTorrent (0 for closed, 1 for open, 2 for half-open).
+# TYPE rtorrent_exporter_circuit_breaker_state gauge
+rtorrent_exporter_circuit_breaker_state 0
+# HELP rtorrent_exporter_xmlrpc_failures_total Number of failed XML-RPC call attempts to rTorrent, retried or not.
+# TYPE rtorrent_exporter_xmlrpc_failures_total counter
+rtorrent_exporter_xmlrpc_failures_total{method="system.client_version"} 2
+# HELP rtorrent_exporter_xmlrpc_retries_total Number of XML-RPC calls to rTorrent retried after a failed attempt.
+# TYPE rtorrent_exporter_xmlrpc_retries_total counter
+rtorrent_exporter_xmlrpc_retries_total{method="system.client_version"} 2
+`)))
+}
+
+func TestTransport_NoRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   int
+	}{
+		{"attempts exhausted", http.StatusServiceUnavailable, clientVersion, http.StatusServiceUnavailable},
+		{"modifying call", http.StatusServiceUnavailable, stop, http.StatusServiceUnavailable},
+		{"client error", http.StatusUnauthorized, clientVersion, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, requests := flakyServer(t, 10, tt.status)
+			rt := New(http.DefaultTransport, Opts{Attempts: 2, InitialBackoff: time.Millisecond})
+
+			status, err := call(t, rt, ts.URL, tt.body)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, status)
+
+			want := int32(2)
+			if tt.body == stop || tt.status < http.StatusInternalServerError {
+				want = 1
+			}
+			assert.Equal(t, want, requests.Load())
+		})
+	}
+}
+
+func TestTransport_Deadline(t *testing.T) {
+	ts, requests := flakyServer(t, 10, http.StatusServiceUnavailable)
+	rt := New(http.DefaultTransport, Opts{Attempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Second})
+
+	// The backoff of the first retry would end past the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	status, err := callContext(t, ctx, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The deadline of one request doesn't bound the retries of another
+	rt.opts.InitialBackoff, rt.opts.MaxBackoff = time.Millisecond, time.Millisecond
+	_, err = call(t, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(6), requests.Load())
+}
+
+func TestTransport_CircuitBreaker(t *testing.T) {
+	ts, requests := flakyServer(t, 2, http.StatusInternalServerError)
+	rt := New(http.DefaultTransport, Opts{Attempts: 1, BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		_, err := call(t, rt, ts.URL, clientVersion)
+		assert.Nil(t, err)
+	}
+
+	// The breaker is open, rTorrent isn't called anymore
+	_, err := call(t, rt, ts.URL, clientVersion)
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Equal(t, StateOpen, rt.state)
+
+	// After the cooldown a single call checks on rTorrent, which recovered
+	time.Sleep(60 * time.Millisecond)
+	status, err := call(t, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, StateClosed, rt.state)
+}
+
+func TestTransport_CircuitBreakerHalfOpenFailure(t *testing.T) {
+	ts, requests := flakyServer(t, 10, http.StatusInternalServerError)
+	rt := New(http.DefaultTransport, Opts{Attempts: 3, BreakerThreshold: 1, BreakerCooldown: 20 * time.Millisecond})
+
+	_, err := call(t, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, StateOpen, rt.state)
+
+	// The half-open check isn't retried, and opens the breaker again
+	time.Sleep(30 * time.Millisecond)
+	_, err = call(t, rt, ts.URL, clientVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+	_, err = call(t, rt, ts.URL, clientVersion)
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+}
+
+func TestParseCall(t *testing.T) {
+	multicall := func(methods ...string) string {
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0"?><methodCall><methodName>system.multicall</methodName><params><param><value><array><data>`)
+		for _, m := range methods {
+			fmt.Fprintf(&b, `<value><struct><member><name>methodName</name><value><string>%s</string></value></member>`+
+				`<member><name>params</name><value><array><data><value><string></string></value></data></array></value></member>`+
+				`</struct></value>`, m)
+		}
+		b.WriteString(`</data></array></value></param></params></methodCall>`)
+		return b.String()
+	}
+	dMulticall := func(commands ...string) string {
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0"?><methodCall><methodName>d.multicall2</methodName><params>` +
+			`<param><value><string></string></value></param><param><value><string>started</string></value></param>`)
+		for _, c := range commands {
+			fmt.Fprintf(&b, `<param><value><string>%s</string></value></param>`, c)
+		}
+		b.WriteString(`</params></methodCall>`)
+		return b.String()
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		method   string
+		readOnly bool
+	}{
+		{"getter", clientVersion, "system.client_version", true},
+		{"stop", stop, "d.stop", false},
+		{"multicall of getters", multicall("view.size", "t.multicall"), "system.multicall", true},
+		{"multicall with a setter", multicall("view.size", "throttle.global_down.max_rate.set"), "system.multicall", false},
+		{"d.multicall2 of getters", dMulticall("d.hash=", "d.base_filename=", "d.load_date=", "d.is_open="), "d.multicall2", true},
+		{"d.multicall2 with a setter", dMulticall("d.hash=", "d.custom.set=label"), "d.multicall2", false},
+		{"d.multicall2 with a save", dMulticall("d.hash=", "d.save_full_session="), "d.multicall2", false},
+		{"execute", strings.ReplaceAll(clientVersion, "system.client_version", "execute.throw"), "execute.throw", false},
+		{"not XML", "not XML", "", false},
+		{"no body", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, readOnly := parseCall([]byte(tt.body))
+			assert.Equal(t, tt.method, method)
+			assert.Equal(t, tt.readOnly, readOnly)
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package rtorrentexporter
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// AggregateByCommand groups downloads by the value of a d.* command, such
+	// as the ruTorrent label in d.custom1= or a regex capture of d.directory=
+	AggregateByCommand = "command"
+
+	// AggregateByTracker groups downloads by the hostname of their first
+	// enabled tracker.
+	AggregateByTracker = "tracker"
+
+	// AggregateByView groups downloads by the rTorrent views they are in, a
+	// download is counted in each of its views.
+	AggregateByView = "view"
+
+	// noGroup is the group of downloads without a value for the grouping. It
+	// is empty, as any other value could also be that of a real group, such as
+	// a ruTorrent label named none.
+	noGroup = ""
+)
+
+var _ AggregateSource = &rtorrentrpc.DownloadService{}
+
+// An AggregateSource is a type which can retrieve the details of every
+// rTorrent download along with its trackers. It is implemented by
+// *rtorrentrpc.DownloadService and *session.Source.
+type AggregateSource interface {
+	AllWithDetails([]string) ([][]any, error)
+	TrackersWithDetails([]string, []string) ([][][]any, error)
+}
+
+// An Aggregation selects how downloads are grouped by the AggregateCollector.
+type Aggregation struct {
+	// By is one of AggregateByCommand, AggregateByTracker or AggregateByView.
+	By string
+
+	// Label holds the name of the label the groups are reported under. With
+	// AggregateByCommand its command and regex select the group of each
+	// download, the same way as for a download label.
+	Label DetailLabel
+}
+
+// ValidateAggregation checks that a can be collected.
+func ValidateAggregation(a Aggregation) error {
+	switch a.By {
+	case AggregateByCommand:
+		return ValidateDetailLabel(a.Label)
+	case AggregateByTracker, AggregateByView:
+		if a.Label.Command != "" || a.Label.Regex != nil {
+			return fmt.Errorf("a command or regex can't be used when aggregating by %s", a.By)
+		}
+		// Any valid source will do, only the label name is checked
+		return ValidateDetailLabel(DetailLabel{Name: a.Label.Name, Command: detailLabelSources[0]})
+	default:
+		return fmt.Errorf("unknown aggregation %q, must be %s, %s or %s", a.By, AggregateByCommand, AggregateByTracker, AggregateByView)
+	}
+}
+
+var (
+	// aggregateCommands are retrieved for every download, the order must match
+	// the fields decoded in parseAggregateRow. The command selecting the group
+	// of the download follows them.
+	aggregateCommands = []string{
+		"d.hash=",
+		"d.down.rate=",
+		"d.down.total=",
+		"d.up.rate=",
+		"d.up.total=",
+		"d.size_bytes=",
+		"d.completed_bytes=",
+		"d.complete=",
+		"d.state=",
+	}
+
+	// aggregateTrackerCommands are retrieved for every tracker of every
+	// download when aggregating by tracker.
+	aggregateTrackerCommands = []string{"t.url=", "t.is_enabled="}
+)
+
+// An AggregateCollector is a Prometheus collector for metrics regarding
+// rTorrent downloads aggregated by a single dimension, which keeps useful
+// breakdowns without a series per download.
+type AggregateCollector struct {
+	Downloads          *prometheus.Desc
+	Complete           *prometheus.Desc
+	Started            *prometheus.Desc
+	DownloadRateBytes  *prometheus.Desc
+	DownloadTotalBytes *prometheus.Desc
+	UploadRateBytes    *prometheus.Desc
+	UploadTotalBytes   *prometheus.Desc
+	SizeBytes          *prometheus.Desc
+	CompletedBytes     *prometheus.Desc
+
+	as  AggregateSource
+	agg Aggregation
+}
+
+// groupStats holds the aggregated statistics of a single group of downloads.
+type groupStats struct {
+	downloads int
+	complete  int
+	started   int
+	downRate  int64
+	downTotal int64
+	upRate    int64
+	upTotal   int64
+	size      int64
+	completed int64
+}
+
+// Verify that AggregateCollector implements the prometheus.Collector interface.
+var _ prometheus.Collector = &AggregateCollector{}
+
+// NewAggregateCollector creates a new AggregateCollector which collects
+// metrics regarding rTorrent downloads grouped as selected by agg.
+func NewAggregateCollector(as AggregateSource, agg Aggregation) *AggregateCollector {
+	const (
+		subsystem = "category"
+	)
+
+	var (
+		labels = []string{agg.Label.Name}
+	)
+
+	return &AggregateCollector{
+		Downloads: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "downloads"),
+			"Number of downloads in the category.",
+			labels,
+			nil,
+		),
+
+		Complete: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "complete"),
+			"Number of complete downloads in the category.",
+			labels,
+			nil,
+		),
+
+		Started: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "started"),
+			"Number of started downloads in the category.",
+			labels,
+			nil,
+		),
+
+		DownloadRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "download_rate_bytes"),
+			"Current download rate of the category in bytes.",
+			labels,
+			nil,
+		),
+
+		DownloadTotalBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "download_total_bytes"),
+			"Total Bytes downloaded by the downloads in the category.",
+			labels,
+			nil,
+		),
+
+		UploadRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "upload_rate_bytes"),
+			"Current upload rate of the category in bytes.",
+			labels,
+			nil,
+		),
+
+		UploadTotalBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "upload_total_bytes"),
+			"Total Bytes uploaded by the downloads in the category.",
+			labels,
+			nil,
+		),
+
+		SizeBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "size_bytes"),
+			"Total size of the downloads in the category in bytes.",
+			labels,
+			nil,
+		),
+
+		CompletedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "completed_bytes"),
+			"Bytes completed by the downloads in the category.",
+			labels,
+			nil,
+		),
+
+		as:  as,
+		agg: agg,
+	}
+}
+
+// commands returns the commands retrieved for every download.
+func (c *AggregateCollector) commands() []string {
+	cmds := make([]string, 0, len(aggregateCommands)+1)
+	cmds = append(cmds, aggregateCommands...)
+
+	switch c.agg.By {
+	case AggregateByCommand:
+		cmds = append(cmds, c.agg.Label.Command)
+	case AggregateByView:
+		cmds = append(cmds, "d.views=")
+	}
+
+	return cmds
+}
+
+// collect begins a metrics collection task for all metrics related to
+// aggregated rTorrent downloads.
+func (c *AggregateCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	cmds := c.commands()
+
+	// Every download is aggregated, not only those in the active view
+	rows, err := c.as.AllWithDetails(cmds)
+	if err != nil {
+		return c.Downloads, err
+	}
+
+	groups, err := c.downloadGroups(rows, len(cmds))
+	if err != nil {
+		return c.Downloads, err
+	}
+
+	stats := make(map[string]*groupStats)
+	for i, row := range rows {
+		if err := parseAggregateRow(row, groups[i], stats); err != nil {
+			return c.Downloads, err
+		}
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := stats[name]
+
+		ch <- prometheus.MustNewConstMetric(c.Downloads, prometheus.GaugeValue, float64(s.downloads), name)
+		ch <- prometheus.MustNewConstMetric(c.Complete, prometheus.GaugeValue, float64(s.complete), name)
+		ch <- prometheus.MustNewConstMetric(c.Started, prometheus.GaugeValue, float64(s.started), name)
+		ch <- prometheus.MustNewConstMetric(c.DownloadRateBytes, prometheus.GaugeValue, float64(s.downRate), name)
+		ch <- prometheus.MustNewConstMetric(c.DownloadTotalBytes, prometheus.GaugeValue, float64(s.downTotal), name)
+		ch <- prometheus.MustNewConstMetric(c.UploadRateBytes, prometheus.GaugeValue, float64(s.upRate), name)
+		ch <- prometheus.MustNewConstMetric(c.UploadTotalBytes, prometheus.GaugeValue, float64(s.upTotal), name)
+		ch <- prometheus.MustNewConstMetric(c.SizeBytes, prometheus.GaugeValue, float64(s.size), name)
+		ch <- prometheus.MustNewConstMetric(c.CompletedBytes, prometheus.GaugeValue, float64(s.completed), name)
+	}
+
+	return nil, nil
+}
+
+// downloadGroups returns the groups each of the provided rows belongs to.
+func (c *AggregateCollector) downloadGroups(rows [][]any, width int) ([][]string, error) {
+	groups := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != width {
+			return nil, fmt.Errorf("expected %d download values, got %d", width, len(row))
+		}
+
+		switch c.agg.By {
+		case AggregateByCommand:
+			v, ok := row[len(aggregateCommands)].(string)
+			if !ok {
+				return nil, fmt.Errorf("failed to convert %s value of type %T to string",
+					c.agg.Label.Command, row[len(aggregateCommands)])
+			}
+			groups = append(groups, []string{c.agg.Label.value(v)})
+		case AggregateByView:
+			views, ok := row[len(aggregateCommands)].([]any)
+			if !ok {
+				return nil, fmt.Errorf("failed to convert d.views= value of type %T to list", row[len(aggregateCommands)])
+			}
+			g := make([]string, 0, len(views))
+			for _, v := range views {
+				if s, ok := v.(string); ok {
+					g = append(g, s)
+				}
+			}
+			groups = append(groups, g)
+		default:
+			groups = append(groups, nil)
+		}
+	}
+
+	if c.agg.By == AggregateByTracker {
+		return c.trackerGroups(rows)
+	}
+
+	return groups, nil
+}
+
+// trackerGroups returns the tracker hostname of each of the provided rows.
+func (c *AggregateCollector) trackerGroups(rows [][]any) ([][]string, error) {
+	hashes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		hash, ok := row[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to convert d.hash= value of type %T to string", row[0])
+		}
+		hashes = append(hashes, hash)
+	}
+
+	trackers, err := c.as.TrackersWithDetails(hashes, aggregateTrackerCommands)
+	if err != nil {
+		return nil, err
+	}
+	if len(trackers) != len(hashes) {
+		return nil, fmt.Errorf("expected trackers for %d downloads, got %d", len(hashes), len(trackers))
+	}
+
+	groups := make([][]string, 0, len(trackers))
+	for _, tt := range trackers {
+		group := noGroup
+		for _, t := range tt {
+			if len(t) != len(aggregateTrackerCommands) {
+				return nil, fmt.Errorf("expected %d tracker values, got %d", len(aggregateTrackerCommands), len(t))
+			}
+			url, _ := t[0].(string)
+			enabled, _ := t[1].(int64)
+			domain := trackerDomain(url)
+			// rTorrent lists its DHT pseudo tracker among the trackers of
+			// every public download, it says nothing about where it came from
+			if enabled != 0 && domain != "dht" {
+				group = domain
+				break
+			}
+		}
+		groups = append(groups, []string{group})
+	}
+
+	return groups, nil
+}
+
+// parseAggregateRow adds a single download row, as retrieved with
+// aggregateCommands, to the statistics of each of its groups.
+func parseAggregateRow(row []any, groups []string, stats map[string]*groupStats) error {
+	ints := make([]int64, 0, len(aggregateCommands)-1)
+	for i, v := range row[1:len(aggregateCommands)] {
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("failed to convert %s value of type %T to int", aggregateCommands[i+1], v)
+		}
+		ints = append(ints, n)
+	}
+
+	for _, g := range groups {
+		s, ok := stats[g]
+		if !ok {
+			s = &groupStats{}
+			stats[g] = s
+		}
+
+		s.downloads++
+		s.downRate += ints[0]
+		s.downTotal += ints[1]
+		s.upRate += ints[2]
+		s.upTotal += ints[3]
+		s.size += ints[4]
+		s.completed += ints[5]
+		if ints[6] != 0 {
+			s.complete++
+		}
+		if ints[7] != 0 {
+			s.started++
+		}
+	}
+
+	return nil
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *AggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.Downloads,
+		c.Complete,
+		c.Started,
+		c.DownloadRateBytes,
+		c.DownloadTotalBytes,
+		c.UploadRateBytes,
+		c.UploadTotalBytes,
+		c.SizeBytes,
+		c.CompletedBytes,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect sends the metric values for each metric pertaining to the aggregated
+// rTorrent downloads to the provided prometheus Metric channel.
+func (c *AggregateCollector) Collect(ch chan<- prometheus.Metric) {
+	if desc, err := c.collect(ch); err != nil {
+		log.Printf("[ERROR] failed collecting aggregate metric %v: %v", desc, err)
+		ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+}
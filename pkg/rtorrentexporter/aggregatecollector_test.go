@@ -0,0 +1,231 @@
+package rtorrentexporter
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// aggregateRow returns a row of aggregateCommands values followed by the
+// provided group value.
+func aggregateRow(hash string, downRate, size, completed, complete, started int64, group any) []any {
+	row := []any{hash, downRate, int64(1000), int64(10), int64(2000), size, completed, complete, started}
+	if group != nil {
+		row = append(row, group)
+	}
+	return row
+}
+
+func TestAggregateCollector_CollectByCommand(t *testing.T) {
+	agg := Aggregation{
+		By:    AggregateByCommand,
+		Label: DetailLabel{Name: "category", Command: "d.directory=", Regex: regexp.MustCompile(`^/data/([^/]+)`)},
+	}
+
+	ds := new(MockDownloadsSource)
+	ds.On("AllWithDetails", append(aggregateCommands, "d.directory=")).Return([][]any{
+		aggregateRow("hash1", 100, 4096, 4096, 1, 1, "/data/movies/a"),
+		aggregateRow("hash2", 50, 1024, 512, 0, 1, "/data/movies/b"),
+		aggregateRow("hash3", 0, 2048, 2048, 1, 0, "/data/tv/c"),
+		aggregateRow("hash4", 0, 10, 0, 0, 0, "/srv/other"),
+	}, nil)
+
+	expected := `
+# HELP rtorrent_category_complete Number of complete downloads in the category.
+# TYPE rtorrent_category_complete gauge
+rtorrent_category_complete{category="movies"} 1
+rtorrent_category_complete{category=""} 0
+rtorrent_category_complete{category="tv"} 1
+# HELP rtorrent_category_completed_bytes Bytes completed by the downloads in the category.
+# TYPE rtorrent_category_completed_bytes gauge
+rtorrent_category_completed_bytes{category="movies"} 4608
+rtorrent_category_completed_bytes{category=""} 0
+rtorrent_category_completed_bytes{category="tv"} 2048
+# HELP rtorrent_category_download_rate_bytes Current download rate of the category in bytes.
+# TYPE rtorrent_category_download_rate_bytes gauge
+rtorrent_category_download_rate_bytes{category="movies"} 150
+rtorrent_category_download_rate_bytes{category=""} 0
+rtorrent_category_download_rate_bytes{category="tv"} 0
+# HELP rtorrent_category_download_total_bytes Total Bytes downloaded by the downloads in the category.
+# TYPE rtorrent_category_download_total_bytes gauge
+rtorrent_category_download_total_bytes{category="movies"} 2000
+rtorrent_category_download_total_bytes{category=""} 1000
+rtorrent_category_download_total_bytes{category="tv"} 1000
+# HELP rtorrent_category_downloads Number of downloads in the category.
+# TYPE rtorrent_category_downloads gauge
+rtorrent_category_downloads{category="movies"} 2
+rtorrent_category_downloads{category=""} 1
+rtorrent_category_downloads{category="tv"} 1
+# HELP rtorrent_category_size_bytes Total size of the downloads in the category in bytes.
+# TYPE rtorrent_category_size_bytes gauge
+rtorrent_category_size_bytes{category="movies"} 5120
+rtorrent_category_size_bytes{category=""} 10
+rtorrent_category_size_bytes{category="tv"} 2048
+# HELP rtorrent_category_started Number of started downloads in the category.
+# TYPE rtorrent_category_started gauge
+rtorrent_category_started{category="movies"} 2
+rtorrent_category_started{category=""} 0
+rtorrent_category_started{category="tv"} 0
+# HELP rtorrent_category_upload_rate_bytes Current upload rate of the category in bytes.
+# TYPE rtorrent_category_upload_rate_bytes gauge
+rtorrent_category_upload_rate_bytes{category="movies"} 20
+rtorrent_category_upload_rate_bytes{category=""} 10
+rtorrent_category_upload_rate_bytes{category="tv"} 10
+# HELP rtorrent_category_upload_total_bytes Total Bytes uploaded by the downloads in the category.
+# TYPE rtorrent_category_upload_total_bytes gauge
+rtorrent_category_upload_total_bytes{category="movies"} 4000
+rtorrent_category_upload_total_bytes{category=""} 2000
+rtorrent_category_upload_total_bytes{category="tv"} 2000
+`
+
+	assert.Nil(t, testutil.CollectAndCompare(NewAggregateCollector(ds, agg), strings.NewReader(expected)))
+	ds.AssertNotCalled(t, "TrackersWithDetails", mock.Anything, mock.Anything)
+}
+
+func TestAggregateCollector_CollectLabelNone(t *testing.T) {
+	// A ruTorrent label named none is kept apart from unlabeled downloads
+	ds := new(MockDownloadsSource)
+	ds.On("AllWithDetails", append(aggregateCommands, "d.custom1=")).Return([][]any{
+		aggregateRow("hash1", 100, 1, 1, 1, 1, "none"),
+		aggregateRow("hash2", 50, 1, 1, 1, 1, ""),
+		aggregateRow("hash3", 25, 1, 1, 1, 1, ""),
+	}, nil)
+
+	collector := NewAggregateCollector(ds, Aggregation{By: AggregateByCommand, Label: DetailLabel{Name: "label", Command: "d.custom1="}})
+
+	expected := `
+# HELP rtorrent_category_downloads Number of downloads in the category.
+# TYPE rtorrent_category_downloads gauge
+rtorrent_category_downloads{label=""} 2
+rtorrent_category_downloads{label="none"} 1
+`
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "rtorrent_category_downloads"))
+}
+
+func TestAggregateCollector_CollectByTracker(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	ds.On("AllWithDetails", aggregateCommands).Return([][]any{
+		aggregateRow("hash1", 100, 1, 1, 1, 1, nil),
+		aggregateRow("hash2", 50, 1, 1, 1, 1, nil),
+		aggregateRow("hash3", 25, 1, 1, 1, 1, nil),
+	}, nil)
+	ds.On("TrackersWithDetails", []string{"hash1", "hash2", "hash3"}, aggregateTrackerCommands).Return([][][]any{
+		{{"dht://", int64(1)}, {"https://disabled.example.org/announce", int64(0)}, {"https://a.example.org/announce", int64(1)}},
+		{{"udp://A.example.org:6969/announce", int64(1)}},
+		{{"dht://", int64(1)}},
+	}, nil)
+
+	collector := NewAggregateCollector(ds, Aggregation{By: AggregateByTracker, Label: DetailLabel{Name: "tracker"}})
+
+	expected := `
+# HELP rtorrent_category_download_rate_bytes Current download rate of the category in bytes.
+# TYPE rtorrent_category_download_rate_bytes gauge
+rtorrent_category_download_rate_bytes{tracker="a.example.org"} 150
+rtorrent_category_download_rate_bytes{tracker=""} 25
+`
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "rtorrent_category_download_rate_bytes"))
+}
+
+func TestAggregateCollector_CollectByView(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	ds.On("AllWithDetails", append(aggregateCommands, "d.views=")).Return([][]any{
+		aggregateRow("hash1", 100, 1, 1, 1, 1, []any{"seeding", "started"}),
+		aggregateRow("hash2", 50, 1, 1, 1, 1, []any{"started"}),
+		aggregateRow("hash3", 25, 1, 1, 1, 1, []any{}),
+	}, nil)
+
+	collector := NewAggregateCollector(ds, Aggregation{By: AggregateByView, Label: DetailLabel{Name: "view"}})
+
+	expected := `
+# HELP rtorrent_category_downloads Number of downloads in the category.
+# TYPE rtorrent_category_downloads gauge
+rtorrent_category_downloads{view="seeding"} 1
+rtorrent_category_downloads{view="started"} 2
+`
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "rtorrent_category_downloads"))
+}
+
+func TestAggregateCollector_CollectInactive(t *testing.T) {
+	agg := Aggregation{By: AggregateByCommand, Label: DetailLabel{Name: "label", Command: "d.custom1="}}
+
+	// Only hash1 is transferring data, the stopped and idle seeding downloads
+	// must be counted all the same
+	ds := new(MockDownloadsSource)
+	ds.On("DownloadWithDetails", mock.Anything).Return([][]any{
+		aggregateRow("hash1", 100, 1024, 1024, 1, 1, "linux"),
+	}, nil)
+	ds.On("AllWithDetails", append(aggregateCommands, "d.custom1=")).Return([][]any{
+		aggregateRow("hash1", 100, 1024, 1024, 1, 1, "linux"),
+		aggregateRow("hash2", 0, 2048, 2048, 1, 1, "linux"),
+		aggregateRow("hash3", 0, 4096, 0, 0, 0, "linux"),
+	}, nil)
+
+	expected := `
+# HELP rtorrent_category_completed_bytes Bytes completed by the downloads in the category.
+# TYPE rtorrent_category_completed_bytes gauge
+rtorrent_category_completed_bytes{label="linux"} 3072
+# HELP rtorrent_category_downloads Number of downloads in the category.
+# TYPE rtorrent_category_downloads gauge
+rtorrent_category_downloads{label="linux"} 3
+# HELP rtorrent_category_size_bytes Total size of the downloads in the category in bytes.
+# TYPE rtorrent_category_size_bytes gauge
+rtorrent_category_size_bytes{label="linux"} 7168
+`
+	assert.Nil(t, testutil.CollectAndCompare(NewAggregateCollector(ds, agg), strings.NewReader(expected),
+		"rtorrent_category_downloads", "rtorrent_category_size_bytes", "rtorrent_category_completed_bytes"))
+	ds.AssertNotCalled(t, "DownloadWithDetails", mock.Anything)
+}
+
+func TestAggregateCollector_collectError(t *testing.T) {
+	agg := Aggregation{By: AggregateByCommand, Label: DetailLabel{Name: "label", Command: "d.custom1="}}
+
+	tests := []struct {
+		name string
+		rows [][]any
+		err  error
+	}{
+		{"source error", nil, errors.New("connection refused")},
+		{"short row", [][]any{{"hash1", int64(1)}}, nil},
+		{"bad value", [][]any{{"hash1", "1", int64(1), int64(1), int64(1), int64(1), int64(1), int64(1), int64(1), "movies"}}, nil},
+		{"bad group", [][]any{aggregateRow("hash1", 1, 1, 1, 1, 1, int64(1))}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := new(MockDownloadsSource)
+			ds.On("AllWithDetails", mock.Anything).Return(tt.rows, tt.err)
+
+			collector := NewAggregateCollector(ds, agg)
+			desc, err := collector.collect(nil)
+			assert.Equal(t, collector.Downloads, desc)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestValidateAggregation(t *testing.T) {
+	tests := []struct {
+		name string
+		agg  Aggregation
+		ok   bool
+	}{
+		{"command", Aggregation{By: AggregateByCommand, Label: DetailLabel{Name: "label", Command: "d.custom1="}}, true},
+		{"tracker", Aggregation{By: AggregateByTracker, Label: DetailLabel{Name: "tracker"}}, true},
+		{"view", Aggregation{By: AggregateByView, Label: DetailLabel{Name: "view"}}, true},
+		{"unknown", Aggregation{By: "ratio", Label: DetailLabel{Name: "ratio"}}, false},
+		{"bad command", Aggregation{By: AggregateByCommand, Label: DetailLabel{Name: "label", Command: "d.ratio="}}, false},
+		{"tracker with command", Aggregation{By: AggregateByTracker, Label: DetailLabel{Name: "tracker", Command: "d.custom1="}}, false},
+		{"invalid label", Aggregation{By: AggregateByView, Label: DetailLabel{Name: "my-view"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ok, ValidateAggregation(tt.agg) == nil)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package rtorrentexporter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ParseNumber parses integers, floats and numeric strings, such as the
+	// values of d.custom=key. Empty strings have no value.
+	ParseNumber = "number"
+
+	// ParseBool parses integers and strings as 1 when they are non-zero or
+	// true, and 0 otherwise.
+	ParseBool = "bool"
+)
+
+var (
+	// detailParsers maps the name of each value parser to its implementation.
+	detailParsers = map[string]func(any) (float64, error){
+		ParseNumber: numberValue,
+		ParseBool:   boolValue,
+	}
+
+	// metricNameRE matches the valid characters of a metric name.
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// builtinDownloadMetrics holds the names of the metrics reported by the
+	// DownloadsCollector itself, after rtorrent_downloads_, which a column
+	// can't be named after.
+	builtinDownloadMetrics = map[string]bool{
+		"started":              true,
+		"stopped":              true,
+		"complete":             true,
+		"incomplete":           true,
+		"hashing":              true,
+		"seeding":              true,
+		"leeching":             true,
+		"active":               true,
+		"download_rate_bytes":  true,
+		"download_bytes_total": true,
+		"download_total_bytes": true,
+		"upload_rate_bytes":    true,
+		"upload_bytes_total":   true,
+		"upload_total_bytes":   true,
+		"peers_connected":      true,
+		"peers_accounted":      true,
+		"peers_complete":       true,
+		"peers_not_connected":  true,
+		"tracker_seeders":      true,
+		"tracker_leechers":     true,
+		"filtered":             true,
+	}
+
+	// errNoValue is returned by a value parser when a download has no value
+	// for the column, in which case no metric is reported for it.
+	errNoValue = errors.New("no value")
+)
+
+// A DetailColumn declares a metric reported for every download from the value
+// of an arbitrary d.* command, along with the standard download labels.
+type DetailColumn struct {
+	// Command is the d.* command retrieved for every download, e.g.
+	// d.size_bytes= or d.custom=seedtime
+	Command string
+
+	// Name is the name of the metric, which is prefixed with
+	// rtorrent_downloads_
+	Name string
+
+	Help string
+
+	// Type is the type of the metric, it defaults to a gauge when zero.
+	Type prometheus.ValueType
+
+	// Scale multiplies each value, it defaults to 1 when zero. For instance
+	// d.ratio= is reported in thousandths and can be scaled with 0.001.
+	Scale float64
+
+	// Parser names how values are converted, ParseNumber when empty.
+	Parser string
+}
+
+// ValidateDetailColumn checks that col can be collected.
+func ValidateDetailColumn(col DetailColumn) error {
+	if !strings.HasPrefix(col.Command, "d.") || !strings.Contains(col.Command, "=") {
+		return fmt.Errorf("command %q must be a d.* command such as d.size_bytes=", col.Command)
+	}
+	if !metricNameRE.MatchString(col.Name) {
+		return fmt.Errorf("invalid metric name %q", col.Name)
+	}
+	if builtinDownloadMetrics[col.Name] {
+		return fmt.Errorf("metric name %q is already used by rtorrent_downloads_%s", col.Name, col.Name)
+	}
+	switch col.Type {
+	case 0, prometheus.GaugeValue, prometheus.CounterValue:
+	default:
+		return fmt.Errorf("metric type must be a gauge or counter")
+	}
+	if _, ok := detailParsers[col.Parser]; !ok && col.Parser != "" {
+		return fmt.Errorf("unknown parser %q, must be %s or %s", col.Parser, ParseNumber, ParseBool)
+	}
+	return nil
+}
+
+// detailColumn returns the column collecting col in the provided subsystem.
+func (col DetailColumn) detailColumn(subsystem string, labels []string) detailColumn {
+	help := col.Help
+	if help == "" {
+		help = fmt.Sprintf("Value of %s for the download.", col.Command)
+	}
+
+	valueType := col.Type
+	if valueType == 0 {
+		valueType = prometheus.GaugeValue
+	}
+
+	scale := col.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	parse, ok := detailParsers[col.Parser]
+	if !ok {
+		parse = numberValue
+	}
+
+	return detailColumn{
+		cmd:       col.Command,
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, col.Name), help, labels, nil),
+		valueType: valueType,
+		value: func(v any) (float64, error) {
+			f, err := parse(v)
+			return f * scale, err
+		},
+	}
+}
+
+// numberValue converts an integer, float or numeric string returned by
+// rTorrent into a metric value.
+func numberValue(v any) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		n = strings.TrimSpace(n)
+		if n == "" {
+			return 0, errNoValue
+		}
+		return strconv.ParseFloat(n, 64)
+	default:
+		return intValue(v)
+	}
+}
+
+// boolValue converts an integer or string returned by rTorrent into 1 when it
+// is non-zero or true, and 0 otherwise.
+func boolValue(v any) (float64, error) {
+	if s, ok := v.(string); ok {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "", "0", "false", "no":
+			return 0, nil
+		default:
+			return 1, nil
+		}
+	}
+
+	n, err := intValue(v)
+	if err != nil {
+		return 0, err
+	}
+	if n != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
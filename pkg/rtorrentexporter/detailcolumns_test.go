@@ -0,0 +1,154 @@
+package rtorrentexporter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadsCollector_detailColumns(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{
+		DownloadDetails: true,
+		DetailColumns: []DetailColumn{
+			{Command: "d.size_bytes=", Name: "size_bytes"},
+			{Command: "d.ratio=", Name: "ratio", Scale: 0.001},
+			{Command: "d.custom=seedtime", Name: "seedtime_seconds", Type: prometheus.CounterValue},
+			{Command: "d.custom=added", Name: "added"},
+			{Command: "d.custom=private", Name: "private", Parser: ParseBool},
+		},
+	})
+
+	assert.Equal(t, []string{
+		"d.hash=", "d.base_filename=", "d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total=",
+		"d.size_bytes=", "d.ratio=", "d.custom=seedtime", "d.custom=added", "d.custom=private",
+	}, collector.getDownloadDetailCommands())
+
+	row := []any{
+		"hash1", "name1", int64(100), int64(200), int64(300), int64(400),
+		int64(4096), int64(1500), "3600", "", "true",
+	}
+
+	d, desc, err := collector.decodeDownloadDetails(row)
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		collector.sendDownloadDetails(d, ch)
+	}()
+
+	type value struct {
+		v       float64
+		counter bool
+	}
+	got := make(map[string]value)
+	for m := range ch {
+		var pb dto.Metric
+		assert.Nil(t, m.Write(&pb))
+		got[m.Desc().String()] = value{metricValue(t, m), pb.Counter != nil}
+	}
+
+	cols := collector.detailColumns[4:]
+	assert.Equal(t, value{4096, false}, got[cols[0].desc.String()])
+	assert.Equal(t, value{1.5, false}, got[cols[1].desc.String()])
+	assert.Equal(t, value{3600, true}, got[cols[2].desc.String()])
+	// Downloads without a custom value have no metric
+	assert.NotContains(t, got, cols[3].desc.String())
+	assert.Equal(t, value{1, false}, got[cols[4].desc.String()])
+	assert.Contains(t, cols[0].desc.String(), `"rtorrent_downloads_size_bytes"`)
+}
+
+func TestValidateDetailColumn(t *testing.T) {
+	tests := []struct {
+		name string
+		col  DetailColumn
+		ok   bool
+	}{
+		{"valid", DetailColumn{Command: "d.size_bytes=", Name: "size_bytes"}, true},
+		{"custom", DetailColumn{Command: "d.custom=seedtime", Name: "seedtime", Parser: ParseNumber}, true},
+		{"not a d command", DetailColumn{Command: "t.url=", Name: "url"}, false},
+		{"missing equals", DetailColumn{Command: "d.size_bytes", Name: "size_bytes"}, false},
+		{"invalid name", DetailColumn{Command: "d.size_bytes=", Name: "size-bytes"}, false},
+		{"empty name", DetailColumn{Command: "d.size_bytes=", Name: ""}, false},
+		{"unknown parser", DetailColumn{Command: "d.size_bytes=", Name: "size_bytes", Parser: "hex"}, false},
+		{"invalid type", DetailColumn{Command: "d.size_bytes=", Name: "size_bytes", Type: prometheus.UntypedValue}, false},
+		{"built-in metric", DetailColumn{Command: "d.down.rate=", Name: "download_rate_bytes"}, false},
+		{"built-in count", DetailColumn{Command: "d.is_active=", Name: "active"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ok, ValidateDetailColumn(tt.col) == nil)
+		})
+	}
+}
+
+func TestBuiltinDownloadMetrics(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{
+		DownloadDetails:  true,
+		DownloadPeers:    true,
+		LegacyTotalBytes: true,
+		Filters:          []DownloadFilter{{Rule: "r", Field: FilterByName, Pattern: regexp.MustCompile("x")}},
+	})
+
+	ch := make(chan *prometheus.Desc, 64)
+	collector.Describe(ch)
+	close(ch)
+
+	// Every metric of the collector must be reserved, for a column named after
+	// it to be rejected instead of failing its registration
+	fqNameRE := regexp.MustCompile(`fqName: "rtorrent_downloads_([^"]+)"`)
+	for desc := range ch {
+		if m := fqNameRE.FindStringSubmatch(desc.String()); m != nil {
+			assert.True(t, builtinDownloadMetrics[m[1]], "%s isn't reserved", m[1])
+		}
+	}
+}
+
+func TestNumberValue(t *testing.T) {
+	tests := []struct {
+		in   any
+		want float64
+		err  bool
+	}{
+		{int64(42), 42, false},
+		{42, 42, false},
+		{1.5, 1.5, false},
+		{" 17 ", 17, false},
+		{"abc", 0, true},
+		{[]any{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := numberValue(tt.in)
+		assert.Equal(t, tt.err, err != nil, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	_, err := numberValue("")
+	assert.ErrorIs(t, err, errNoValue)
+}
+
+func TestBoolValue(t *testing.T) {
+	tests := []struct {
+		in   any
+		want float64
+	}{
+		{int64(0), 0},
+		{int64(2), 1},
+		{"", 0},
+		{"false", 0},
+		{"yes", 1},
+		{"1", 1},
+	}
+
+	for _, tt := range tests {
+		got, err := boolValue(tt.in)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
@@ -0,0 +1,89 @@
+package rtorrentexporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// labelNameRE matches the valid characters of a label name.
+	labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// detailLabelSources are the commands which extra download labels can be
+	// sourced from, besides d.custom=key.
+	detailLabelSources = []string{
+		"d.custom1=", "d.custom2=", "d.custom3=", "d.custom4=", "d.custom5=",
+		"d.directory=", "d.base_path=",
+	}
+)
+
+// A DetailLabel declares a label added to the metrics of every download, with
+// its value sourced from a d.* command such as the ruTorrent label in
+// d.custom1.
+type DetailLabel struct {
+	// Name is the name of the label.
+	Name string
+
+	// Command is the command the label value is retrieved with, one of
+	// d.custom1= to d.custom5=, d.custom=key, d.directory= or d.base_path=
+	Command string
+
+	// Regex, if set, is matched against the value of Command and the label
+	// value is its first capture group, or the whole match if it has none.
+	// The label value is empty when the regex doesn't match.
+	Regex *regexp.Regexp
+}
+
+// ValidateDetailLabel checks that l can be collected.
+func ValidateDetailLabel(l DetailLabel) error {
+	if !labelNameRE.MatchString(l.Name) || strings.HasPrefix(l.Name, "__") {
+		return fmt.Errorf("invalid label name %q", l.Name)
+	}
+	switch l.Name {
+	case "info_hash", "name", InstanceLabel:
+		return fmt.Errorf("label name %q is reserved", l.Name)
+	}
+
+	if strings.HasPrefix(l.Command, "d.custom=") && len(l.Command) > len("d.custom=") {
+		return nil
+	}
+	for _, src := range detailLabelSources {
+		if l.Command == src {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q must be one of %s or d.custom=key", l.Command, strings.Join(detailLabelSources, ", "))
+}
+
+// RevealsNames reports whether the label values may hold download names, which
+// NamePrivacy can't hide: d.base_path= ends with the name of the download, and
+// so does d.directory= for multi-file downloads unless a regex captures part
+// of it.
+func (l DetailLabel) RevealsNames() bool {
+	switch l.Command {
+	case "d.base_path=":
+		return true
+	case "d.directory=":
+		return l.Regex == nil
+	default:
+		return false
+	}
+}
+
+// value returns the label value for the value of the label's command.
+func (l DetailLabel) value(v string) string {
+	if l.Regex == nil {
+		return v
+	}
+
+	m := l.Regex.FindStringSubmatch(v)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
@@ -0,0 +1,111 @@
+package rtorrentexporter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadsCollector_detailLabels(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{
+		DownloadDetails: true,
+		DetailLabels: []DetailLabel{
+			{Name: "label", Command: "d.custom1="},
+			{Name: "category", Command: "d.directory=", Regex: regexp.MustCompile(`^/data/([^/]+)/`)},
+			{Name: "owner", Command: "d.custom=owner"},
+		},
+	})
+
+	assert.Equal(t, []string{
+		"d.hash=", "d.base_filename=", "d.custom1=", "d.directory=", "d.custom=owner",
+		"d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total=",
+	}, collector.getDownloadDetailCommands())
+
+	row := []any{
+		"hash1", "name1", "Linux ISOs", "/data/linux-isos/debian", "",
+		int64(100), int64(200), int64(300), int64(400),
+	}
+
+	d, desc, err := collector.decodeDownloadDetails(row)
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		collector.sendDownloadDetails(d, ch)
+	}()
+
+	count := 0
+	for m := range ch {
+		count++
+		assert.Equal(t, "hash1", metricLabel(t, m, "info_hash"))
+		assert.Equal(t, "name1", metricLabel(t, m, "name"))
+		assert.Equal(t, "Linux ISOs", metricLabel(t, m, "label"))
+		assert.Equal(t, "linux-isos", metricLabel(t, m, "category"))
+		assert.Equal(t, "", metricLabel(t, m, "owner"))
+	}
+	assert.Equal(t, 4, count)
+}
+
+func TestDetailLabel_value(t *testing.T) {
+	tests := []struct {
+		name  string
+		regex string
+		in    string
+		want  string
+	}{
+		{"no regex", "", "/data/tv/show", "/data/tv/show"},
+		{"capture group", `^/data/([^/]+)`, "/data/tv/show", "tv"},
+		{"whole match", `movies|tv`, "/data/tv/show", "tv"},
+		{"no match", `^/srv/([^/]+)`, "/data/tv/show", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := DetailLabel{Name: "category", Command: "d.directory="}
+			if tt.regex != "" {
+				l.Regex = regexp.MustCompile(tt.regex)
+			}
+			assert.Equal(t, tt.want, l.value(tt.in))
+		})
+	}
+}
+
+func TestValidateDetailLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		label DetailLabel
+		ok    bool
+	}{
+		{"custom1", DetailLabel{Name: "label", Command: "d.custom1="}, true},
+		{"custom5", DetailLabel{Name: "label", Command: "d.custom5="}, true},
+		{"custom key", DetailLabel{Name: "owner", Command: "d.custom=owner"}, true},
+		{"base path", DetailLabel{Name: "category", Command: "d.base_path="}, true},
+		{"custom without key", DetailLabel{Name: "owner", Command: "d.custom="}, false},
+		{"custom6", DetailLabel{Name: "label", Command: "d.custom6="}, false},
+		{"other command", DetailLabel{Name: "ratio", Command: "d.ratio="}, false},
+		{"invalid name", DetailLabel{Name: "my-label", Command: "d.custom1="}, false},
+		{"reserved prefix", DetailLabel{Name: "__label", Command: "d.custom1="}, false},
+		{"reserved name", DetailLabel{Name: "name", Command: "d.custom1="}, false},
+		{"instance label", DetailLabel{Name: InstanceLabel, Command: "d.custom1="}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ok, ValidateDetailLabel(tt.label) == nil)
+		})
+	}
+}
+
+func TestDetailLabel_RevealsNames(t *testing.T) {
+	re := regexp.MustCompile(`^/data/([^/]+)`)
+
+	assert.True(t, DetailLabel{Name: "path", Command: "d.base_path="}.RevealsNames())
+	assert.True(t, DetailLabel{Name: "path", Command: "d.base_path=", Regex: re}.RevealsNames())
+	assert.True(t, DetailLabel{Name: "dir", Command: "d.directory="}.RevealsNames())
+	assert.False(t, DetailLabel{Name: "dir", Command: "d.directory=", Regex: re}.RevealsNames())
+	assert.False(t, DetailLabel{Name: "label", Command: "d.custom1="}.RevealsNames())
+}
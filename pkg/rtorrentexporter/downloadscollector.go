@@ -1,17 +1,21 @@
 package rtorrentexporter
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
-	"github.com/aauren/rtorrent/rtorrent"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var _ DownloadsSource = &rtorrent.DownloadService{}
+var _ DownloadsSource = &rtorrentrpc.DownloadService{}
 
 // A DownloadsSource is a type which can retrieve downloads information from
-// rTorrent.  It is implemented by *rtorrent.DownloadService.
+// rTorrent.  It is implemented by *rtorrentrpc.DownloadService and
+// *session.Source.
 type DownloadsSource interface {
 	All() ([]string, error)
 	Started() ([]string, error)
@@ -23,6 +27,9 @@ type DownloadsSource interface {
 	Leeching() ([]string, error)
 	Active() ([]string, error)
 	DownloadWithDetails([]string) ([][]any, error)
+	AllWithDetails([]string) ([][]any, error)
+	ViewSizes([]string) ([]int, error)
+	TrackersWithDetails([]string, []string) ([][][]any, error)
 
 	BaseFilename(infoHash string) (string, error)
 	DownloadRate(infoHash string) (int, error)
@@ -43,24 +50,102 @@ type DownloadsCollector struct {
 	DownloadsSeeding    *prometheus.Desc
 	DownloadsLeeching   *prometheus.Desc
 	DownloadsActive     *prometheus.Desc
+	DownloadsFiltered   *prometheus.Desc
 
 	DownloadRateBytes  *prometheus.Desc
-	DownloadTotalBytes *prometheus.Desc
+	DownloadBytesTotal *prometheus.Desc
 	UploadRateBytes    *prometheus.Desc
+	UploadBytesTotal   *prometheus.Desc
+
+	// DownloadTotalBytes and UploadTotalBytes are the deprecated gauges of the
+	// byte totals, they are nil unless LegacyTotalBytes is set.
+	DownloadTotalBytes *prometheus.Desc
 	UploadTotalBytes   *prometheus.Desc
 
+	PeersConnected    *prometheus.Desc
+	PeersAccounted    *prometheus.Desc
+	PeersComplete     *prometheus.Desc
+	PeersNotConnected *prometheus.Desc
+	TrackerSeeders    *prometheus.Desc
+	TrackerLeechers   *prometheus.Desc
+
 	ds DownloadsSource
 
 	collectOpts *CollectorOpts
+
+	// detailLabels are retrieved for every download after the
+	// detailLabelCommands, and detailColumns after them, in this order.
+	detailLabels  []DetailLabel
+	detailColumns []detailColumn
+
+	// top holds the info hashes of the top downloads selected by the last
+	// collection, see selectTopDownloads.
+	topMu sync.Mutex
+	top   map[string]bool
+}
+
+// A detailColumn is a d.* command retrieved for every download along with the
+// metric its value is reported as.
+type detailColumn struct {
+	cmd       string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	// legacyDesc, if set, is a deprecated gauge reported with the same value.
+	legacyDesc *prometheus.Desc
+	// value converts the value returned by rTorrent into the metric value.
+	value func(v any) (float64, error)
 }
 
 type CollectorOpts struct {
 	DownloadDetails bool
 	CollectURLs     bool
+	// DownloadPeers adds per-torrent peer connection and tracker scrape metrics
+	// to the download details, it has no effect unless DownloadDetails is set.
+	DownloadPeers bool
+	// Trackers enables the TrackersCollector, which reports announce health
+	// aggregated by tracker hostname.
+	Trackers bool
+	// DetailColumns are reported for every download in addition to the
+	// built-in details, they have no effect unless DownloadDetails is set.
+	DetailColumns []DetailColumn
+	// DetailLabels are added to the metrics of every download in addition to
+	// the info_hash and name labels.
+	DetailLabels []DetailLabel
+	// TopDownloads limits the download details to the downloads ranked highest
+	// by TopDownloadsBy, the others are summed in a single download labeled
+	// other. All downloads are reported when it is zero.
+	TopDownloads   int
+	TopDownloadsBy string
+	// Aggregation enables the AggregateCollector, which reports download
+	// metrics grouped as selected rather than per download.
+	Aggregation *Aggregation
+	// Filters select the downloads whose details are reported, they are
+	// evaluated in order and the first matching rule decides.
+	Filters []DownloadFilter
+	// FilterCounts applies the Filters to the download counts as well, which
+	// are then computed from every download rather than the view sizes.
+	FilterCounts bool
+	// LegacyTotalBytes also reports the download byte totals as the gauges
+	// named _total_bytes which preceded the _bytes_total counters.
+	LegacyTotalBytes bool
+	// Names, if set, replaces the name label of the downloads with
+	// pseudonyms or removes it.
+	Names *Names
+	// ScrapeTimeout bounds the retries of the failed calls of a collection,
+	// when the client retries them.
+	ScrapeTimeout time.Duration
 }
 
 var (
-	defaultActiveCommands = []string{"d.hash=", "d.base_filename=", "d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total="}
+	// detailLabelCommands are retrieved first for every download, their values
+	// are the info_hash and name labels of its detail metrics.
+	detailLabelCommands = []string{"d.hash=", "d.base_filename="}
+
+	trackerScrapeCommands = []string{"t.scrape_complete=", "t.scrape_incomplete="}
+
+	// countViews are the rTorrent views whose sizes are reported as download
+	// counts, the empty view is the default view which contains all downloads.
+	countViews = []string{"", "started", "stopped", "complete", "incomplete", "hashing", "seeding", "leeching"}
 )
 
 // Verify that DownloadsCollector implements the prometheus.Collector interface.
@@ -73,9 +158,13 @@ func NewDownloadsCollector(ds DownloadsSource, collectorOpts CollectorOpts) *Dow
 		subsystem = "downloads"
 	)
 
-	var (
-		labels = []string{"info_hash", "name"}
-	)
+	labels := []string{"info_hash"}
+	if !collectorOpts.Names.Drops() {
+		labels = append(labels, "name")
+	}
+	for _, l := range collectorOpts.DetailLabels {
+		labels = append(labels, l.Name)
+	}
 
 	downCollector := &DownloadsCollector{
 		Downloads: prometheus.NewDesc(
@@ -145,50 +234,117 @@ func NewDownloadsCollector(ds DownloadsSource, collectorOpts CollectorOpts) *Dow
 		ds: ds,
 
 		collectOpts: &collectorOpts,
+
+		detailLabels: collectorOpts.DetailLabels,
+	}
+
+	// detail declares a column of the download details and returns the
+	// descriptor of its metric
+	detail := func(cmd, name, help string, valueType prometheus.ValueType, value func(any) (float64, error)) *prometheus.Desc {
+		desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil)
+		downCollector.detailColumns = append(downCollector.detailColumns, detailColumn{
+			cmd:       cmd,
+			desc:      desc,
+			valueType: valueType,
+			value:     value,
+		})
+		return desc
+	}
+
+	// legacy declares a deprecated gauge reporting the value of the last
+	// declared column and returns its descriptor
+	legacy := func(name, help string) *prometheus.Desc {
+		desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil)
+		downCollector.detailColumns[len(downCollector.detailColumns)-1].legacyDesc = desc
+		return desc
 	}
 
 	if downCollector.collectOpts.DownloadDetails {
-		downCollector.DownloadRateBytes = prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "download_rate_bytes"),
-			"Current download rate in bytes.",
-			labels,
-			nil,
-		)
+		downCollector.DownloadRateBytes = detail("d.down.rate=", "download_rate_bytes",
+			"Current download rate in bytes.", prometheus.GaugeValue, intValue)
+		downCollector.DownloadBytesTotal = detail("d.down.total=", "download_bytes_total",
+			"Total bytes downloaded.", prometheus.CounterValue, intValue)
+		if downCollector.collectOpts.LegacyTotalBytes {
+			downCollector.DownloadTotalBytes = legacy("download_total_bytes",
+				"Total Bytes downloaded. Deprecated, use rtorrent_downloads_download_bytes_total instead.")
+		}
+		downCollector.UploadRateBytes = detail("d.up.rate=", "upload_rate_bytes",
+			"Current upload rate in bytes.", prometheus.GaugeValue, intValue)
+		downCollector.UploadBytesTotal = detail("d.up.total=", "upload_bytes_total",
+			"Total bytes uploaded.", prometheus.CounterValue, intValue)
+		if downCollector.collectOpts.LegacyTotalBytes {
+			downCollector.UploadTotalBytes = legacy("upload_total_bytes",
+				"Total Bytes uploaded. Deprecated, use rtorrent_downloads_upload_bytes_total instead.")
+		}
+	}
 
-		downCollector.DownloadTotalBytes = prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "download_total_bytes"),
-			"Total Bytes downloaded.",
+	if downCollector.collectOpts.DownloadDetails && downCollector.collectOpts.DownloadPeers {
+		downCollector.PeersConnected = detail("d.peers_connected=", "peers_connected",
+			"Number of peers connected.", prometheus.GaugeValue, intValue)
+		downCollector.PeersAccounted = detail("d.peers_accounted=", "peers_accounted",
+			"Number of connected peers counted towards the peer limits.", prometheus.GaugeValue, intValue)
+		downCollector.PeersComplete = detail("d.peers_complete=", "peers_complete",
+			"Number of connected peers which have the complete download.", prometheus.GaugeValue, intValue)
+		downCollector.PeersNotConnected = detail("d.peers_not_connected=", "peers_not_connected",
+			"Number of known peers which are not connected.", prometheus.GaugeValue, intValue)
+
+		downCollector.TrackerSeeders = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "tracker_seeders"),
+			"Number of seeders reported by tracker scrapes, summed across all trackers.",
 			labels,
 			nil,
 		)
 
-		downCollector.UploadRateBytes = prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "upload_rate_bytes"),
-			"Current upload rate in bytes.",
+		downCollector.TrackerLeechers = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "tracker_leechers"),
+			"Number of leechers reported by tracker scrapes, summed across all trackers.",
 			labels,
 			nil,
 		)
+	}
 
-		downCollector.UploadTotalBytes = prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "upload_total_bytes"),
-			"Total Bytes uploaded.",
-			labels,
+	if len(downCollector.collectOpts.Filters) > 0 {
+		downCollector.DownloadsFiltered = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "filtered"),
+			"Number of downloads dropped by each filter rule.",
+			[]string{"rule"},
 			nil,
 		)
 	}
 
+	if downCollector.collectOpts.DownloadDetails {
+		for _, col := range downCollector.collectOpts.DetailColumns {
+			downCollector.detailColumns = append(downCollector.detailColumns, col.detailColumn(subsystem, labels))
+		}
+	}
+
 	return downCollector
 }
 
 // collect begins a metrics collection task for all metrics related to rTorrent
 // downloads.
 func (c *DownloadsCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	if desc, err := c.collectDownloadCounts(ch); err != nil {
-		return desc, err
+	// kept holds the info hashes of the downloads selected by the filters,
+	// all downloads are kept when it is nil
+	var kept map[string]bool
+	if len(c.collectOpts.Filters) > 0 {
+		var (
+			desc *prometheus.Desc
+			err  error
+		)
+		if kept, desc, err = c.collectFilters(ch); err != nil {
+			return desc, err
+		}
+	}
+
+	if !c.collectOpts.FilterCounts || kept == nil {
+		if desc, err := c.collectDownloadCounts(ch); err != nil {
+			return desc, err
+		}
 	}
 
 	if c.collectOpts.DownloadDetails {
-		if desc, err := c.collectDownloadDetails(ch); err != nil {
+		if desc, err := c.collectDownloadDetails(ch, kept); err != nil {
 			return desc, err
 		}
 	}
@@ -199,100 +355,47 @@ func (c *DownloadsCollector) collect(ch chan<- prometheus.Metric) (*prometheus.D
 // collectDownloadCounts collects metrics which track number of downloads in
 // various possible states.
 func (c *DownloadsCollector) collectDownloadCounts(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	all, err := c.ds.All()
+	// All counts are gathered in a single round trip rather than retrieving
+	// the full list of hashes for each view only to count them
+	sizes, err := c.ds.ViewSizes(countViews)
 	if err != nil {
 		return c.Downloads, err
 	}
 
-	started, err := c.ds.Started()
-	if err != nil {
-		return c.DownloadsStarted, err
-	}
-
-	stopped, err := c.ds.Stopped()
-	if err != nil {
-		return c.DownloadsStopped, err
-	}
-
-	complete, err := c.ds.Complete()
-	if err != nil {
-		return c.DownloadsComplete, err
-	}
-
-	incomplete, err := c.ds.Incomplete()
-	if err != nil {
-		return c.DownloadsIncomplete, err
-	}
-
-	hashing, err := c.ds.Hashing()
-	if err != nil {
-		return c.DownloadsHashing, err
-	}
-
-	seeding, err := c.ds.Seeding()
-	if err != nil {
-		return c.DownloadsSeeding, err
-	}
-
-	leeching, err := c.ds.Leeching()
-	if err != nil {
-		return c.DownloadsLeeching, err
-	}
+	return c.sendDownloadCounts(sizes, ch)
+}
 
-	ch <- prometheus.MustNewConstMetric(
+// sendDownloadCounts sends the number of downloads in each of the countViews.
+func (c *DownloadsCollector) sendDownloadCounts(sizes []int, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	descs := []*prometheus.Desc{
 		c.Downloads,
-		prometheus.GaugeValue,
-		float64(len(all)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsStarted,
-		prometheus.GaugeValue,
-		float64(len(started)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsStopped,
-		prometheus.GaugeValue,
-		float64(len(stopped)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsComplete,
-		prometheus.GaugeValue,
-		float64(len(complete)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsIncomplete,
-		prometheus.GaugeValue,
-		float64(len(incomplete)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsHashing,
-		prometheus.GaugeValue,
-		float64(len(hashing)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsSeeding,
-		prometheus.GaugeValue,
-		float64(len(seeding)),
-	)
-
-	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsLeeching,
-		prometheus.GaugeValue,
-		float64(len(leeching)),
-	)
+	}
+	if len(sizes) != len(descs) {
+		return c.Downloads, fmt.Errorf("expected %d view sizes, got %d", len(descs), len(sizes))
+	}
+
+	for i, desc := range descs {
+		ch <- prometheus.MustNewConstMetric(
+			desc,
+			prometheus.GaugeValue,
+			float64(sizes[i]),
+		)
+	}
 
 	return nil, nil
 }
 
 // collectDownloadDetails collects information about active downloads,
-// which are uploading and/or downloading data.
-func (c *DownloadsCollector) collectDownloadDetails(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+// which are uploading and/or downloading data. Only the downloads whose info
+// hash is in kept are reported, unless it is nil.
+func (c *DownloadsCollector) collectDownloadDetails(ch chan<- prometheus.Metric, kept map[string]bool) (*prometheus.Desc, error) {
 	cmds := c.getDownloadDetailCommands()
 
 	active, err := c.ds.DownloadWithDetails(cmds)
@@ -300,101 +403,238 @@ func (c *DownloadsCollector) collectDownloadDetails(ch chan<- prometheus.Metric)
 		return c.DownloadsActive, err
 	}
 
+	details := make([]downloadDetail, 0, len(active))
+	for _, a := range active {
+		d, desc, err := c.decodeDownloadDetails(a)
+		if err != nil {
+			return desc, err
+		}
+		// Downloads added since the filters were evaluated are left out until
+		// the next scrape
+		if kept != nil && !kept[d.labels[0]] {
+			continue
+		}
+		details = append(details, d)
+	}
+
 	ch <- prometheus.MustNewConstMetric(
 		c.DownloadsActive,
 		prometheus.GaugeValue,
-		float64(len(active)),
+		float64(len(details)),
 	)
 
-	// Here active should be a slice of slices, where each inner slice looks like:
-	// [hash, name, down.rate, down.total, up.rate, up.total]
-	for _, a := range active {
-		err := c.parseDownloadDetailsMetrics(a, cmds, ch)
-		if err != nil {
-			return c.DownloadRateBytes, err
+	details, other := c.selectTopDownloads(details)
+	for _, d := range details {
+		c.sendDownloadDetails(d, ch)
+	}
+	if other != nil {
+		c.sendDownloadDetails(*other, ch)
+	}
+
+	if c.collectOpts.DownloadPeers {
+		rows := make([][]any, 0, len(details))
+		for _, d := range details {
+			rows = append(rows, d.row)
+		}
+		if desc, err := c.collectTrackerScrapes(rows, ch); err != nil {
+			return desc, err
 		}
 	}
 
 	return nil, nil
 }
 
-func (c *DownloadsCollector) parseDownloadDetailsMetrics(a []any, cmds []string, ch chan<- prometheus.Metric) error {
-	labels, err := c.gatherDownloadDetailLabels(a)
+// collectTrackerScrapes collects the number of seeders and leechers reported
+// by the trackers of each of the provided downloads.
+func (c *DownloadsCollector) collectTrackerScrapes(active [][]any, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	hashes := make([]string, 0, len(active))
+	labels := make([][]string, 0, len(active))
+	for _, a := range active {
+		l, err := c.gatherDownloadDetailLabels(a)
+		if err != nil {
+			return c.TrackerSeeders, err
+		}
+		hashes = append(hashes, l[0])
+		labels = append(labels, l)
+	}
+
+	trackers, err := c.ds.TrackersWithDetails(hashes, trackerScrapeCommands)
 	if err != nil {
-		return err
+		return c.TrackerSeeders, err
+	}
+	if len(trackers) != len(hashes) {
+		return c.TrackerSeeders, fmt.Errorf("expected trackers for %d downloads, got %d", len(hashes), len(trackers))
 	}
 
-	for idx, v := range a[2:] {
-		switch cmds[idx] {
-		case "d.down.rate=":
-			down, ok := v.(int64)
-			if !ok {
-				return fmt.Errorf("failed to convert Download Rate Bytes")
+	for i, tt := range trackers {
+		var seeders, leechers int64
+		for _, t := range tt {
+			if len(t) != len(trackerScrapeCommands) {
+				return c.TrackerSeeders, fmt.Errorf("expected %d tracker values, got %d", len(trackerScrapeCommands), len(t))
 			}
-			ch <- prometheus.MustNewConstMetric(
-				c.DownloadRateBytes,
-				prometheus.GaugeValue,
-				float64(down),
-				labels...,
-			)
-		case "d.down.total=":
-			downTotal, ok := v.(int64)
+			complete, ok := t[0].(int64)
 			if !ok {
-				return fmt.Errorf("failed to convert Download Total Bytes")
+				return c.TrackerSeeders, fmt.Errorf("failed to convert Tracker Seeders")
 			}
-			ch <- prometheus.MustNewConstMetric(
-				c.DownloadTotalBytes,
-				prometheus.GaugeValue,
-				float64(downTotal),
-				labels...,
-			)
-		case "d.up.rate=":
-			up, ok := v.(int64)
-			if !ok {
-				return fmt.Errorf("failed to convert Upload Rate Bytes")
-			}
-			ch <- prometheus.MustNewConstMetric(
-				c.UploadRateBytes,
-				prometheus.GaugeValue,
-				float64(up),
-				labels...,
-			)
-		case "d.up.total=":
-			upTotal, ok := v.(int64)
+			incomplete, ok := t[1].(int64)
 			if !ok {
-				return fmt.Errorf("failed to convert Upload Total Bytes")
+				return c.TrackerLeechers, fmt.Errorf("failed to convert Tracker Leechers")
 			}
+			seeders += complete
+			leechers += incomplete
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.TrackerSeeders,
+			prometheus.GaugeValue,
+			float64(seeders),
+			labels[i]...,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.TrackerLeechers,
+			prometheus.GaugeValue,
+			float64(leechers),
+			labels[i]...,
+		)
+	}
+
+	return nil, nil
+}
+
+// A downloadDetail is a decoded row of download details.
+type downloadDetail struct {
+	row    []any
+	labels []string
+	// values holds the value of each detail column, has reports whether the
+	// download has a value for the column at all.
+	values []float64
+	has    []bool
+}
+
+// decodeDownloadDetails decodes a row of download details, as retrieved with
+// getDownloadDetailCommands. On error the descriptor of the offending column is
+// returned.
+func (c *DownloadsCollector) decodeDownloadDetails(row []any) (downloadDetail, *prometheus.Desc, error) {
+	labelCount := len(detailLabelCommands) + len(c.detailLabels)
+	if want := labelCount + len(c.detailColumns); len(row) != want {
+		return downloadDetail{}, c.DownloadsActive, fmt.Errorf("expected %d download detail values, got %d", want, len(row))
+	}
+
+	labels, err := c.gatherDownloadDetailLabels(row)
+	if err != nil {
+		return downloadDetail{}, c.DownloadsActive, err
+	}
+
+	d := downloadDetail{
+		row:    row,
+		labels: labels,
+		values: make([]float64, len(c.detailColumns)),
+		has:    make([]bool, len(c.detailColumns)),
+	}
+
+	for i, col := range c.detailColumns {
+		v, err := col.value(row[labelCount+i])
+		if errors.Is(err, errNoValue) {
+			continue
+		}
+		if err != nil {
+			return downloadDetail{}, col.desc, fmt.Errorf("failed to convert %s of download %s: %w", col.cmd, labels[0], err)
+		}
+		d.values[i] = v
+		d.has[i] = true
+	}
+
+	return d, nil, nil
+}
+
+// sendDownloadDetails sends the metric of each of the detail columns the
+// download has a value for.
+func (c *DownloadsCollector) sendDownloadDetails(d downloadDetail, ch chan<- prometheus.Metric) {
+	for i, col := range c.detailColumns {
+		if !d.has[i] {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			col.desc,
+			col.valueType,
+			d.values[i],
+			d.labels...,
+		)
+
+		if col.legacyDesc != nil {
 			ch <- prometheus.MustNewConstMetric(
-				c.UploadTotalBytes,
+				col.legacyDesc,
 				prometheus.GaugeValue,
-				float64(upTotal),
-				labels...,
+				d.values[i],
+				d.labels...,
 			)
 		}
 	}
-	return nil
 }
 
+// gatherDownloadDetailLabels returns the label values of a row of download
+// details, which starts with the values of detailLabelCommands followed by
+// those of the detail labels. The name is replaced by its pseudonym, or left
+// out when names are dropped.
 func (c *DownloadsCollector) gatherDownloadDetailLabels(torSlice []any) ([]string, error) {
-	hash, ok := torSlice[0].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to convert torrent hash to string")
-	}
-	name, ok := torSlice[1].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to convert torrent name to string")
+	cmds := c.labelCommands()
+	if len(torSlice) < len(cmds) {
+		return nil, fmt.Errorf("expected at least %d download detail values, got %d", len(cmds), len(torSlice))
 	}
 
-	labels := []string{
-		hash,
-		name,
+	labels := make([]string, 0, len(cmds))
+	for i, cmd := range cmds {
+		l, ok := torSlice[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to convert %s value of type %T to string", cmd, torSlice[i])
+		}
+		switch {
+		case i == 1 && c.collectOpts.Names.Drops():
+			continue
+		case i == 1:
+			l = c.collectOpts.Names.Pseudonym(l)
+		case i >= len(detailLabelCommands):
+			l = c.detailLabels[i-len(detailLabelCommands)].value(l)
+		}
+		labels = append(labels, l)
 	}
 
 	return labels, nil
 }
 
+// labelCommands returns the commands whose values are the labels of the
+// download details.
+func (c *DownloadsCollector) labelCommands() []string {
+	cmds := make([]string, 0, len(detailLabelCommands)+len(c.detailLabels))
+	cmds = append(cmds, detailLabelCommands...)
+	for _, l := range c.detailLabels {
+		cmds = append(cmds, l.Command)
+	}
+	return cmds
+}
+
+// getDownloadDetailCommands returns the commands retrieved for every download:
+// the label commands followed by the command of each detail column.
 func (c *DownloadsCollector) getDownloadDetailCommands() []string {
-	return defaultActiveCommands
+	cmds := c.labelCommands()
+	for _, col := range c.detailColumns {
+		cmds = append(cmds, col.cmd)
+	}
+	return cmds
+}
+
+// intValue converts an integer returned by rTorrent into a metric value.
+func intValue(v any) (float64, error) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %T", v)
+	}
 }
 
 // Describe sends the descriptors of each metric over to the provided channel.
@@ -411,13 +651,21 @@ func (c *DownloadsCollector) Describe(ch chan<- *prometheus.Desc) {
 		c.DownloadsLeeching,
 		c.DownloadsActive,
 	}
+	if c.DownloadsFiltered != nil {
+		ds = append(ds, c.DownloadsFiltered)
+	}
 
-	if c.collectOpts.DownloadDetails {
+	for _, col := range c.detailColumns {
+		ds = append(ds, col.desc)
+		if col.legacyDesc != nil {
+			ds = append(ds, col.legacyDesc)
+		}
+	}
+
+	if c.collectOpts.DownloadDetails && c.collectOpts.DownloadPeers {
 		ds = append(ds,
-			c.DownloadRateBytes,
-			c.DownloadTotalBytes,
-			c.UploadRateBytes,
-			c.UploadTotalBytes,
+			c.TrackerSeeders,
+			c.TrackerLeechers,
 		)
 	}
 
@@ -1,9 +1,11 @@
 package rtorrentexporter
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -88,6 +90,53 @@ func (m *MockDownloadsSource) DownloadWithDetails(cmds []string) ([][]any, error
 	return args.Get(0).([][]any), args.Error(1)
 }
 
+func (m *MockDownloadsSource) AllWithDetails(cmds []string) ([][]any, error) {
+	args := m.Called(cmds)
+	return args.Get(0).([][]any), args.Error(1)
+}
+
+func (m *MockDownloadsSource) TrackersWithDetails(hashes []string, cmds []string) ([][][]any, error) {
+	args := m.Called(hashes, cmds)
+	return args.Get(0).([][][]any), args.Error(1)
+}
+
+func (m *MockDownloadsSource) ViewSizes(views []string) ([]int, error) {
+	args := m.Called(views)
+	return args.Get(0).([]int), args.Error(1)
+}
+
+// metricValue returns the value of a gauge, counter or untyped metric.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+
+	var pb dto.Metric
+	assert.Nil(t, m.Write(&pb))
+
+	switch {
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	default:
+		return pb.Untyped.GetValue()
+	}
+}
+
+// metricLabel returns the value of the named label of a metric.
+func metricLabel(t *testing.T, m prometheus.Metric, name string) string {
+	t.Helper()
+
+	var pb dto.Metric
+	assert.Nil(t, m.Write(&pb))
+
+	for _, l := range pb.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
 func TestNewDownloadsCollector(t *testing.T) {
 	ds := new(MockDownloadsSource)
 	collectorOpts := CollectorOpts{DownloadDetails: true}
@@ -99,14 +148,7 @@ func TestNewDownloadsCollector(t *testing.T) {
 
 func TestDownloadsCollector_collectDownloadCounts(t *testing.T) {
 	ds := new(MockDownloadsSource)
-	ds.On("All").Return([]string{}, nil)
-	ds.On("Started").Return([]string{}, nil)
-	ds.On("Stopped").Return([]string{}, nil)
-	ds.On("Complete").Return([]string{}, nil)
-	ds.On("Incomplete").Return([]string{}, nil)
-	ds.On("Hashing").Return([]string{}, nil)
-	ds.On("Seeding").Return([]string{}, nil)
-	ds.On("Leeching").Return([]string{}, nil)
+	ds.On("ViewSizes", countViews).Return([]int{8, 7, 1, 5, 3, 0, 4, 2}, nil)
 
 	collector := NewDownloadsCollector(ds, CollectorOpts{})
 	ch := make(chan prometheus.Metric)
@@ -118,9 +160,34 @@ func TestDownloadsCollector_collectDownloadCounts(t *testing.T) {
 		assert.Nil(t, err)
 	}()
 
-	for range ch {
-		// Consume the channel
+	got := make(map[string]float64)
+	for m := range ch {
+		got[m.Desc().String()] = metricValue(t, m)
 	}
+
+	assert.Equal(t, map[string]float64{
+		collector.Downloads.String():           8,
+		collector.DownloadsStarted.String():    7,
+		collector.DownloadsStopped.String():    1,
+		collector.DownloadsComplete.String():   5,
+		collector.DownloadsIncomplete.String(): 3,
+		collector.DownloadsHashing.String():    0,
+		collector.DownloadsSeeding.String():    4,
+		collector.DownloadsLeeching.String():   2,
+	}, got)
+	ds.AssertNotCalled(t, "All")
+}
+
+func TestDownloadsCollector_collectDownloadCountsError(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	ds.On("ViewSizes", countViews).Return([]int(nil), errors.New("connection refused"))
+
+	collector := NewDownloadsCollector(ds, CollectorOpts{})
+	ch := make(chan prometheus.Metric)
+
+	desc, err := collector.collectDownloadCounts(ch)
+	assert.Equal(t, collector.Downloads, desc)
+	assert.NotNil(t, err)
 }
 
 func TestDownloadsCollector_collectDownloadDetails(t *testing.T) {
@@ -135,7 +202,7 @@ func TestDownloadsCollector_collectDownloadDetails(t *testing.T) {
 
 	go func() {
 		defer close(ch)
-		desc, err := collector.collectDownloadDetails(ch)
+		desc, err := collector.collectDownloadDetails(ch, nil)
 		assert.Nil(t, desc)
 		assert.Nil(t, err)
 	}()
@@ -145,20 +212,191 @@ func TestDownloadsCollector_collectDownloadDetails(t *testing.T) {
 	}
 }
 
-func TestDownloadsCollector_parseDownloadDetailsMetrics(t *testing.T) {
-	collector := NewDownloadsCollector(nil, CollectorOpts{DownloadDetails: true})
+func TestDownloadsCollector_collectDownloadDetailsPeers(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	cmds := []string{
+		"d.hash=", "d.base_filename=", "d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total=",
+		"d.peers_connected=", "d.peers_accounted=", "d.peers_complete=", "d.peers_not_connected=",
+	}
+	ds.On("DownloadWithDetails", cmds).Return([][]any{
+		{"hash1", "name1", int64(100), int64(200), int64(300), int64(400), int64(5), int64(4), int64(2), int64(30)},
+	}, nil)
+	ds.On("TrackersWithDetails", []string{"hash1"}, trackerScrapeCommands).Return([][][]any{
+		{{int64(10), int64(3)}, {int64(7), int64(1)}},
+	}, nil)
+
+	collector := NewDownloadsCollector(ds, CollectorOpts{DownloadDetails: true, DownloadPeers: true})
 	ch := make(chan prometheus.Metric)
-	a := []any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400)}
-	cmds := []string{"d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total="}
 
 	go func() {
 		defer close(ch)
-		err := collector.parseDownloadDetailsMetrics(a, cmds, ch)
+		desc, err := collector.collectDownloadDetails(ch, nil)
+		assert.Nil(t, desc)
 		assert.Nil(t, err)
 	}()
 
-	for range ch {
-		// Consume the channel
+	got := make(map[string]float64)
+	for m := range ch {
+		got[m.Desc().String()] = metricValue(t, m)
+	}
+
+	assert.Equal(t, float64(5), got[collector.PeersConnected.String()])
+	assert.Equal(t, float64(4), got[collector.PeersAccounted.String()])
+	assert.Equal(t, float64(2), got[collector.PeersComplete.String()])
+	assert.Equal(t, float64(30), got[collector.PeersNotConnected.String()])
+	assert.Equal(t, float64(17), got[collector.TrackerSeeders.String()])
+	assert.Equal(t, float64(4), got[collector.TrackerLeechers.String()])
+}
+
+func TestDownloadsCollector_totalBytesTypes(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{DownloadDetails: true, LegacyTotalBytes: true})
+
+	d, desc, err := collector.decodeDownloadDetails([]any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400)})
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+
+	ch := make(chan prometheus.Metric, 16)
+	collector.sendDownloadDetails(d, ch)
+	close(ch)
+
+	counters := make(map[*prometheus.Desc]bool)
+	for m := range ch {
+		var pb dto.Metric
+		assert.Nil(t, m.Write(&pb))
+		counters[m.Desc()] = pb.Counter != nil
+	}
+
+	assert.Equal(t, map[*prometheus.Desc]bool{
+		collector.DownloadRateBytes:  false,
+		collector.DownloadBytesTotal: true,
+		collector.DownloadTotalBytes: false,
+		collector.UploadRateBytes:    false,
+		collector.UploadBytesTotal:   true,
+		collector.UploadTotalBytes:   false,
+	}, counters)
+	assert.Contains(t, collector.DownloadBytesTotal.String(), `"rtorrent_downloads_download_bytes_total"`)
+	assert.Contains(t, collector.UploadTotalBytes.String(), `"rtorrent_downloads_upload_total_bytes"`)
+}
+
+func TestDownloadsCollector_decodeDownloadDetails(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{DownloadDetails: true})
+	a := []any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400)}
+	cmds := []string{"d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total="}
+
+	// Each value is decoded by the column of the command it was retrieved with
+	got := make([]string, 0, len(collector.detailColumns))
+	for _, col := range collector.detailColumns {
+		got = append(got, col.cmd)
+	}
+	assert.Equal(t, cmds, got)
+
+	d, desc, err := collector.decodeDownloadDetails(a)
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+	assert.Equal(t, a, d.row)
+	assert.Equal(t, []string{"hash1", "name1"}, d.labels)
+	assert.Equal(t, []float64{100, 200, 300, 400}, d.values)
+	assert.Equal(t, []bool{true, true, true, true}, d.has)
+}
+
+func TestDownloadsCollector_sendDownloadDetails(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CollectorOpts
+		row  []any
+		want func(c *DownloadsCollector) map[*prometheus.Desc]float64
+	}{
+		{
+			name: "details",
+			opts: CollectorOpts{DownloadDetails: true},
+			row:  []any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400)},
+			want: func(c *DownloadsCollector) map[*prometheus.Desc]float64 {
+				return map[*prometheus.Desc]float64{
+					c.DownloadRateBytes:  100,
+					c.DownloadBytesTotal: 200,
+					c.UploadRateBytes:    300,
+					c.UploadBytesTotal:   400,
+				}
+			},
+		},
+		{
+			name: "details with peers",
+			opts: CollectorOpts{DownloadDetails: true, DownloadPeers: true},
+			row:  []any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400), int64(5), int64(4), int64(2), int64(30)},
+			want: func(c *DownloadsCollector) map[*prometheus.Desc]float64 {
+				return map[*prometheus.Desc]float64{
+					c.DownloadRateBytes:  100,
+					c.DownloadBytesTotal: 200,
+					c.UploadRateBytes:    300,
+					c.UploadBytesTotal:   400,
+					c.PeersConnected:     5,
+					c.PeersAccounted:     4,
+					c.PeersComplete:      2,
+					c.PeersNotConnected:  30,
+				}
+			},
+		},
+		{
+			name: "details with legacy totals",
+			opts: CollectorOpts{DownloadDetails: true, LegacyTotalBytes: true},
+			row:  []any{"hash1", "name1", int64(100), int64(200), int64(300), int64(400)},
+			want: func(c *DownloadsCollector) map[*prometheus.Desc]float64 {
+				return map[*prometheus.Desc]float64{
+					c.DownloadRateBytes:  100,
+					c.DownloadBytesTotal: 200,
+					c.DownloadTotalBytes: 200,
+					c.UploadRateBytes:    300,
+					c.UploadBytesTotal:   400,
+					c.UploadTotalBytes:   400,
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewDownloadsCollector(nil, tt.opts)
+			d, desc, err := collector.decodeDownloadDetails(tt.row)
+			assert.Nil(t, desc)
+			assert.Nil(t, err)
+
+			ch := make(chan prometheus.Metric)
+			go func() {
+				defer close(ch)
+				collector.sendDownloadDetails(d, ch)
+			}()
+
+			got := make(map[*prometheus.Desc]float64)
+			for m := range ch {
+				assert.Equal(t, "hash1", metricLabel(t, m, "info_hash"))
+				assert.Equal(t, "name1", metricLabel(t, m, "name"))
+				got[m.Desc()] = metricValue(t, m)
+			}
+			assert.Equal(t, tt.want(collector), got)
+		})
+	}
+}
+
+func TestDownloadsCollector_decodeDownloadDetailsErrors(t *testing.T) {
+	collector := NewDownloadsCollector(nil, CollectorOpts{DownloadDetails: true})
+
+	tests := []struct {
+		name string
+		row  []any
+		desc *prometheus.Desc
+	}{
+		{"short row", []any{"hash1", "name1", int64(100)}, collector.DownloadsActive},
+		{"long row", []any{"hash1", "name1", int64(1), int64(2), int64(3), int64(4), int64(5)}, collector.DownloadsActive},
+		{"bad label", []any{int64(1), "name1", int64(1), int64(2), int64(3), int64(4)}, collector.DownloadsActive},
+		{"bad value", []any{"hash1", "name1", int64(1), "2", int64(3), int64(4)}, collector.DownloadBytesTotal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, desc, err := collector.decodeDownloadDetails(tt.row)
+			assert.Equal(t, tt.desc, desc)
+			assert.NotNil(t, err)
+		})
 	}
 }
 
@@ -172,9 +410,23 @@ func TestDownloadsCollector_gatherDownloadDetailLabels(t *testing.T) {
 }
 
 func TestDownloadsCollector_getDownloadDetailCommands(t *testing.T) {
-	collector := NewDownloadsCollector(nil, CollectorOpts{})
-	cmds := collector.getDownloadDetailCommands()
-	assert.Equal(t, defaultActiveCommands, cmds)
+	tests := []struct {
+		opts CollectorOpts
+		want []string
+	}{
+		{CollectorOpts{}, []string{"d.hash=", "d.base_filename="}},
+		{CollectorOpts{DownloadDetails: true}, []string{
+			"d.hash=", "d.base_filename=", "d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total=",
+		}},
+		{CollectorOpts{DownloadDetails: true, DownloadPeers: true}, []string{
+			"d.hash=", "d.base_filename=", "d.down.rate=", "d.down.total=", "d.up.rate=", "d.up.total=",
+			"d.peers_connected=", "d.peers_accounted=", "d.peers_complete=", "d.peers_not_connected=",
+		}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NewDownloadsCollector(nil, tt.opts).getDownloadDetailCommands())
+	}
 }
 
 func TestDownloadsCollector_Describe(t *testing.T) {
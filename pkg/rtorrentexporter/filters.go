@@ -0,0 +1,319 @@
+package rtorrentexporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// FilterByName matches the name of downloads.
+	FilterByName = "name"
+
+	// FilterByHash matches the info hash of downloads.
+	FilterByHash = "hash"
+
+	// FilterByLabel matches the ruTorrent label of downloads, d.custom1.
+	FilterByLabel = "label"
+
+	// FilterByTracker matches the hostname of any of the trackers of
+	// downloads.
+	FilterByTracker = "tracker"
+
+	// FilterByView matches any of the rTorrent views downloads are in.
+	FilterByView = "view"
+
+	// defaultFilterRule is the rule downloads are reported as dropped by
+	// when include rules exist and none of the rules matched them.
+	defaultFilterRule = "default"
+)
+
+// A DownloadFilter is a rule including or excluding downloads from the
+// DownloadsCollector's metrics. Rules are evaluated in order and the first one
+// matching a download decides whether it is kept. Downloads matching no rule
+// are kept, unless there are include rules, in which case they are dropped.
+type DownloadFilter struct {
+	// Rule is the name the rule is reported under.
+	Rule string
+
+	// Exclude drops the downloads matching the rule rather than keeping them.
+	Exclude bool
+
+	// Field is one of FilterByName, FilterByHash, FilterByLabel,
+	// FilterByTracker or FilterByView.
+	Field string
+
+	// Pattern is matched against the field.
+	Pattern *regexp.Regexp
+}
+
+// ValidateDownloadFilter checks that f can be evaluated.
+func ValidateDownloadFilter(f DownloadFilter) error {
+	if f.Rule == "" || f.Rule == defaultFilterRule {
+		return fmt.Errorf("rule name must be set and not be %q", defaultFilterRule)
+	}
+	switch f.Field {
+	case FilterByName, FilterByHash, FilterByLabel, FilterByTracker, FilterByView:
+	default:
+		return fmt.Errorf("unknown field %q, must be %s, %s, %s, %s or %s",
+			f.Field, FilterByName, FilterByHash, FilterByLabel, FilterByTracker, FilterByView)
+	}
+	if f.Pattern == nil {
+		return fmt.Errorf("pattern must be set")
+	}
+	return nil
+}
+
+// GlobPattern compiles a glob, in which * matches any run of characters and ?
+// matches a single character, into a pattern matching whole values.
+func GlobPattern(glob string) *regexp.Regexp {
+	re := regexp.QuoteMeta(glob)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// A filterDownload holds the fields of a download filters are matched against,
+// along with its state for counting.
+type filterDownload struct {
+	hash     string
+	name     string
+	label    string
+	views    []string
+	trackers []string
+
+	started  bool
+	complete bool
+	hashing  bool
+}
+
+// matches reports whether the rule matches the download.
+func (f DownloadFilter) matches(d filterDownload) bool {
+	switch f.Field {
+	case FilterByName:
+		return f.Pattern.MatchString(d.name)
+	case FilterByHash:
+		return f.Pattern.MatchString(d.hash)
+	case FilterByLabel:
+		return f.Pattern.MatchString(d.label)
+	case FilterByTracker:
+		return matchesAny(f.Pattern, d.trackers)
+	case FilterByView:
+		return matchesAny(f.Pattern, d.views)
+	default:
+		return false
+	}
+}
+
+// matchesAny reports whether re matches any of the provided values.
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDownload evaluates the filters against d and reports whether it is
+// kept, along with the rule which dropped it otherwise.
+func (c *DownloadsCollector) filterDownload(d filterDownload) (bool, string) {
+	for _, f := range c.collectOpts.Filters {
+		if f.matches(d) {
+			return !f.Exclude, f.Rule
+		}
+	}
+
+	if c.hasIncludeFilters() {
+		return false, defaultFilterRule
+	}
+	return true, ""
+}
+
+// hasIncludeFilters reports whether any of the filters is an include rule.
+func (c *DownloadsCollector) hasIncludeFilters() bool {
+	for _, f := range c.collectOpts.Filters {
+		if !f.Exclude {
+			return true
+		}
+	}
+	return false
+}
+
+// usesFilterField reports whether any of the filters matches field.
+func (c *DownloadsCollector) usesFilterField(field string) bool {
+	for _, f := range c.collectOpts.Filters {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCommands returns the commands retrieved for every download in order to
+// evaluate the filters, the order must match the fields decoded in
+// decodeFilterDownload.
+func (c *DownloadsCollector) filterCommands() []string {
+	cmds := []string{"d.hash=", "d.base_filename="}
+	if c.usesFilterField(FilterByLabel) {
+		cmds = append(cmds, "d.custom1=")
+	}
+	if c.usesFilterField(FilterByView) {
+		cmds = append(cmds, "d.views=")
+	}
+	if c.collectOpts.FilterCounts {
+		cmds = append(cmds, "d.state=", "d.complete=", "d.hashing=")
+	}
+	return cmds
+}
+
+// decodeFilterDownload decodes a row retrieved with filterCommands.
+func (c *DownloadsCollector) decodeFilterDownload(row []any, cmds []string) (filterDownload, error) {
+	var d filterDownload
+	if len(row) != len(cmds) {
+		return d, fmt.Errorf("expected %d download filter values, got %d", len(cmds), len(row))
+	}
+
+	for i, cmd := range cmds {
+		var ok bool
+		switch cmd {
+		case "d.hash=":
+			d.hash, ok = row[i].(string)
+		case "d.base_filename=":
+			d.name, ok = row[i].(string)
+		case "d.custom1=":
+			d.label, ok = row[i].(string)
+		case "d.views=":
+			var views []any
+			views, ok = row[i].([]any)
+			for _, v := range views {
+				if s, isString := v.(string); isString {
+					d.views = append(d.views, s)
+				}
+			}
+		case "d.state=", "d.complete=", "d.hashing=":
+			var n int64
+			n, ok = row[i].(int64)
+			switch cmd {
+			case "d.state=":
+				d.started = n != 0
+			case "d.complete=":
+				d.complete = n != 0
+			default:
+				d.hashing = n != 0
+			}
+		}
+		if !ok {
+			return d, fmt.Errorf("failed to convert %s value of type %T", cmd, row[i])
+		}
+	}
+
+	return d, nil
+}
+
+// collectFilters evaluates the filters against every download, sends the
+// number of downloads each rule dropped and, with FilterCounts, the download
+// counts of the kept downloads. It returns the info hashes of the kept
+// downloads.
+func (c *DownloadsCollector) collectFilters(ch chan<- prometheus.Metric) (map[string]bool, *prometheus.Desc, error) {
+	cmds := c.filterCommands()
+	// The filters are evaluated over every download, not only those in the
+	// active view, for the counts to include stopped and idle downloads
+	rows, err := c.ds.AllWithDetails(cmds)
+	if err != nil {
+		return nil, c.DownloadsFiltered, err
+	}
+
+	downloads := make([]filterDownload, 0, len(rows))
+	for _, row := range rows {
+		d, err := c.decodeFilterDownload(row, cmds)
+		if err != nil {
+			return nil, c.DownloadsFiltered, err
+		}
+		downloads = append(downloads, d)
+	}
+
+	if c.usesFilterField(FilterByTracker) {
+		if err := c.gatherFilterTrackers(downloads); err != nil {
+			return nil, c.DownloadsFiltered, err
+		}
+	}
+
+	dropped := make(map[string]int)
+	for _, f := range c.collectOpts.Filters {
+		dropped[f.Rule] = 0
+	}
+	if c.hasIncludeFilters() {
+		dropped[defaultFilterRule] = 0
+	}
+
+	kept := make(map[string]bool, len(downloads))
+	counts := make([]int, len(countViews))
+	for _, d := range downloads {
+		keep, rule := c.filterDownload(d)
+		if !keep {
+			dropped[rule]++
+			continue
+		}
+		kept[d.hash] = true
+
+		// The same states as the filters of the views in countViews
+		for i, in := range []bool{
+			true,
+			d.started,
+			!d.started,
+			d.complete,
+			!d.complete,
+			d.hashing,
+			d.started && d.complete,
+			d.started && !d.complete,
+		} {
+			if in {
+				counts[i]++
+			}
+		}
+	}
+
+	for rule, n := range dropped {
+		ch <- prometheus.MustNewConstMetric(c.DownloadsFiltered, prometheus.GaugeValue, float64(n), rule)
+	}
+
+	if c.collectOpts.FilterCounts {
+		if desc, err := c.sendDownloadCounts(counts, ch); err != nil {
+			return nil, desc, err
+		}
+	}
+
+	return kept, nil, nil
+}
+
+// gatherFilterTrackers retrieves the tracker hostnames of each of the provided
+// downloads.
+func (c *DownloadsCollector) gatherFilterTrackers(downloads []filterDownload) error {
+	hashes := make([]string, 0, len(downloads))
+	for _, d := range downloads {
+		hashes = append(hashes, d.hash)
+	}
+
+	trackers, err := c.ds.TrackersWithDetails(hashes, aggregateTrackerCommands)
+	if err != nil {
+		return err
+	}
+	if len(trackers) != len(hashes) {
+		return fmt.Errorf("expected trackers for %d downloads, got %d", len(hashes), len(trackers))
+	}
+
+	for i, tt := range trackers {
+		for _, t := range tt {
+			if len(t) != len(aggregateTrackerCommands) {
+				return fmt.Errorf("expected %d tracker values, got %d", len(aggregateTrackerCommands), len(t))
+			}
+			url, _ := t[0].(string)
+			downloads[i].trackers = append(downloads[i].trackers, trackerDomain(url))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package rtorrentexporter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadsCollector_collectFilters(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	collector := NewDownloadsCollector(ds, CollectorOpts{
+		DownloadDetails: true,
+		FilterCounts:    true,
+		Filters: []DownloadFilter{
+			{Rule: "no-linux", Exclude: true, Field: FilterByLabel, Pattern: GlobPattern("linux*")},
+			{Rule: "private", Field: FilterByTracker, Pattern: regexp.MustCompile(`^tracker\.example\.org$`)},
+			{Rule: "seeding", Field: FilterByView, Pattern: GlobPattern("seeding")},
+		},
+	})
+
+	cmds := collector.filterCommands()
+	assert.Equal(t, []string{"d.hash=", "d.base_filename=", "d.custom1=", "d.views=", "d.state=", "d.complete=", "d.hashing="}, cmds)
+
+	ds.On("AllWithDetails", cmds).Return([][]any{
+		// Excluded by label even though its tracker is included
+		{"hash1", "name1", "linux-isos", []any{}, int64(1), int64(1), int64(0)},
+		{"hash2", "name2", "", []any{}, int64(1), int64(0), int64(0)},
+		{"hash3", "name3", "", []any{"seeding"}, int64(1), int64(1), int64(0)},
+		// Stopped, so only returned along with every download, and dropped as no
+		// include rule matches it
+		{"hash4", "name4", "", []any{}, int64(0), int64(1), int64(0)},
+	}, nil)
+	ds.On("TrackersWithDetails", []string{"hash1", "hash2", "hash3", "hash4"}, aggregateTrackerCommands).Return([][][]any{
+		{{"http://tracker.example.org/announce", int64(1)}},
+		{{"udp://tracker.example.org:1337", int64(1)}},
+		{{"dht://", int64(1)}},
+		{{"http://other.example.com/announce", int64(1)}},
+	}, nil)
+	ds.On("DownloadWithDetails", collector.getDownloadDetailCommands()).Return(detailRows, nil)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		desc, err := collector.collect(ch)
+		assert.Nil(t, desc)
+		assert.Nil(t, err)
+	}()
+
+	dropped := make(map[string]float64)
+	counts := make(map[*prometheus.Desc]float64)
+	hashes := make(map[string]bool)
+	for m := range ch {
+		switch m.Desc() {
+		case collector.DownloadsFiltered:
+			dropped[metricLabel(t, m, "rule")] = metricValue(t, m)
+		case collector.UploadBytesTotal:
+			hashes[metricLabel(t, m, "info_hash")] = true
+		default:
+			counts[m.Desc()] = metricValue(t, m)
+		}
+	}
+
+	assert.Equal(t, map[string]float64{"no-linux": 1, "private": 0, "seeding": 0, "default": 1}, dropped)
+	assert.Equal(t, map[string]bool{"hash2": true, "hash3": true}, hashes)
+	assert.Equal(t, float64(2), counts[collector.Downloads])
+	assert.Equal(t, float64(2), counts[collector.DownloadsStarted])
+	assert.Equal(t, float64(0), counts[collector.DownloadsStopped])
+	assert.Equal(t, float64(1), counts[collector.DownloadsComplete])
+	assert.Equal(t, float64(1), counts[collector.DownloadsSeeding])
+	assert.Equal(t, float64(1), counts[collector.DownloadsLeeching])
+	assert.Equal(t, float64(2), counts[collector.DownloadsActive])
+	ds.AssertExpectations(t)
+}
+
+func TestDownloadsCollector_collectFiltersExcludeOnly(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	collector := NewDownloadsCollector(ds, CollectorOpts{
+		DownloadDetails: true,
+		Filters: []DownloadFilter{
+			{Rule: "hash2", Exclude: true, Field: FilterByHash, Pattern: regexp.MustCompile(`^hash2$`)},
+		},
+	})
+
+	cmds := collector.filterCommands()
+	assert.Equal(t, []string{"d.hash=", "d.base_filename="}, cmds)
+
+	ds.On("AllWithDetails", cmds).Return([][]any{{"hash1", "name1"}, {"hash2", "name2"}, {"hash3", "name3"}, {"hash4", "name4"}}, nil)
+	// Counts are still reported from the view sizes
+	ds.On("ViewSizes", countViews).Return([]int{4, 4, 0, 0, 4, 0, 0, 4}, nil)
+	ds.On("DownloadWithDetails", collector.getDownloadDetailCommands()).Return(detailRows, nil)
+
+	ch := make(chan prometheus.Metric, 64)
+	desc, err := collector.collect(ch)
+	close(ch)
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+
+	var rules []string
+	for m := range ch {
+		if m.Desc() == collector.DownloadsFiltered {
+			rules = append(rules, metricLabel(t, m, "rule"))
+		}
+		if m.Desc() == collector.DownloadsActive {
+			assert.Equal(t, float64(3), metricValue(t, m))
+		}
+	}
+	// Without include rules nothing is dropped by default
+	assert.Equal(t, []string{"hash2"}, rules)
+	ds.AssertExpectations(t)
+}
+
+func TestValidateDownloadFilter(t *testing.T) {
+	re := regexp.MustCompile("x")
+	tests := []struct {
+		name   string
+		filter DownloadFilter
+		ok     bool
+	}{
+		{"valid", DownloadFilter{Rule: "r", Field: FilterByName, Pattern: re}, true},
+		{"missing rule", DownloadFilter{Field: FilterByName, Pattern: re}, false},
+		{"default rule", DownloadFilter{Rule: "default", Field: FilterByName, Pattern: re}, false},
+		{"unknown field", DownloadFilter{Rule: "r", Field: "ratio", Pattern: re}, false},
+		{"missing pattern", DownloadFilter{Rule: "r", Field: FilterByView}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ok, ValidateDownloadFilter(tt.filter) == nil)
+		})
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	re := GlobPattern("linux-*.is?")
+	assert.True(t, re.MatchString("linux-mint.iso"))
+	assert.False(t, re.MatchString("my-linux-mint.iso"))
+	assert.False(t, re.MatchString("linux-mint.isos"))
+	assert.True(t, GlobPattern("a.b").MatchString("a.b"))
+	assert.False(t, GlobPattern("a.b").MatchString("axb"))
+}
@@ -0,0 +1,134 @@
+package rtorrentexporter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PollOpts configures the background polling of an Exporter.
+type PollOpts struct {
+	// Interval is the time between two polls of rTorrent, polling is disabled
+	// when it is zero.
+	Interval time.Duration
+
+	// MaxAge bounds the age of the snapshot served by scrapes, past it the
+	// snapshot is considered invalid and rTorrent is reported as down. It
+	// defaults to three times Interval when zero.
+	MaxAge time.Duration
+}
+
+// Enabled reports whether polling is enabled.
+func (o PollOpts) Enabled() bool {
+	return o.Interval > 0
+}
+
+// maxAge returns MaxAge, or its default when it is zero.
+func (o PollOpts) maxAge() time.Duration {
+	if o.MaxAge == 0 {
+		return 3 * o.Interval
+	}
+	return o.MaxAge
+}
+
+// A snapshot holds the metrics collected by the last good poll of rTorrent.
+type snapshot struct {
+	mu      sync.RWMutex
+	metrics []prometheus.Metric
+	taken   time.Time
+	maxAge  time.Duration
+
+	now func() time.Time
+}
+
+// StartPolling makes the Exporter poll rTorrent on the interval of opts until
+// ctx is done, with scrapes served the metrics of the last good poll instead of
+// reaching rTorrent themselves. A poll is good when rTorrent could be reached,
+// so a failed poll keeps the previous snapshot until it grows older than
+// opts.MaxAge. StartPolling must be called before the Exporter is first
+// collected. The returned channel is closed once ctx is done and the last poll
+// finished.
+func (c *Exporter) StartPolling(ctx context.Context, opts PollOpts) <-chan struct{} {
+	c.snap = &snapshot{
+		maxAge: opts.maxAge(),
+		now:    time.Now,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		t := time.NewTicker(opts.Interval)
+		defer t.Stop()
+
+		for {
+			c.poll()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+// poll collects the metrics of each of the collectors from rTorrent and stores
+// them as the current snapshot if rTorrent could be reached.
+func (c *Exporter) poll() {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+
+	up := c.collectLive(ch)
+	close(ch)
+	metrics := <-done
+
+	if !up {
+		return
+	}
+
+	c.snap.mu.Lock()
+	defer c.snap.mu.Unlock()
+
+	c.snap.metrics = metrics
+	c.snap.taken = c.snap.now()
+}
+
+// collectSnapshot sends the metrics of the current snapshot along with its age.
+// If there is no snapshot yet, or it is older than its bound, only rTorrent
+// being down is reported.
+func (c *Exporter) collectSnapshot(ch chan<- prometheus.Metric) {
+	c.snap.mu.RLock()
+	defer c.snap.mu.RUnlock()
+
+	if c.snap.taken.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	age := c.snap.now().Sub(c.snap.taken)
+	ch <- prometheus.MustNewConstMetric(c.SnapshotAge, prometheus.GaugeValue, age.Seconds())
+
+	if age > c.snap.maxAge {
+		log.Printf("[ERROR] snapshot of rTorrent metrics%s is %v old, past its bound of %v", c.logInstance(), age, c.snap.maxAge)
+		ch <- prometheus.MustNewConstMetric(c.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	for _, m := range c.snap.metrics {
+		ch <- m
+	}
+}
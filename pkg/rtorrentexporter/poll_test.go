@@ -0,0 +1,92 @@
+package rtorrentexporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPolledExporter creates an Exporter serving snapshots bounded by maxAge,
+// with its clock under the control of the returned function.
+func newPolledExporter(ss SystemSource, maxAge time.Duration) (*Exporter, func(time.Duration)) {
+	e := newExporter(ss, nil)
+
+	now := time.Unix(1700000000, 0)
+	e.snap = &snapshot{
+		maxAge: maxAge,
+		now:    func() time.Time { return now },
+	}
+
+	return e, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestExporter_CollectSnapshot(t *testing.T) {
+	ss := new(MockSystemSource)
+	ss.On("ClientVersion").Return("0.9.8", nil).Once()
+	ss.On("ClientVersion").Return("", errors.New("connection refused"))
+	ss.On("StartupTime").Return(1700000000, nil)
+
+	e, advance := newPolledExporter(ss, time.Minute)
+
+	// Nothing was polled yet
+	assert.Equal(t, map[string]float64{e.Up.String(): 0}, collectExporter(t, e))
+
+	e.poll()
+	advance(10 * time.Second)
+	assert.Equal(t, map[string]float64{
+		e.Up.String():          1,
+		e.StartTime.String():   1700000000,
+		e.SnapshotAge.String(): 10,
+	}, collectExporter(t, e))
+
+	// A failed poll keeps serving the last good snapshot
+	e.poll()
+	advance(10 * time.Second)
+	assert.Equal(t, map[string]float64{
+		e.Up.String():          1,
+		e.StartTime.String():   1700000000,
+		e.SnapshotAge.String(): 20,
+	}, collectExporter(t, e))
+
+	// Until it grows past its bound
+	advance(time.Minute)
+	assert.Equal(t, map[string]float64{
+		e.Up.String():          0,
+		e.SnapshotAge.String(): 80,
+	}, collectExporter(t, e))
+
+	// Scrapes never reach rTorrent themselves
+	ss.AssertNumberOfCalls(t, "ClientVersion", 2)
+}
+
+func TestExporter_StartPolling(t *testing.T) {
+	ss := new(MockSystemSource)
+	ss.On("ClientVersion").Return("0.9.8", nil)
+	ss.On("StartupTime").Return(1700000000, nil)
+
+	e := newExporter(ss, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := e.StartPolling(ctx, PollOpts{Interval: 10 * time.Millisecond})
+	assert.Equal(t, 30*time.Millisecond, e.snap.maxAge)
+
+	assert.Eventually(t, func() bool {
+		return collectExporter(t, e)[e.Up.String()] == 1
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("polling didn't stop")
+	}
+}
+
+func TestPollOpts_Enabled(t *testing.T) {
+	assert.False(t, PollOpts{}.Enabled())
+	assert.True(t, PollOpts{Interval: time.Second}.Enabled())
+	assert.Equal(t, time.Minute, PollOpts{Interval: time.Second, MaxAge: time.Minute}.maxAge())
+}
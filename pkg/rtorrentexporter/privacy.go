@@ -0,0 +1,196 @@
+package rtorrentexporter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// NamesHMAC replaces download names with a keyed HMAC-SHA256 of the name.
+	NamesHMAC = "hmac"
+
+	// NamesTruncate replaces download names with their first characters.
+	NamesTruncate = "truncate"
+
+	// NamesDrop removes the name label from the download metrics.
+	NamesDrop = "drop"
+
+	// defaultHMACLength and defaultTruncateLength are the default number of
+	// characters of the pseudonyms.
+	defaultHMACLength     = 16
+	defaultTruncateLength = 8
+
+	// nameRetention is how long a name which isn't reported anymore can still
+	// be looked up, names are forgotten at most namesPruneInterval later.
+	nameRetention      = 24 * time.Hour
+	namesPruneInterval = time.Hour
+)
+
+// NamePrivacy selects how download names are hidden from the metrics.
+type NamePrivacy struct {
+	// Mode is NamesHMAC, NamesTruncate or NamesDrop, names are reported as is
+	// when it is empty.
+	Mode string
+
+	// Key is the secret key of NamesHMAC.
+	Key []byte
+
+	// Length is the number of characters pseudonyms are cut to, 16 hex
+	// characters for NamesHMAC and 8 characters for NamesTruncate when zero.
+	Length int
+}
+
+// ValidateNamePrivacy checks that p can be applied.
+func ValidateNamePrivacy(p NamePrivacy) error {
+	switch p.Mode {
+	case "", NamesDrop:
+	case NamesHMAC:
+		if len(p.Key) == 0 {
+			return fmt.Errorf("a key is required to hash names")
+		}
+	case NamesTruncate:
+	default:
+		return fmt.Errorf("unknown mode %q, must be %s, %s or %s", p.Mode, NamesHMAC, NamesTruncate, NamesDrop)
+	}
+	if p.Length < 0 {
+		return fmt.Errorf("length must not be negative")
+	}
+	return nil
+}
+
+// Names replaces download names with pseudonyms as selected by its NamePrivacy
+// and remembers the names behind each pseudonym, so that operators can look
+// them up. Names which weren't reported for nameRetention are forgotten, so
+// that downloads coming and going don't accumulate. A nil *Names reports names
+// as is.
+type Names struct {
+	privacy NamePrivacy
+
+	mu sync.Mutex
+	// names maps each pseudonym to its names and when they were last
+	// reported.
+	names  map[string]map[string]time.Time
+	pruned time.Time
+
+	now func() time.Time
+}
+
+// NewNames creates Names which applies the provided privacy settings.
+func NewNames(p NamePrivacy) *Names {
+	return &Names{
+		privacy: p,
+		names:   make(map[string]map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Drops reports whether the name label is removed from the metrics.
+func (n *Names) Drops() bool {
+	return n != nil && n.privacy.Mode == NamesDrop
+}
+
+// Pseudonym returns the label value reported for the download name.
+func (n *Names) Pseudonym(name string) string {
+	if n == nil {
+		return name
+	}
+
+	var p string
+	switch n.privacy.Mode {
+	case NamesHMAC:
+		mac := hmac.New(sha256.New, n.privacy.Key)
+		mac.Write([]byte(name))
+		p = hex.EncodeToString(mac.Sum(nil))
+		p = p[:min(n.length(defaultHMACLength), len(p))]
+	case NamesTruncate:
+		r := []rune(name)
+		p = string(r[:min(n.length(defaultTruncateLength), len(r))])
+	default:
+		return name
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	if n.names[p] == nil {
+		n.names[p] = make(map[string]time.Time)
+	}
+	n.names[p][name] = now
+
+	if now.Sub(n.pruned) >= namesPruneInterval {
+		n.prune(now)
+	}
+
+	return p
+}
+
+// prune forgets the names which weren't reported for nameRetention.
+func (n *Names) prune(now time.Time) {
+	for p, names := range n.names {
+		for name, seen := range names {
+			if now.Sub(seen) > nameRetention {
+				delete(names, name)
+			}
+		}
+		if len(names) == 0 {
+			delete(n.names, p)
+		}
+	}
+	n.pruned = now
+}
+
+// length returns the length of pseudonyms, def when it isn't set.
+func (n *Names) length(def int) int {
+	if n.privacy.Length > 0 {
+		return n.privacy.Length
+	}
+	return def
+}
+
+// Lookup returns the sorted names which were reported as the pseudonym.
+func (n *Names) Lookup(pseudonym string) []string {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return sortedNames(n.names[pseudonym])
+}
+
+// All returns the sorted names behind every pseudonym reported so far.
+func (n *Names) All() map[string][]string {
+	all := make(map[string][]string)
+	if n == nil {
+		return all
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for p, names := range n.names {
+		all[p] = sortedNames(names)
+	}
+	return all
+}
+
+// sortedNames returns the keys of names in order.
+func sortedNames(names map[string]time.Time) []string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	s := make([]string, 0, len(names))
+	for name := range names {
+		s = append(s, name)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,112 @@
+package rtorrentexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames_Pseudonym(t *testing.T) {
+	var none *Names
+	assert.Equal(t, "Some.Linux.ISO", none.Pseudonym("Some.Linux.ISO"))
+	assert.False(t, none.Drops())
+
+	hashed := NewNames(NamePrivacy{Mode: NamesHMAC, Key: []byte("secret")})
+	p := hashed.Pseudonym("Some.Linux.ISO")
+	assert.Len(t, p, defaultHMACLength)
+	assert.Equal(t, p, hashed.Pseudonym("Some.Linux.ISO"))
+	assert.NotEqual(t, p, hashed.Pseudonym("Other.Linux.ISO"))
+	// The pseudonyms depend on the key
+	assert.NotEqual(t, p, NewNames(NamePrivacy{Mode: NamesHMAC, Key: []byte("other")}).Pseudonym("Some.Linux.ISO"))
+	assert.Equal(t, []string{"Some.Linux.ISO"}, hashed.Lookup(p))
+
+	truncated := NewNames(NamePrivacy{Mode: NamesTruncate, Length: 4})
+	assert.Equal(t, "Some", truncated.Pseudonym("Some.Linux.ISO"))
+	assert.Equal(t, "Some", truncated.Pseudonym("Something"))
+	assert.Equal(t, "Ü", truncated.Pseudonym("Ü"))
+	assert.Equal(t, []string{"Some.Linux.ISO", "Something"}, truncated.Lookup("Some"))
+	assert.Equal(t, map[string][]string{"Some": {"Some.Linux.ISO", "Something"}, "Ü": {"Ü"}}, truncated.All())
+	assert.Nil(t, truncated.Lookup("None"))
+
+	assert.True(t, NewNames(NamePrivacy{Mode: NamesDrop}).Drops())
+}
+
+func TestNames_Prune(t *testing.T) {
+	now := time.Now()
+	names := NewNames(NamePrivacy{Mode: NamesTruncate, Length: 4})
+	names.now = func() time.Time { return now }
+
+	names.Pseudonym("Some.Linux.ISO")
+	names.Pseudonym("Something")
+	names.Pseudonym("Other.Linux.ISO")
+
+	// Only Something keeps being reported
+	now = now.Add(nameRetention / 2)
+	names.Pseudonym("Something")
+	now = now.Add(nameRetention/2 + namesPruneInterval)
+	names.Pseudonym("Something")
+
+	assert.Equal(t, map[string][]string{"Some": {"Something"}}, names.All())
+	assert.Nil(t, names.Lookup("Othe"))
+}
+
+func TestDownloadsCollector_namePrivacy(t *testing.T) {
+	row := []any{"hash1", "Some.Linux.ISO", "linux", int64(10), int64(100), int64(0), int64(1000)}
+	labels := []DetailLabel{{Name: "label", Command: "d.custom1="}}
+
+	tests := []struct {
+		name  string
+		names *Names
+		want  map[string]string
+	}{
+		{
+			name: "names",
+			want: map[string]string{"info_hash": "hash1", "name": "Some.Linux.ISO", "label": "linux"},
+		},
+		{
+			name:  "truncated",
+			names: NewNames(NamePrivacy{Mode: NamesTruncate, Length: 4}),
+			want:  map[string]string{"info_hash": "hash1", "name": "Some", "label": "linux"},
+		},
+		{
+			name:  "dropped",
+			names: NewNames(NamePrivacy{Mode: NamesDrop}),
+			want:  map[string]string{"info_hash": "hash1", "label": "linux"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewDownloadsCollector(nil, CollectorOpts{DownloadDetails: true, DetailLabels: labels, Names: tt.names})
+
+			d, desc, err := collector.decodeDownloadDetails(row)
+			assert.Nil(t, desc)
+			assert.Nil(t, err)
+
+			ch := make(chan prometheus.Metric, 16)
+			collector.sendDownloadDetails(d, ch)
+			close(ch)
+
+			for m := range ch {
+				got := make(map[string]string)
+				for _, name := range []string{"info_hash", "name", "label"} {
+					if v := metricLabel(t, m, name); v != "" {
+						got[name] = v
+					}
+				}
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateNamePrivacy(t *testing.T) {
+	assert.Nil(t, ValidateNamePrivacy(NamePrivacy{}))
+	assert.Nil(t, ValidateNamePrivacy(NamePrivacy{Mode: NamesHMAC, Key: []byte("secret")}))
+	assert.Nil(t, ValidateNamePrivacy(NamePrivacy{Mode: NamesTruncate, Length: 12}))
+	assert.NotNil(t, ValidateNamePrivacy(NamePrivacy{Mode: NamesHMAC}))
+	assert.NotNil(t, ValidateNamePrivacy(NamePrivacy{Mode: "rot13"}))
+	assert.NotNil(t, ValidateNamePrivacy(NamePrivacy{Mode: NamesTruncate, Length: -1}))
+}
@@ -3,17 +3,59 @@
 package rtorrentexporter
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 
-	"github.com/aauren/rtorrent/rtorrent"
+	"github.com/aauren/rtorrent-exporter/pkg/retry"
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/aauren/rtorrent-exporter/pkg/session"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
 	// namespace is the top-level namespace for this rTorrent exporter.
 	namespace = "rtorrent"
+
+	// exporterSubsystem is the subsystem for metrics about the exporter itself.
+	exporterSubsystem = "exporter"
+
+	// InstanceLabel is the label identifying which rTorrent instance metrics
+	// were collected from when several instances are exported at once.
+	InstanceLabel = "rtorrent_instance"
 )
 
+var (
+	_ SystemSource    = &rtorrentrpc.SystemService{}
+	_ SystemSource    = &session.Source{}
+	_ DownloadsSource = &session.Source{}
+)
+
+// A SystemSource is a type which can retrieve information about the rTorrent
+// process itself. It is implemented by *rtorrentrpc.SystemService and
+// *session.Source.
+type SystemSource interface {
+	ClientVersion() (string, error)
+	StartupTime() (int, error)
+}
+
+// A collector is a prometheus.Collector which also reports whether its
+// collection succeeded, so that the Exporter can report on the health of each
+// collector while still returning the metrics of the others.
+type collector interface {
+	prometheus.Collector
+
+	collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error)
+}
+
+// A namedCollector is a collector along with the name it is reported under.
+type namedCollector struct {
+	name string
+	c    collector
+}
+
 // An Exporter is a Prometheus exporter for rTorrent metrics.
 // It wraps all rTorrent metrics collectors and provides a single global
 // exporter which can serve metrics. It also ensures that the collection
@@ -21,38 +63,250 @@ const (
 // Prometheus. It implements the prometheus.Collector interface in order to
 // register with Prometheus.
 type Exporter struct {
+	Up                *prometheus.Desc
+	CollectorSuccess  *prometheus.Desc
+	CollectorDuration *prometheus.Desc
+	SnapshotAge       *prometheus.Desc
+	StartTime         *prometheus.Desc
+
 	mu         sync.Mutex
-	collectors []prometheus.Collector
+	ss         SystemSource
+	collectors []namedCollector
+
+	// snap holds the metrics of the last poll, it is nil unless StartPolling
+	// was called.
+	snap *snapshot
+
+	// startTime is the startup time of rTorrent seen by the last collection,
+	// a change means rTorrent restarted and reset its counters.
+	startTime int
+
+	// instance is the name the Exporter was registered under with
+	// RegisterInstance, it is only used to tell instances apart in logs.
+	instance string
+
+	// retry is the transport retrying the calls to rTorrent, if any, and
+	// scrapeTimeout bounds its retries during a collection through the
+	// context of the calls made by client.
+	retry         *retry.Transport
+	client        *rtorrentrpc.Client
+	scrapeTimeout time.Duration
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
 var _ prometheus.Collector = &Exporter{}
 
 // New creates a new Exporter which collects metrics from one or mote sites.
-func New(c *rtorrent.Client, collectOpts CollectorOpts) *Exporter {
+func New(c *rtorrentrpc.Client, collectOpts CollectorOpts) *Exporter {
+	collectors := []namedCollector{
+		{"downloads", NewDownloadsCollector(c.Downloads, collectOpts)},
+		{"throttle", NewThrottleCollector(c.Throttle)},
+	}
+
+	if collectOpts.Trackers {
+		collectors = append(collectors, namedCollector{"trackers", NewTrackersCollector(c.Downloads)})
+	}
+
+	if collectOpts.Aggregation != nil {
+		collectors = append(collectors, namedCollector{"aggregate", NewAggregateCollector(c.Downloads, *collectOpts.Aggregation)})
+	}
+
+	e := newExporter(c.System, collectors)
+	if rt, ok := c.Transport().(*retry.Transport); ok {
+		e.retry, e.client, e.scrapeTimeout = rt, c, collectOpts.ScrapeTimeout
+	}
+	return e
+}
+
+// NewSession creates a new Exporter which collects download metrics from the
+// session directory read by s, for when rTorrent's XML-RPC interface is
+// disabled or unresponsive. Throttle, tracker and peer metrics aren't saved in
+// the session directory, so only the downloads and aggregate collectors are
+// run.
+func NewSession(s *session.Source, collectOpts CollectorOpts) *Exporter {
+	collectors := []namedCollector{
+		{"downloads", NewDownloadsCollector(s, collectOpts)},
+	}
+
+	if collectOpts.Aggregation != nil {
+		collectors = append(collectors, namedCollector{"aggregate", NewAggregateCollector(s, *collectOpts.Aggregation)})
+	}
+
+	return newExporter(s, collectors)
+}
+
+// RegisterInstance registers the Exporter with reg, adding an InstanceLabel
+// with the provided name to every metric it collects. Each registered Exporter
+// is collected concurrently by the registry, so a failing instance neither
+// hides nor delays the metrics of the others.
+func RegisterInstance(reg prometheus.Registerer, name string, e *Exporter) error {
+	e.instance = name
+	return prometheus.WrapRegistererWith(prometheus.Labels{InstanceLabel: name}, reg).Register(e)
+}
+
+// newExporter creates a new Exporter which checks that rTorrent is up using ss
+// and then runs each of the provided collectors.
+func newExporter(ss SystemSource, collectors []namedCollector) *Exporter {
 	return &Exporter{
-		collectors: []prometheus.Collector{
-			NewDownloadsCollector(c.Downloads, collectOpts),
-		},
+		Up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether rTorrent could be reached (1 for yes, 0 for no).",
+			nil,
+			nil,
+		),
+
+		CollectorSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, exporterSubsystem, "collector_success"),
+			"Whether a collector succeeded (1 for yes, 0 for no).",
+			[]string{"collector"},
+			nil,
+		),
+
+		CollectorDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, exporterSubsystem, "collector_duration_seconds"),
+			"Duration of a collector's collection in seconds.",
+			[]string{"collector"},
+			nil,
+		),
+
+		SnapshotAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, exporterSubsystem, "snapshot_age_seconds"),
+			"Age of the polled snapshot of rTorrent metrics served by scrapes in seconds.",
+			nil,
+			nil,
+		),
+
+		StartTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "start_time_seconds"),
+			"Start time of rTorrent since unix epoch in seconds, its counters are reset when it changes.",
+			nil,
+			nil,
+		),
+
+		ss:         ss,
+		collectors: collectors,
 	}
 }
 
 // Describe sends all the descriptors of the collectors included to
 // the provided channel.
 func (c *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Up
+	ch <- c.CollectorSuccess
+	ch <- c.CollectorDuration
+	ch <- c.SnapshotAge
+	ch <- c.StartTime
+
 	for _, cc := range c.collectors {
-		cc.Describe(ch)
+		cc.c.Describe(ch)
+	}
+
+	if c.retry != nil {
+		c.retry.Describe(ch)
 	}
 }
 
 // Collect sends the collected metrics from each of the collectors to
-// prometheus. Collect could be called several times concurrently
-// and thus its run is protected by a single mutex.
+// prometheus. When polling was started with StartPolling, the metrics of the
+// last poll are sent instead without reaching rTorrent.
 func (c *Exporter) Collect(ch chan<- prometheus.Metric) {
+	if c.snap != nil {
+		c.collectSnapshot(ch)
+	} else {
+		c.collectLive(ch)
+	}
+
+	// The retry metrics are current rather than part of the snapshot, and
+	// include the retries of this collection
+	if c.retry != nil {
+		c.retry.Collect(ch)
+	}
+}
+
+// collectLive collects the metrics of each of the collectors from rTorrent and
+// reports whether rTorrent could be reached. collectLive could be called
+// several times concurrently and thus its run is protected by a single mutex.
+//
+// A failing collector doesn't fail the whole scrape, instead its failure is
+// reported through the collector success metric and the metrics of the other
+// collectors are still returned. If rTorrent can't be reached at all, the
+// collectors are skipped rather than each waiting to time out.
+func (c *Exporter) collectLive(ch chan<- prometheus.Metric) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.client != nil && c.scrapeTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTimeout)
+		defer cancel()
+		c.client.SetContext(ctx)
+		defer c.client.SetContext(nil)
+	}
+
+	up := 1.0
+	if _, err := c.ss.ClientVersion(); err != nil {
+		log.Printf("[ERROR] failed to reach rTorrent%s: %v", c.logInstance(), err)
+		up = 0
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.Up,
+		prometheus.GaugeValue,
+		up,
+	)
+
+	if up == 1 {
+		c.collectStartTime(ch)
+	}
+
 	for _, cc := range c.collectors {
-		cc.Collect(ch)
+		if up == 0 {
+			ch <- prometheus.MustNewConstMetric(c.CollectorSuccess, prometheus.GaugeValue, 0, cc.name)
+			continue
+		}
+
+		start := time.Now()
+		desc, err := cc.c.collect(ch)
+		duration := time.Since(start)
+
+		success := 1.0
+		if err != nil {
+			log.Printf("[ERROR] collector %q%s failed collecting metric %v: %v", cc.name, c.logInstance(), desc, err)
+			success = 0
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.CollectorSuccess, prometheus.GaugeValue, success, cc.name)
+		ch <- prometheus.MustNewConstMetric(c.CollectorDuration, prometheus.GaugeValue, duration.Seconds(), cc.name)
+	}
+
+	return up == 1
+}
+
+// collectStartTime collects the startup time of rTorrent and logs when it
+// changed since the last collection. Failing to retrieve it doesn't fail the
+// collection, as it is only informative.
+func (c *Exporter) collectStartTime(ch chan<- prometheus.Metric) {
+	start, err := c.ss.StartupTime()
+	if err != nil {
+		log.Printf("[ERROR] failed retrieving rTorrent startup time%s: %v", c.logInstance(), err)
+		return
+	}
+
+	if c.startTime != 0 && start != c.startTime {
+		log.Printf("rTorrent%s restarted at %v, its counters were reset", c.logInstance(), time.Unix(int64(start), 0))
+	}
+	c.startTime = start
+
+	ch <- prometheus.MustNewConstMetric(
+		c.StartTime,
+		prometheus.GaugeValue,
+		float64(start),
+	)
+}
+
+// logInstance returns a suffix identifying the instance in log messages.
+func (c *Exporter) logInstance() string {
+	if c.instance == "" {
+		return ""
 	}
+	return fmt.Sprintf(" (instance %q)", c.instance)
 }
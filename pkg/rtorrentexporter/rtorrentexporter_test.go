@@ -0,0 +1,172 @@
+package rtorrentexporter
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aauren/rtorrent-exporter/pkg/session"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockSystemSource is a mock implementation of the SystemSource interface.
+type MockSystemSource struct {
+	mock.Mock
+}
+
+func (m *MockSystemSource) ClientVersion() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSystemSource) StartupTime() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// collectExporter runs a collection of the Exporter and returns the collected
+// metric values keyed by descriptor and label values.
+func collectExporter(t *testing.T, e *Exporter) map[string]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+
+	got := make(map[string]float64)
+	for m := range ch {
+		key := m.Desc().String()
+		if m.Desc() == e.CollectorSuccess || m.Desc() == e.CollectorDuration {
+			key += metricLabel(t, m, "collector")
+		}
+		got[key] = metricValue(t, m)
+	}
+	return got
+}
+
+func TestExporter_CollectPartialFailure(t *testing.T) {
+	ss := new(MockSystemSource)
+	ss.On("ClientVersion").Return("0.9.8", nil)
+	ss.On("StartupTime").Return(1700000000, nil)
+
+	ds := new(MockDownloadsSource)
+	ds.On("ViewSizes", countViews).Return([]int(nil), errors.New("fault"))
+
+	ts := new(MockThrottleSource)
+	ts.On("Globals").Return(throttleGlobals, nil)
+
+	throttle := NewThrottleCollector(ts)
+	e := newExporter(ss, []namedCollector{
+		{"downloads", NewDownloadsCollector(ds, CollectorOpts{})},
+		{"throttle", throttle},
+	})
+
+	got := collectExporter(t, e)
+	assert.Equal(t, float64(1), got[e.Up.String()])
+	assert.Equal(t, float64(1700000000), got[e.StartTime.String()])
+	assert.Equal(t, float64(0), got[e.CollectorSuccess.String()+"downloads"])
+	assert.Equal(t, float64(1), got[e.CollectorSuccess.String()+"throttle"])
+	assert.Contains(t, got, e.CollectorDuration.String()+"downloads")
+	assert.Contains(t, got, e.CollectorDuration.String()+"throttle")
+	assert.Equal(t, float64(200), got[throttle.UploadRateBytes.String()])
+}
+
+func TestExporter_CollectDown(t *testing.T) {
+	ss := new(MockSystemSource)
+	ss.On("ClientVersion").Return("", errors.New("connection refused"))
+
+	ds := new(MockDownloadsSource)
+	e := newExporter(ss, []namedCollector{
+		{"downloads", NewDownloadsCollector(ds, CollectorOpts{})},
+	})
+
+	got := collectExporter(t, e)
+	assert.Equal(t, map[string]float64{
+		e.Up.String(): 0,
+		e.CollectorSuccess.String() + "downloads": 0,
+	}, got)
+	ds.AssertNotCalled(t, "ViewSizes", mock.Anything)
+}
+
+func TestRegisterInstance(t *testing.T) {
+	upSource := new(MockSystemSource)
+	upSource.On("ClientVersion").Return("0.9.8", nil)
+	upSource.On("StartupTime").Return(1700000000, nil)
+	downSource := new(MockSystemSource)
+	downSource.On("ClientVersion").Return("", errors.New("connection refused"))
+
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, RegisterInstance(reg, "seedbox1", newExporter(upSource, nil)))
+	assert.Nil(t, RegisterInstance(reg, "seedbox2", newExporter(downSource, nil)))
+
+	expected := `
+# HELP rtorrent_up Whether rTorrent could be reached (1 for yes, 0 for no).
+# TYPE rtorrent_up gauge
+rtorrent_up{rtorrent_instance="seedbox1"} 1
+rtorrent_up{rtorrent_instance="seedbox2"} 0
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "rtorrent_up"))
+}
+
+func TestExporter_Describe(t *testing.T) {
+	e := newExporter(new(MockSystemSource), []namedCollector{
+		{"throttle", NewThrottleCollector(nil)},
+	})
+	ch := make(chan *prometheus.Desc)
+
+	go func() {
+		defer close(ch)
+		e.Describe(ch)
+	}()
+
+	count := 0
+	for range ch {
+		count++
+	}
+	assert.Equal(t, 11, count)
+}
+
+func TestExporter_CollectStartTime(t *testing.T) {
+	ss := new(MockSystemSource)
+	ss.On("ClientVersion").Return("0.9.8", nil)
+	ss.On("StartupTime").Return(1700000000, nil).Once()
+	ss.On("StartupTime").Return(1700003600, nil).Once()
+	ss.On("StartupTime").Return(0, errors.New("fault"))
+
+	e := newExporter(ss, nil)
+	assert.Equal(t, float64(1700000000), collectExporter(t, e)[e.StartTime.String()])
+
+	// A restart is reported through the new start time
+	assert.Equal(t, float64(1700003600), collectExporter(t, e)[e.StartTime.String()])
+	assert.Equal(t, 1700003600, e.startTime)
+
+	// Failing to retrieve the start time doesn't take rTorrent down
+	assert.Equal(t, map[string]float64{e.Up.String(): 1}, collectExporter(t, e))
+}
+
+func TestNewSession(t *testing.T) {
+	s := session.New(filepath.Join("..", "session", "testdata", "session"))
+	e := NewSession(s, CollectorOpts{
+		Aggregation: &Aggregation{By: AggregateByView, Label: DetailLabel{Name: "view"}},
+	})
+
+	got := collectExporter(t, e)
+	assert.Equal(t, float64(1), got[e.Up.String()])
+	assert.Equal(t, float64(1), got[e.CollectorSuccess.String()+"downloads"])
+	assert.Equal(t, float64(1), got[e.CollectorSuccess.String()+"aggregate"])
+	assert.NotContains(t, got, e.CollectorSuccess.String()+"throttle")
+
+	downloads := e.collectors[0].c.(*DownloadsCollector)
+	assert.Equal(t, float64(3), got[downloads.Downloads.String()])
+
+	// Rates aren't saved in the session directory, so no download is active
+	// and details fail rather than go missing
+	e = NewSession(s, CollectorOpts{DownloadDetails: true})
+	assert.Equal(t, float64(0), collectExporter(t, e)[e.CollectorSuccess.String()+"downloads"])
+}
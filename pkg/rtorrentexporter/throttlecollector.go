@@ -0,0 +1,148 @@
+package rtorrentexporter
+
+import (
+	"log"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ ThrottleSource = &rtorrentrpc.ThrottleService{}
+
+// A ThrottleSource is a type which can retrieve client-wide transfer and
+// throttle information from rTorrent. It is implemented by
+// *rtorrentrpc.ThrottleService.
+type ThrottleSource interface {
+	Globals() (rtorrentrpc.Globals, error)
+}
+
+// A ThrottleCollector is a Prometheus collector for metrics regarding rTorrent's
+// client-wide throughput and throttle settings.
+type ThrottleCollector struct {
+	DownloadRateBytes    *prometheus.Desc
+	UploadRateBytes      *prometheus.Desc
+	DownloadBytesTotal   *prometheus.Desc
+	UploadBytesTotal     *prometheus.Desc
+	DownloadMaxRateBytes *prometheus.Desc
+	UploadMaxRateBytes   *prometheus.Desc
+
+	ts ThrottleSource
+}
+
+// Verify that ThrottleCollector implements the prometheus.Collector interface.
+var _ prometheus.Collector = &ThrottleCollector{}
+
+// NewThrottleCollector creates a new ThrottleCollector which collects metrics
+// regarding rTorrent's client-wide throughput and throttle settings.
+func NewThrottleCollector(ts ThrottleSource) *ThrottleCollector {
+	const (
+		subsystem = "throttle"
+	)
+
+	return &ThrottleCollector{
+		DownloadRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_download_rate_bytes"),
+			"Current client-wide download rate in bytes.",
+			nil,
+			nil,
+		),
+
+		UploadRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_upload_rate_bytes"),
+			"Current client-wide upload rate in bytes.",
+			nil,
+			nil,
+		),
+
+		DownloadBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_download_bytes_total"),
+			"Total bytes downloaded since rTorrent startup.",
+			nil,
+			nil,
+		),
+
+		UploadBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_upload_bytes_total"),
+			"Total bytes uploaded since rTorrent startup.",
+			nil,
+			nil,
+		),
+
+		DownloadMaxRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_download_max_rate_bytes"),
+			"Configured client-wide download rate limit in bytes, 0 means unlimited.",
+			nil,
+			nil,
+		),
+
+		UploadMaxRateBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "global_upload_max_rate_bytes"),
+			"Configured client-wide upload rate limit in bytes, 0 means unlimited.",
+			nil,
+			nil,
+		),
+
+		ts: ts,
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to rTorrent
+// throughput and throttling.
+func (c *ThrottleCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	// Every value is retrieved in a single round trip before sending anything,
+	// so that a failure doesn't leave a partial set of metrics behind
+	g, err := c.ts.Globals()
+	if err != nil {
+		return c.DownloadRateBytes, err
+	}
+
+	metrics := []struct {
+		desc      *prometheus.Desc
+		valueType prometheus.ValueType
+		value     int
+	}{
+		{c.DownloadRateBytes, prometheus.GaugeValue, g.DownloadRate},
+		{c.UploadRateBytes, prometheus.GaugeValue, g.UploadRate},
+		{c.DownloadBytesTotal, prometheus.CounterValue, g.DownloadTotal},
+		{c.UploadBytesTotal, prometheus.CounterValue, g.UploadTotal},
+		{c.DownloadMaxRateBytes, prometheus.GaugeValue, g.DownloadMaxRate},
+		{c.UploadMaxRateBytes, prometheus.GaugeValue, g.UploadMaxRate},
+	}
+
+	for _, m := range metrics {
+		ch <- prometheus.MustNewConstMetric(
+			m.desc,
+			m.valueType,
+			float64(m.value),
+		)
+	}
+
+	return nil, nil
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *ThrottleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.DownloadRateBytes,
+		c.UploadRateBytes,
+		c.DownloadBytesTotal,
+		c.UploadBytesTotal,
+		c.DownloadMaxRateBytes,
+		c.UploadMaxRateBytes,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect sends the metric values for each metric pertaining to the rTorrent
+// throughput and throttle settings to the provided prometheus Metric channel.
+func (c *ThrottleCollector) Collect(ch chan<- prometheus.Metric) {
+	if desc, err := c.collect(ch); err != nil {
+		log.Printf("[ERROR] failed collecting throttle metric %v: %v", desc, err)
+		ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+}
@@ -0,0 +1,83 @@
+package rtorrentexporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockThrottleSource is a mock implementation of the ThrottleSource interface.
+type MockThrottleSource struct {
+	mock.Mock
+}
+
+func (m *MockThrottleSource) Globals() (rtorrentrpc.Globals, error) {
+	args := m.Called()
+	return args.Get(0).(rtorrentrpc.Globals), args.Error(1)
+}
+
+// throttleGlobals are the values returned by MockThrottleSource.
+var throttleGlobals = rtorrentrpc.Globals{
+	DownloadRate:    100,
+	UploadRate:      200,
+	DownloadTotal:   3000,
+	UploadTotal:     4000,
+	DownloadMaxRate: 0,
+	UploadMaxRate:   5000,
+}
+
+func TestThrottleCollector_Collect(t *testing.T) {
+	ts := new(MockThrottleSource)
+	ts.On("Globals").Return(throttleGlobals, nil)
+
+	collector := NewThrottleCollector(ts)
+
+	expected := `
+# HELP rtorrent_throttle_global_download_bytes_total Total bytes downloaded since rTorrent startup.
+# TYPE rtorrent_throttle_global_download_bytes_total counter
+rtorrent_throttle_global_download_bytes_total 3000
+# HELP rtorrent_throttle_global_download_max_rate_bytes Configured client-wide download rate limit in bytes, 0 means unlimited.
+# TYPE rtorrent_throttle_global_download_max_rate_bytes gauge
+rtorrent_throttle_global_download_max_rate_bytes 0
+# HELP rtorrent_throttle_global_download_rate_bytes Current client-wide download rate in bytes.
+# TYPE rtorrent_throttle_global_download_rate_bytes gauge
+rtorrent_throttle_global_download_rate_bytes 100
+# HELP rtorrent_throttle_global_upload_bytes_total Total bytes uploaded since rTorrent startup.
+# TYPE rtorrent_throttle_global_upload_bytes_total counter
+rtorrent_throttle_global_upload_bytes_total 4000
+# HELP rtorrent_throttle_global_upload_max_rate_bytes Configured client-wide upload rate limit in bytes, 0 means unlimited.
+# TYPE rtorrent_throttle_global_upload_max_rate_bytes gauge
+rtorrent_throttle_global_upload_max_rate_bytes 5000
+# HELP rtorrent_throttle_global_upload_rate_bytes Current client-wide upload rate in bytes.
+# TYPE rtorrent_throttle_global_upload_rate_bytes gauge
+rtorrent_throttle_global_upload_rate_bytes 200
+`
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestThrottleCollector_collectError(t *testing.T) {
+	ts := new(MockThrottleSource)
+	ts.On("Globals").Return(rtorrentrpc.Globals{}, errors.New("connection refused"))
+
+	collector := NewThrottleCollector(ts)
+	ch := make(chan prometheus.Metric)
+
+	go func() {
+		defer close(ch)
+		desc, err := collector.collect(ch)
+		assert.Equal(t, collector.DownloadRateBytes, desc)
+		assert.NotNil(t, err)
+	}()
+
+	count := 0
+	for range ch {
+		count++
+	}
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,142 @@
+package rtorrentexporter
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// TopByUploadRate ranks downloads by their current upload rate.
+	TopByUploadRate = "upload_rate"
+
+	// TopByDownloadRate ranks downloads by their current download rate.
+	TopByDownloadRate = "download_rate"
+
+	// TopByActivity ranks downloads by their current upload and download
+	// rates combined, as of the scrape rather than over a window.
+	TopByActivity = "activity"
+
+	// otherDownloads is the info_hash and name of the download summing the
+	// downloads outside of the top downloads.
+	otherDownloads = "other"
+
+	// topDownloadsHysteresis raises the ranking of the downloads selected by
+	// the previous collection, so that another download only displaces one of
+	// them by ranking half again as high. Rates change from one scrape to the
+	// next, without it downloads near the cut would flap in and out.
+	topDownloadsHysteresis = 1.5
+)
+
+// ValidTopDownloadsBy reports whether by is a valid ranking of the top
+// downloads, the empty string ranks by TopByUploadRate.
+func ValidTopDownloadsBy(by string) bool {
+	switch by {
+	case "", TopByUploadRate, TopByDownloadRate, TopByActivity:
+		return true
+	default:
+		return false
+	}
+}
+
+// selectTopDownloads returns the details of the top downloads, if they are
+// limited, along with a download summing the value of each column across all
+// the other downloads. The latter is nil if no download was left out.
+//
+// Counter columns aren't summed, as the sum would drop whenever a download
+// enters the top downloads and look like a counter reset. The counters of the
+// top downloads thus fall short of the client-wide totals.
+func (c *DownloadsCollector) selectTopDownloads(details []downloadDetail) ([]downloadDetail, *downloadDetail) {
+	n := c.collectOpts.TopDownloads
+	if n <= 0 || len(details) <= n {
+		return details, nil
+	}
+
+	c.topMu.Lock()
+	defer c.topMu.Unlock()
+
+	rank := c.topDownloadsRank()
+	ranks := make(map[string]float64, len(details))
+	for _, d := range details {
+		r := rank(d)
+		if c.top[d.labels[0]] {
+			r *= topDownloadsHysteresis
+		}
+		ranks[d.labels[0]] = r
+	}
+
+	sorted := make([]downloadDetail, len(details))
+	copy(sorted, details)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		hi, hj := sorted[i].labels[0], sorted[j].labels[0]
+		if ranks[hi] != ranks[hj] {
+			return ranks[hi] > ranks[hj]
+		}
+		// Keep the selection stable across scrapes when downloads are tied,
+		// most commonly when they are idle
+		if c.top[hi] != c.top[hj] {
+			return c.top[hi]
+		}
+		return hi < hj
+	})
+
+	c.top = make(map[string]bool, n)
+	for _, d := range sorted[:n] {
+		c.top[d.labels[0]] = true
+	}
+
+	labels := make([]string, len(sorted[0].labels))
+	labels[0] = otherDownloads
+	if !c.collectOpts.Names.Drops() {
+		labels[1] = otherDownloads
+	}
+
+	other := &downloadDetail{
+		labels: labels,
+		values: make([]float64, len(c.detailColumns)),
+		has:    make([]bool, len(c.detailColumns)),
+	}
+	for _, d := range sorted[n:] {
+		for i := range d.values {
+			if d.has[i] && c.detailColumns[i].valueType != prometheus.CounterValue {
+				other.values[i] += d.values[i]
+				other.has[i] = true
+			}
+		}
+	}
+
+	return sorted[:n], other
+}
+
+// topDownloadsRank returns the function ranking downloads by TopDownloadsBy.
+func (c *DownloadsCollector) topDownloadsRank() func(downloadDetail) float64 {
+	up := c.detailColumnIndex("d.up.rate=")
+	down := c.detailColumnIndex("d.down.rate=")
+
+	value := func(d downloadDetail, i int) float64 {
+		if i < 0 {
+			return 0
+		}
+		return d.values[i]
+	}
+
+	switch c.collectOpts.TopDownloadsBy {
+	case TopByDownloadRate:
+		return func(d downloadDetail) float64 { return value(d, down) }
+	case TopByActivity:
+		return func(d downloadDetail) float64 { return value(d, up) + value(d, down) }
+	default:
+		return func(d downloadDetail) float64 { return value(d, up) }
+	}
+}
+
+// detailColumnIndex returns the index of the detail column retrieved with cmd,
+// or -1 if there is none.
+func (c *DownloadsCollector) detailColumnIndex(cmd string) int {
+	for i, col := range c.detailColumns {
+		if col.cmd == cmd {
+			return i
+		}
+	}
+	return -1
+}
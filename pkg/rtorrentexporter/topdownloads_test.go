@@ -0,0 +1,176 @@
+package rtorrentexporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// detailRows are rows of the default download details: hash, name, down.rate,
+// down.total, up.rate and up.total.
+var detailRows = [][]any{
+	{"hash1", "name1", int64(10), int64(100), int64(0), int64(1000)},
+	{"hash2", "name2", int64(0), int64(200), int64(50), int64(2000)},
+	{"hash3", "name3", int64(30), int64(300), int64(30), int64(3000)},
+	{"hash4", "name4", int64(0), int64(400), int64(0), int64(4000)},
+}
+
+func TestDownloadsCollector_collectTopDownloads(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CollectorOpts
+		want map[string][]float64
+	}{
+		{
+			name: "all downloads",
+			opts: CollectorOpts{DownloadDetails: true},
+			want: map[string][]float64{
+				"hash1": {10, 100, 0, 1000},
+				"hash2": {0, 200, 50, 2000},
+				"hash3": {30, 300, 30, 3000},
+				"hash4": {0, 400, 0, 4000},
+			},
+		},
+		{
+			name: "by upload rate",
+			opts: CollectorOpts{DownloadDetails: true, TopDownloads: 2},
+			want: map[string][]float64{
+				"hash2": {0, 200, 50, 2000},
+				"hash3": {30, 300, 30, 3000},
+				"other": {10, 0, 0, 0},
+			},
+		},
+		{
+			name: "by download rate",
+			opts: CollectorOpts{DownloadDetails: true, TopDownloads: 2, TopDownloadsBy: TopByDownloadRate},
+			want: map[string][]float64{
+				"hash1": {10, 100, 0, 1000},
+				"hash3": {30, 300, 30, 3000},
+				"other": {0, 0, 50, 0},
+			},
+		},
+		{
+			name: "by activity with idle ties",
+			opts: CollectorOpts{DownloadDetails: true, TopDownloads: 3, TopDownloadsBy: TopByActivity},
+			want: map[string][]float64{
+				"hash1": {10, 100, 0, 1000},
+				"hash2": {0, 200, 50, 2000},
+				"hash3": {30, 300, 30, 3000},
+				"other": {0, 0, 0, 0},
+			},
+		},
+		{
+			name: "fewer downloads than top",
+			opts: CollectorOpts{DownloadDetails: true, TopDownloads: 10},
+			want: map[string][]float64{
+				"hash1": {10, 100, 0, 1000},
+				"hash2": {0, 200, 50, 2000},
+				"hash3": {30, 300, 30, 3000},
+				"hash4": {0, 400, 0, 4000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := new(MockDownloadsSource)
+			collector := NewDownloadsCollector(ds, tt.opts)
+			ds.On("DownloadWithDetails", collector.getDownloadDetailCommands()).Return(detailRows, nil)
+
+			ch := make(chan prometheus.Metric)
+			go func() {
+				defer close(ch)
+				desc, err := collector.collectDownloadDetails(ch, nil)
+				assert.Nil(t, desc)
+				assert.Nil(t, err)
+			}()
+
+			descs := []*prometheus.Desc{
+				collector.DownloadRateBytes, collector.DownloadBytesTotal, collector.UploadRateBytes, collector.UploadBytesTotal,
+			}
+			got := make(map[string][]float64)
+			for m := range ch {
+				if m.Desc() == collector.DownloadsActive {
+					assert.Equal(t, float64(len(detailRows)), metricValue(t, m))
+					continue
+				}
+				hash := metricLabel(t, m, "info_hash")
+				if hash == otherDownloads {
+					assert.Equal(t, otherDownloads, metricLabel(t, m, "name"))
+					// The byte totals of other aren't counters
+					assert.NotEqual(t, collector.DownloadBytesTotal, m.Desc())
+					assert.NotEqual(t, collector.UploadBytesTotal, m.Desc())
+				}
+				if got[hash] == nil {
+					got[hash] = make([]float64, len(descs))
+				}
+				for i, d := range descs {
+					if m.Desc() == d {
+						got[hash][i] = metricValue(t, m)
+					}
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDownloadsCollector_selectTopDownloadsHysteresis(t *testing.T) {
+	collector := NewDownloadsCollector(new(MockDownloadsSource), CollectorOpts{DownloadDetails: true, TopDownloads: 1})
+	up := collector.detailColumnIndex("d.up.rate=")
+
+	detail := func(hash string, rate float64) downloadDetail {
+		d := downloadDetail{
+			labels: []string{hash, hash},
+			values: make([]float64, len(collector.detailColumns)),
+			has:    make([]bool, len(collector.detailColumns)),
+		}
+		d.values[up], d.has[up] = rate, true
+		return d
+	}
+
+	scrapes := []struct {
+		a, b float64
+		want string
+	}{
+		{a: 100, b: 50, want: "a"},
+		// b doesn't rank half again as high as a
+		{a: 100, b: 140, want: "a"},
+		{a: 100, b: 160, want: "b"},
+		{a: 120, b: 160, want: "b"},
+		{a: 0, b: 0, want: "b"},
+	}
+	for i, s := range scrapes {
+		top, other := collector.selectTopDownloads([]downloadDetail{detail("a", s.a), detail("b", s.b)})
+		assert.Len(t, top, 1)
+		assert.Equal(t, s.want, top[0].labels[0], "scrape %d", i)
+		assert.NotNil(t, other)
+	}
+}
+
+func TestDownloadsCollector_collectTopDownloadsPeers(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	collector := NewDownloadsCollector(ds, CollectorOpts{DownloadDetails: true, DownloadPeers: true, TopDownloads: 1})
+
+	rows := make([][]any, 0, len(detailRows))
+	for _, r := range detailRows {
+		rows = append(rows, append(append([]any{}, r...), int64(1), int64(1), int64(1), int64(1)))
+	}
+	ds.On("DownloadWithDetails", collector.getDownloadDetailCommands()).Return(rows, nil)
+	// Only the trackers of the top download are retrieved
+	ds.On("TrackersWithDetails", []string{"hash2"}, trackerScrapeCommands).Return([][][]any{{{int64(3), int64(4)}}}, nil)
+
+	ch := make(chan prometheus.Metric, 64)
+	desc, err := collector.collectDownloadDetails(ch, nil)
+	close(ch)
+	assert.Nil(t, desc)
+	assert.Nil(t, err)
+	ds.AssertExpectations(t)
+}
+
+func TestValidTopDownloadsBy(t *testing.T) {
+	assert.True(t, ValidTopDownloadsBy(""))
+	assert.True(t, ValidTopDownloadsBy(TopByActivity))
+	assert.False(t, ValidTopDownloadsBy("ratio"))
+}
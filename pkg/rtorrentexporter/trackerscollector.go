@@ -0,0 +1,289 @@
+package rtorrentexporter
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/rtorrentrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ TrackersSource = &rtorrentrpc.DownloadService{}
+
+// A TrackersSource is a type which can retrieve tracker information for
+// rTorrent downloads. It is implemented by *rtorrentrpc.DownloadService.
+type TrackersSource interface {
+	All() ([]string, error)
+	TrackersWithDetails([]string, []string) ([][][]any, error)
+}
+
+var (
+	// trackerCommands are retrieved for every tracker of every download, the
+	// order must match the fields decoded in parseTracker.
+	trackerCommands = []string{
+		"t.url=",
+		"t.is_enabled=",
+		"t.failed_counter=",
+		"t.success_counter=",
+		"t.scrape_counter=",
+		"t.activity_time_last=",
+		"t.success_time_last=",
+	}
+)
+
+// A TrackersCollector is a Prometheus collector for metrics regarding the
+// trackers of rTorrent downloads. Metrics are aggregated by tracker hostname in
+// order to keep cardinality bounded.
+type TrackersCollector struct {
+	Torrents            *prometheus.Desc
+	Enabled             *prometheus.Desc
+	Disabled            *prometheus.Desc
+	AnnounceFailures    *prometheus.Desc
+	AnnounceSuccesses   *prometheus.Desc
+	Scrapes             *prometheus.Desc
+	LastAnnounceSeconds *prometheus.Desc
+	LastSuccessSeconds  *prometheus.Desc
+
+	ts TrackersSource
+
+	now func() time.Time
+}
+
+// trackerStats holds the aggregated statistics of a single tracker domain.
+type trackerStats struct {
+	torrents     int
+	enabled      int
+	disabled     int
+	failures     int64
+	successes    int64
+	scrapes      int64
+	lastAnnounce int64
+	lastSuccess  int64
+}
+
+// Verify that TrackersCollector implements the prometheus.Collector interface.
+var _ prometheus.Collector = &TrackersCollector{}
+
+// NewTrackersCollector creates a new TrackersCollector which collects metrics
+// regarding the trackers of rTorrent downloads.
+func NewTrackersCollector(ts TrackersSource) *TrackersCollector {
+	const (
+		subsystem = "trackers"
+	)
+
+	var (
+		labels = []string{"tracker"}
+	)
+
+	return &TrackersCollector{
+		Torrents: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "torrents"),
+			"Number of downloads using the tracker.",
+			labels,
+			nil,
+		),
+
+		Enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "enabled"),
+			"Number of enabled tracker entries across all downloads.",
+			labels,
+			nil,
+		),
+
+		Disabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "disabled"),
+			"Number of disabled tracker entries across all downloads.",
+			labels,
+			nil,
+		),
+
+		AnnounceFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "announce_failures"),
+			"Number of consecutive failed announces, summed across all downloads.",
+			labels,
+			nil,
+		),
+
+		AnnounceSuccesses: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "announce_successes"),
+			"Number of successful announces, summed across the current downloads, which drops as downloads are removed.",
+			labels,
+			nil,
+		),
+
+		Scrapes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "scrapes"),
+			"Number of scrapes, summed across the current downloads, which drops as downloads are removed.",
+			labels,
+			nil,
+		),
+
+		LastAnnounceSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "last_announce_age_seconds"),
+			"Seconds since the most recent announce to the tracker by any download.",
+			labels,
+			nil,
+		),
+
+		LastSuccessSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "last_success_age_seconds"),
+			"Seconds since the most recent successful announce to the tracker by any download.",
+			labels,
+			nil,
+		),
+
+		ts: ts,
+
+		now: time.Now,
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to rTorrent
+// trackers.
+func (c *TrackersCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	hashes, err := c.ts.All()
+	if err != nil {
+		return c.Torrents, err
+	}
+
+	trackers, err := c.ts.TrackersWithDetails(hashes, trackerCommands)
+	if err != nil {
+		return c.Torrents, err
+	}
+
+	stats := make(map[string]*trackerStats)
+	for _, tt := range trackers {
+		// A download may list several trackers on the same domain, it should
+		// only count once towards that domain's torrents
+		seen := make(map[string]bool)
+		for _, t := range tt {
+			if err := c.parseTracker(t, stats, seen); err != nil {
+				return c.Torrents, err
+			}
+		}
+	}
+
+	domains := make([]string, 0, len(stats))
+	for d := range stats {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	now := c.now().Unix()
+	for _, d := range domains {
+		s := stats[d]
+
+		ch <- prometheus.MustNewConstMetric(c.Torrents, prometheus.GaugeValue, float64(s.torrents), d)
+		ch <- prometheus.MustNewConstMetric(c.Enabled, prometheus.GaugeValue, float64(s.enabled), d)
+		ch <- prometheus.MustNewConstMetric(c.Disabled, prometheus.GaugeValue, float64(s.disabled), d)
+		ch <- prometheus.MustNewConstMetric(c.AnnounceFailures, prometheus.GaugeValue, float64(s.failures), d)
+		ch <- prometheus.MustNewConstMetric(c.AnnounceSuccesses, prometheus.GaugeValue, float64(s.successes), d)
+		ch <- prometheus.MustNewConstMetric(c.Scrapes, prometheus.GaugeValue, float64(s.scrapes), d)
+
+		// A zero time means that no download has announced to this tracker yet
+		if s.lastAnnounce > 0 {
+			ch <- prometheus.MustNewConstMetric(c.LastAnnounceSeconds, prometheus.GaugeValue, float64(now-s.lastAnnounce), d)
+		}
+		if s.lastSuccess > 0 {
+			ch <- prometheus.MustNewConstMetric(c.LastSuccessSeconds, prometheus.GaugeValue, float64(now-s.lastSuccess), d)
+		}
+	}
+
+	return nil, nil
+}
+
+// parseTracker adds a single tracker row, as retrieved with trackerCommands, to
+// the statistics of its domain.
+func (c *TrackersCollector) parseTracker(t []any, stats map[string]*trackerStats, seen map[string]bool) error {
+	if len(t) != len(trackerCommands) {
+		return fmt.Errorf("expected %d tracker values, got %d", len(trackerCommands), len(t))
+	}
+
+	rawURL, ok := t[0].(string)
+	if !ok {
+		return fmt.Errorf("failed to convert tracker URL to string")
+	}
+
+	ints := make([]int64, 0, len(t)-1)
+	for i, v := range t[1:] {
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("failed to convert %s to int", trackerCommands[i+1])
+		}
+		ints = append(ints, n)
+	}
+
+	domain := trackerDomain(rawURL)
+	s, ok := stats[domain]
+	if !ok {
+		s = &trackerStats{}
+		stats[domain] = s
+	}
+
+	if !seen[domain] {
+		seen[domain] = true
+		s.torrents++
+	}
+
+	if ints[0] != 0 {
+		s.enabled++
+	} else {
+		s.disabled++
+	}
+	s.failures += ints[1]
+	s.successes += ints[2]
+	s.scrapes += ints[3]
+	s.lastAnnounce = max(s.lastAnnounce, ints[4])
+	s.lastSuccess = max(s.lastSuccess, ints[5])
+
+	return nil
+}
+
+// trackerDomain returns the lower-cased hostname of a tracker URL. rTorrent's
+// DHT pseudo tracker (dht://) has no hostname and is reported by its scheme.
+func trackerDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+	if h := u.Hostname(); h != "" {
+		return strings.ToLower(h)
+	}
+	if u.Scheme != "" {
+		return strings.ToLower(u.Scheme)
+	}
+	return "unknown"
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *TrackersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.Torrents,
+		c.Enabled,
+		c.Disabled,
+		c.AnnounceFailures,
+		c.AnnounceSuccesses,
+		c.Scrapes,
+		c.LastAnnounceSeconds,
+		c.LastSuccessSeconds,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect sends the metric values for each metric pertaining to the rTorrent
+// trackers to the provided prometheus Metric channel.
+func (c *TrackersCollector) Collect(ch chan<- prometheus.Metric) {
+	if desc, err := c.collect(ch); err != nil {
+		log.Printf("[ERROR] failed collecting tracker metric %v: %v", desc, err)
+		ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+}
@@ -0,0 +1,82 @@
+package rtorrentexporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackersCollector_Collect(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	ds.On("All").Return([]string{"hash1", "hash2"}, nil)
+	ds.On("TrackersWithDetails", []string{"hash1", "hash2"}, trackerCommands).Return([][][]any{
+		{
+			{"https://Tracker.example.org/announce/abc", int64(1), int64(0), int64(12), int64(3), int64(990), int64(990)},
+			{"udp://tracker.example.org:6969/announce", int64(0), int64(0), int64(0), int64(0), int64(0), int64(0)},
+			{"dht://", int64(1), int64(0), int64(5), int64(0), int64(995), int64(995)},
+		},
+		{
+			{"https://tracker.example.org/announce/def", int64(1), int64(4), int64(2), int64(1), int64(980), int64(900)},
+		},
+	}, nil)
+
+	collector := NewTrackersCollector(ds)
+	collector.now = func() time.Time { return time.Unix(1000, 0) }
+
+	expected := `
+# HELP rtorrent_trackers_announce_failures Number of consecutive failed announces, summed across all downloads.
+# TYPE rtorrent_trackers_announce_failures gauge
+rtorrent_trackers_announce_failures{tracker="dht"} 0
+rtorrent_trackers_announce_failures{tracker="tracker.example.org"} 4
+# HELP rtorrent_trackers_announce_successes Number of successful announces, summed across the current downloads, which drops as downloads are removed.
+# TYPE rtorrent_trackers_announce_successes gauge
+rtorrent_trackers_announce_successes{tracker="dht"} 5
+rtorrent_trackers_announce_successes{tracker="tracker.example.org"} 14
+# HELP rtorrent_trackers_disabled Number of disabled tracker entries across all downloads.
+# TYPE rtorrent_trackers_disabled gauge
+rtorrent_trackers_disabled{tracker="dht"} 0
+rtorrent_trackers_disabled{tracker="tracker.example.org"} 1
+# HELP rtorrent_trackers_enabled Number of enabled tracker entries across all downloads.
+# TYPE rtorrent_trackers_enabled gauge
+rtorrent_trackers_enabled{tracker="dht"} 1
+rtorrent_trackers_enabled{tracker="tracker.example.org"} 2
+# HELP rtorrent_trackers_last_announce_age_seconds Seconds since the most recent announce to the tracker by any download.
+# TYPE rtorrent_trackers_last_announce_age_seconds gauge
+rtorrent_trackers_last_announce_age_seconds{tracker="dht"} 5
+rtorrent_trackers_last_announce_age_seconds{tracker="tracker.example.org"} 10
+# HELP rtorrent_trackers_last_success_age_seconds Seconds since the most recent successful announce to the tracker by any download.
+# TYPE rtorrent_trackers_last_success_age_seconds gauge
+rtorrent_trackers_last_success_age_seconds{tracker="dht"} 5
+rtorrent_trackers_last_success_age_seconds{tracker="tracker.example.org"} 10
+# HELP rtorrent_trackers_scrapes Number of scrapes, summed across the current downloads, which drops as downloads are removed.
+# TYPE rtorrent_trackers_scrapes gauge
+rtorrent_trackers_scrapes{tracker="dht"} 0
+rtorrent_trackers_scrapes{tracker="tracker.example.org"} 4
+# HELP rtorrent_trackers_torrents Number of downloads using the tracker.
+# TYPE rtorrent_trackers_torrents gauge
+rtorrent_trackers_torrents{tracker="dht"} 1
+rtorrent_trackers_torrents{tracker="tracker.example.org"} 2
+`
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestTrackersCollector_collectError(t *testing.T) {
+	ds := new(MockDownloadsSource)
+	ds.On("All").Return([]string(nil), errors.New("connection refused"))
+
+	collector := NewTrackersCollector(ds)
+	desc, err := collector.collect(nil)
+	assert.Equal(t, collector.Torrents, desc)
+	assert.NotNil(t, err)
+}
+
+func TestTrackerDomain(t *testing.T) {
+	assert.Equal(t, "tracker.example.org", trackerDomain("https://Tracker.Example.org:443/announce?passkey=abc"))
+	assert.Equal(t, "10.0.0.1", trackerDomain("udp://10.0.0.1:6969"))
+	assert.Equal(t, "dht", trackerDomain("dht://"))
+	assert.Equal(t, "unknown", trackerDomain("::"))
+}
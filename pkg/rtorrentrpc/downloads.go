@@ -0,0 +1,127 @@
+package rtorrentrpc
+
+import (
+	"fmt"
+
+	"github.com/aauren/rtorrent/rtorrent"
+)
+
+const (
+	// viewSize retrieves the number of visible downloads in a view.
+	viewSize = "view.size"
+
+	// trackerMultiCall retrieves details for each tracker of a download.
+	trackerMultiCall = "t.multicall"
+
+	// downloadMultiCall retrieves details for each download of a view.
+	downloadMultiCall = "d.multicall2"
+
+	// defaultView is the view used by download_list when no view is given, it
+	// contains every download.
+	defaultView = "default"
+
+	// mainView contains every download, whether started, stopped or idle.
+	mainView = "main"
+)
+
+// A DownloadService is a wrapper for Client methods which operate on downloads.
+// It provides all of the methods of rtorrent.DownloadService along with
+// additional batched methods.
+type DownloadService struct {
+	*rtorrent.DownloadService
+
+	c *Client
+}
+
+// AllWithDetails retrieves every download along with additional details as
+// specified by the commands slice, one row of command results per download.
+// Unlike DownloadWithDetails, which is limited to the active view, stopped and
+// idle downloads are included.
+func (s *DownloadService) AllWithDetails(commands []string) ([][]any, error) {
+	params := make([]any, 0, len(commands)+2)
+	params = append(params, "", mainView)
+	for _, cmd := range commands {
+		params = append(params, cmd)
+	}
+
+	var rows [][]any
+	if err := s.c.xrc.Call(downloadMultiCall, params, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// ViewSizes retrieves the number of downloads in each of the provided views
+// using a single system.multicall round trip. The returned sizes are in the same
+// order as views. An empty view name refers to the default view containing all
+// downloads, the same as download_list.
+func (s *DownloadService) ViewSizes(views []string) ([]int, error) {
+	calls := make([]call, 0, len(views))
+	for _, v := range views {
+		if v == "" {
+			v = defaultView
+		}
+		calls = append(calls, call{method: viewSize, params: []any{"", v}})
+	}
+
+	results, err := s.c.multicall(calls)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]int, 0, len(results))
+	for i, r := range results {
+		size, ok := r.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s result type %T for view %q", viewSize, r, views[i])
+		}
+		sizes = append(sizes, int(size))
+	}
+
+	return sizes, nil
+}
+
+// TrackersWithDetails retrieves the trackers of each of the provided downloads
+// along with additional details as specified by the commands slice, using a
+// single system.multicall round trip. The outer slice is in the same order as
+// infoHashes and each inner slice holds one row of command results per tracker.
+func (s *DownloadService) TrackersWithDetails(infoHashes []string, commands []string) ([][][]any, error) {
+	if len(infoHashes) == 0 {
+		return nil, nil
+	}
+
+	calls := make([]call, 0, len(infoHashes))
+	for _, h := range infoHashes {
+		params := make([]any, 0, len(commands)+2)
+		params = append(params, h, "")
+		for _, cmd := range commands {
+			params = append(params, cmd)
+		}
+		calls = append(calls, call{method: trackerMultiCall, params: params})
+	}
+
+	results, err := s.c.multicall(calls)
+	if err != nil {
+		return nil, err
+	}
+
+	trackers := make([][][]any, 0, len(results))
+	for i, r := range results {
+		rows, ok := r.([]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s result type %T for download %q", trackerMultiCall, r, infoHashes[i])
+		}
+
+		tt := make([][]any, 0, len(rows))
+		for _, row := range rows {
+			cols, ok := row.([]any)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %s row type %T for download %q", trackerMultiCall, row, infoHashes[i])
+			}
+			tt = append(tt, cols)
+		}
+		trackers = append(trackers, tt)
+	}
+
+	return trackers, nil
+}
@@ -0,0 +1,90 @@
+package rtorrentrpc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadServiceViewSizes(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><array><data><value><i8>3</i8></value></data></array></value>
+<value><array><data><value><i8>1</i8></value></data></array></value>
+</data></array></value>`)
+	defer done()
+
+	sizes, err := c.Downloads.ViewSizes([]string{"", "seeding"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 1}, sizes)
+}
+
+func TestDownloadServiceViewSizesFault(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><array><data><value><i8>3</i8></value></data></array></value>
+<value><struct>
+<member><name>faultCode</name><value><i4>-503</i4></value></member>
+<member><name>faultString</name><value><string>Could not find view: bogus</string></value></member>
+</struct></value>
+</data></array></value>`)
+	defer done()
+
+	_, err := c.Downloads.ViewSizes([]string{"", "bogus"})
+	assert.ErrorContains(t, err, "Could not find view: bogus")
+}
+
+func TestDownloadServiceViewSizesLengthMismatch(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><array><data><value><i8>3</i8></value></data></array></value>
+</data></array></value>`)
+	defer done()
+
+	_, err := c.Downloads.ViewSizes([]string{"", "seeding"})
+	assert.NotNil(t, err)
+}
+
+func TestDownloadServiceTrackersWithDetails(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><array><data><value><array><data>
+<value><array><data><value><i8>10</i8></value><value><i8>2</i8></value></data></array></value>
+<value><array><data><value><i8>4</i8></value><value><i8>1</i8></value></data></array></value>
+</data></array></value></data></array></value>
+<value><array><data><value><array><data></data></array></value></data></array></value>
+</data></array></value>`)
+	defer done()
+
+	trackers, err := c.Downloads.TrackersWithDetails([]string{"hash1", "hash2"}, []string{"t.scrape_complete=", "t.scrape_incomplete="})
+	assert.Nil(t, err)
+	assert.Equal(t, [][][]any{
+		{{int64(10), int64(2)}, {int64(4), int64(1)}},
+		{},
+	}, trackers)
+}
+
+func TestDownloadServiceAllWithDetails(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		// The main view holds every download, the active one only those transferring data
+		assert.Contains(t, string(body), "<methodName>d.multicall2</methodName>")
+		assert.Contains(t, string(body), "<string></string></value></param><param><value><string>main</string>")
+		assert.Contains(t, string(body), "<string>d.hash=</string>")
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><methodResponse><params><param><value><array><data>
+<value><array><data><value><string>hash1</string></value><value><i8>1</i8></value></data></array></value>
+<value><array><data><value><string>hash2</string></value><value><i8>0</i8></value></data></array></value>
+</data></array></value></param></params></methodResponse>`)
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL, nil)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, c.Close()) }()
+
+	rows, err := c.Downloads.AllWithDetails([]string{"d.hash=", "d.state="})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]any{{"hash1", int64(1)}, {"hash2", int64(0)}}, rows)
+}
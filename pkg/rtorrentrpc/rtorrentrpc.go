@@ -0,0 +1,175 @@
+// Package rtorrentrpc provides an rTorrent XML-RPC client which extends
+// github.com/aauren/rtorrent/rtorrent with the additional methods needed by the
+// rtorrent_exporter collectors.
+package rtorrentrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aauren/rtorrent/rtorrent"
+	"github.com/kolo/xmlrpc"
+)
+
+// A Client is an rTorrent client. It exposes the services provided by
+// rtorrent.Client along with additional services for data that library doesn't
+// retrieve.
+type Client struct {
+	Downloads *DownloadService
+	Throttle  *ThrottleService
+	System    *SystemService
+
+	rc        *rtorrent.Client
+	xrc       *xmlrpc.Client
+	transport http.RoundTripper
+	ct        *contextTransport
+}
+
+// New creates a new Client using the input XML-RPC address and an optional
+// transport. If transport is nil, a default one will be used.
+func New(addr string, transport http.RoundTripper) (*Client, error) {
+	ct := &contextTransport{next: transport}
+	if ct.next == nil {
+		ct.next = http.DefaultTransport
+	}
+
+	rc, err := rtorrent.New(addr, ct)
+	if err != nil {
+		return nil, err
+	}
+
+	xrc, err := xmlrpc.NewClient(addr, ct)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		rc:        rc,
+		xrc:       xrc,
+		transport: transport,
+		ct:        ct,
+	}
+
+	c.Downloads = &DownloadService{DownloadService: rc.Downloads, c: c}
+	c.Throttle = &ThrottleService{c: c}
+	c.System = &SystemService{c: c}
+
+	return c, nil
+}
+
+// Transport returns the http.RoundTripper the Client sends its calls with.
+func (c *Client) Transport() http.RoundTripper {
+	return c.transport
+}
+
+// SetContext sets the context the Client's calls are sent with until it is set
+// again, nil restores the background context. Its deadline bounds the retries
+// of the calls, and its cancellation aborts them.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ct.mu.Lock()
+	defer c.ct.mu.Unlock()
+
+	c.ct.ctx = ctx
+}
+
+// A contextTransport sends the calls of a Client with the context set with
+// SetContext, as the XML-RPC client doesn't take one.
+type contextTransport struct {
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// RoundTrip sends the call with the context of the Client, if set.
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	ctx := t.ctx
+	t.mu.Unlock()
+
+	if ctx != nil {
+		r = r.WithContext(ctx)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// Close frees a Client's resources, including any idle connections held by its
+// transport. Calls still in flight must have finished first, as they fail or
+// never return once the Client is closed.
+func (c *Client) Close() error {
+	if err := c.rc.Close(); err != nil {
+		return err
+	}
+	if err := c.xrc.Close(); err != nil {
+		return err
+	}
+	if ic, ok := c.transport.(interface{ CloseIdleConnections() }); ok {
+		ic.CloseIdleConnections()
+	}
+	return nil
+}
+
+// getInt retrieves an integer value from the specified XML-RPC method.
+func (c *Client) getInt(method string) (int, error) {
+	var v int
+	err := c.xrc.Call(method, nil, &v)
+	return v, err
+}
+
+// getString retrieves a string value from the specified XML-RPC method.
+func (c *Client) getString(method string) (string, error) {
+	var v string
+	err := c.xrc.Call(method, nil, &v)
+	return v, err
+}
+
+// A call is a single XML-RPC method call which is batched with others by
+// multicall.
+type call struct {
+	method string
+	params []any
+}
+
+// multicall performs all of the provided calls in a single system.multicall
+// request and returns the result of each call in the same order. If any of the
+// calls results in a fault, an error is returned.
+func (c *Client) multicall(calls []call) ([]any, error) {
+	batch := make([]any, 0, len(calls))
+	for _, cl := range calls {
+		batch = append(batch, map[string]any{
+			"methodName": cl.method,
+			"params":     cl.params,
+		})
+	}
+
+	var v []any
+	if err := c.xrc.Call("system.multicall", []any{batch}, &v); err != nil {
+		return nil, err
+	}
+
+	if len(v) != len(calls) {
+		return nil, fmt.Errorf("system.multicall returned %d results for %d calls", len(v), len(calls))
+	}
+
+	// Each successful result is wrapped in a single element array, while
+	// failed calls are represented by a fault struct
+	results := make([]any, 0, len(v))
+	for i, r := range v {
+		switch r := r.(type) {
+		case []any:
+			if len(r) != 1 {
+				return nil, fmt.Errorf("system.multicall returned %d values for %s", len(r), calls[i].method)
+			}
+			results = append(results, r[0])
+		case map[string]any:
+			return nil, fmt.Errorf("%s failed in system.multicall: Fault(%v): %v", calls[i].method, r["faultCode"], r["faultString"])
+		default:
+			return nil, fmt.Errorf("system.multicall returned unexpected type %T for %s", r, calls[i].method)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,77 @@
+package rtorrentrpc
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient starts an XML-RPC server which asserts that the expected method is
+// called and replies with the provided XML encoded value.
+func testClient(t *testing.T, method string, value string) (*Client, func()) {
+	t.Helper()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var xr struct {
+			MethodName string `xml:"methodName"`
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, xml.Unmarshal(body, &xr))
+		assert.Equal(t, method, xr.MethodName)
+
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><methodResponse><params><param>%s</param></params></methodResponse>`, value)
+	}))
+
+	c, err := New(s.URL, nil)
+	assert.Nil(t, err)
+
+	return c, func() {
+		assert.Nil(t, c.Close())
+		s.Close()
+	}
+}
+
+// A roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClientSetContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><methodResponse><params><param>`+
+			`<value><string>0.9.8</string></value></param></params></methodResponse>`)
+	}))
+	defer s.Close()
+
+	var deadline time.Time
+	c, err := New(s.URL, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		deadline, _ = r.Context().Deadline()
+		return http.DefaultTransport.RoundTrip(r)
+	}))
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	c.SetContext(ctx)
+	_, err = c.System.ClientVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, want, deadline)
+
+	c.SetContext(nil)
+	_, err = c.System.ClientVersion()
+	assert.Nil(t, err)
+	assert.True(t, deadline.IsZero())
+}
@@ -0,0 +1,18 @@
+package rtorrentrpc
+
+// A SystemService is a wrapper for Client methods which retrieve information
+// about the rTorrent process itself.
+type SystemService struct {
+	c *Client
+}
+
+// ClientVersion retrieves the version of rTorrent.
+func (s *SystemService) ClientVersion() (string, error) {
+	return s.c.getString("system.client_version")
+}
+
+// StartupTime retrieves the time rTorrent started at, in seconds since the
+// Unix epoch.
+func (s *SystemService) StartupTime() (int, error) {
+	return s.c.getInt("system.startup_time")
+}
@@ -0,0 +1,25 @@
+package rtorrentrpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemServiceClientVersion(t *testing.T) {
+	c, done := testClient(t, "system.client_version", "<value><string>0.9.8</string></value>")
+	defer done()
+
+	v, err := c.System.ClientVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "0.9.8", v)
+}
+
+func TestSystemServiceStartupTime(t *testing.T) {
+	c, done := testClient(t, "system.startup_time", "<value><i8>1700000000</i8></value>")
+	defer done()
+
+	v, err := c.System.StartupTime()
+	assert.Nil(t, err)
+	assert.Equal(t, 1700000000, v)
+}
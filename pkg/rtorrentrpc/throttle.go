@@ -0,0 +1,91 @@
+package rtorrentrpc
+
+import "fmt"
+
+// A ThrottleService is a wrapper for Client methods which retrieve client-wide
+// transfer rates, totals and throttle settings.
+type ThrottleService struct {
+	c *Client
+}
+
+// Globals are the client-wide transfer rates, totals and throttle settings, in
+// bytes. A max rate of 0 means that the rate is unlimited.
+type Globals struct {
+	DownloadRate    int
+	UploadRate      int
+	DownloadTotal   int
+	UploadTotal     int
+	DownloadMaxRate int
+	UploadMaxRate   int
+}
+
+// Globals retrieves the client-wide transfer rates, totals and throttle
+// settings using a single system.multicall round trip.
+func (s *ThrottleService) Globals() (Globals, error) {
+	var g Globals
+	fields := []struct {
+		method string
+		v      *int
+	}{
+		{"throttle.global_down.rate", &g.DownloadRate},
+		{"throttle.global_up.rate", &g.UploadRate},
+		{"throttle.global_down.total", &g.DownloadTotal},
+		{"throttle.global_up.total", &g.UploadTotal},
+		{"throttle.global_down.max_rate", &g.DownloadMaxRate},
+		{"throttle.global_up.max_rate", &g.UploadMaxRate},
+	}
+
+	calls := make([]call, 0, len(fields))
+	for _, f := range fields {
+		calls = append(calls, call{method: f.method, params: []any{""}})
+	}
+
+	results, err := s.c.multicall(calls)
+	if err != nil {
+		return Globals{}, err
+	}
+
+	for i, r := range results {
+		v, ok := r.(int64)
+		if !ok {
+			return Globals{}, fmt.Errorf("unexpected %s result type %T", fields[i].method, r)
+		}
+		*fields[i].v = int(v)
+	}
+
+	return g, nil
+}
+
+// GlobalDownloadRate retrieves the current client-wide download rate in bytes.
+func (s *ThrottleService) GlobalDownloadRate() (int, error) {
+	return s.c.getInt("throttle.global_down.rate")
+}
+
+// GlobalUploadRate retrieves the current client-wide upload rate in bytes.
+func (s *ThrottleService) GlobalUploadRate() (int, error) {
+	return s.c.getInt("throttle.global_up.rate")
+}
+
+// GlobalDownloadTotal retrieves the total number of bytes downloaded since
+// rTorrent startup.
+func (s *ThrottleService) GlobalDownloadTotal() (int, error) {
+	return s.c.getInt("throttle.global_down.total")
+}
+
+// GlobalUploadTotal retrieves the total number of bytes uploaded since rTorrent
+// startup.
+func (s *ThrottleService) GlobalUploadTotal() (int, error) {
+	return s.c.getInt("throttle.global_up.total")
+}
+
+// GlobalDownloadMaxRate retrieves the configured client-wide download rate
+// limit in bytes. A value of 0 means that the rate is unlimited.
+func (s *ThrottleService) GlobalDownloadMaxRate() (int, error) {
+	return s.c.getInt("throttle.global_down.max_rate")
+}
+
+// GlobalUploadMaxRate retrieves the configured client-wide upload rate limit in
+// bytes. A value of 0 means that the rate is unlimited.
+func (s *ThrottleService) GlobalUploadMaxRate() (int, error) {
+	return s.c.getInt("throttle.global_up.max_rate")
+}
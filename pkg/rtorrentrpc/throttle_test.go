@@ -0,0 +1,100 @@
+package rtorrentrpc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleService(t *testing.T) {
+	tests := []struct {
+		method string
+		call   func(s *ThrottleService) (int, error)
+	}{
+		{"throttle.global_down.rate", (*ThrottleService).GlobalDownloadRate},
+		{"throttle.global_up.rate", (*ThrottleService).GlobalUploadRate},
+		{"throttle.global_down.total", (*ThrottleService).GlobalDownloadTotal},
+		{"throttle.global_up.total", (*ThrottleService).GlobalUploadTotal},
+		{"throttle.global_down.max_rate", (*ThrottleService).GlobalDownloadMaxRate},
+		{"throttle.global_up.max_rate", (*ThrottleService).GlobalUploadMaxRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			c, done := testClient(t, tt.method, "<value><i8>1048576</i8></value>")
+			defer done()
+
+			v, err := tt.call(c.Throttle)
+			assert.Nil(t, err)
+			assert.Equal(t, 1048576, v)
+		})
+	}
+}
+
+func TestThrottleServiceGlobals(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><array><data><value><i8>100</i8></value></data></array></value>
+<value><array><data><value><i8>200</i8></value></data></array></value>
+<value><array><data><value><i8>3000</i8></value></data></array></value>
+<value><array><data><value><i8>4000</i8></value></data></array></value>
+<value><array><data><value><i8>0</i8></value></data></array></value>
+<value><array><data><value><i8>5000</i8></value></data></array></value>
+</data></array></value>`)
+	defer done()
+
+	g, err := c.Throttle.Globals()
+	assert.Nil(t, err)
+	assert.Equal(t, Globals{
+		DownloadRate:    100,
+		UploadRate:      200,
+		DownloadTotal:   3000,
+		UploadTotal:     4000,
+		DownloadMaxRate: 0,
+		UploadMaxRate:   5000,
+	}, g)
+}
+
+func TestThrottleServiceGlobalsSingleRoundTrip(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		for _, method := range []string{
+			"throttle.global_down.rate", "throttle.global_up.rate", "throttle.global_down.total",
+			"throttle.global_up.total", "throttle.global_down.max_rate", "throttle.global_up.max_rate",
+		} {
+			assert.Contains(t, string(body), "<string>"+method+"</string>")
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><array><data>`+
+			strings.Repeat(`<value><array><data><value><i8>1</i8></value></data></array></value>`, 6)+
+			`</data></array></value></param></params></methodResponse>`)
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL, nil)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, c.Close()) }()
+
+	_, err = c.Throttle.Globals()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestThrottleServiceGlobalsFault(t *testing.T) {
+	c, done := testClient(t, "system.multicall", `<value><array><data>
+<value><struct>
+<member><name>faultCode</name><value><i4>-506</i4></value></member>
+<member><name>faultString</name><value><string>Method 'throttle.global_down.rate' not defined</string></value></member>
+</struct></value>
+</data></array></value>`)
+	defer done()
+
+	_, err := c.Throttle.Globals()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,231 @@
+// Package scgi provides an http.RoundTripper which speaks the SCGI protocol,
+// allowing XML-RPC requests to be sent directly to rTorrent's
+// network.scgi.open_port or network.scgi.open_local sockets without an HTTP
+// front-end.
+package scgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Scheme is the URL scheme used to address an SCGI server over TCP,
+	// e.g. scgi://127.0.0.1:5000
+	Scheme = "scgi"
+
+	// UnixScheme is the URL scheme used to address an SCGI server over a unix
+	// domain socket, e.g. scgi+unix:///home/user/.rtorrent/rpc.socket
+	UnixScheme = "scgi+unix"
+
+	// defaultRequestURI is sent when the request URL doesn't carry a useful path,
+	// rTorrent ignores it but some SCGI servers require it to be present.
+	defaultRequestURI = "/RPC2"
+)
+
+// IsSCGI reports whether the provided address uses one of the SCGI URL schemes.
+func IsSCGI(addr string) bool {
+	return strings.HasPrefix(addr, Scheme+"://") || strings.HasPrefix(addr, UnixScheme+"://")
+}
+
+// Verify that the Transport implements the http.RoundTripper interface.
+var _ http.RoundTripper = &Transport{}
+
+// A Transport is a http.RoundTripper which frames each HTTP request as an SCGI
+// request and parses the CGI style response sent back by the server.
+//
+// The SCGI protocol allows exactly one request per connection and servers like
+// rTorrent close the connection once the response has been written, so a new
+// connection is dialed for each request.
+type Transport struct {
+	// Timeout bounds the whole exchange with the SCGI server, including
+	// dialing, writing the request and reading the response. A zero value
+	// means no timeout, although deadlines on the request context are still
+	// honored.
+	Timeout time.Duration
+
+	// Dialer is used to establish connections, if nil a zero net.Dialer is
+	// used.
+	Dialer *net.Dialer
+}
+
+// RoundTrip sends the request to the SCGI server addressed by its URL and
+// returns the server's response.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+
+	network, addr, uri, err := target(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	d := t.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Close the connection if the context is cancelled part way through the
+	// exchange so that blocked reads and writes return
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	if _, err := conn.Write(encodeRequest(r, uri, body)); err != nil {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("failed to write SCGI request: %w", err)
+	}
+
+	resp, err := readResponse(bufio.NewReader(conn), r)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	// The body is fully buffered so that the deadline and the connection can be
+	// released before handing the response back to the caller
+	respBody, err := io.ReadAll(resp.Body)
+	stop()
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SCGI response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+
+	return resp, nil
+}
+
+// target determines the network, address and request URI for the request
+// based on its URL scheme.
+func target(r *http.Request) (network, addr, uri string, err error) {
+	if r.URL == nil {
+		return "", "", "", fmt.Errorf("scgi: nil request URL")
+	}
+
+	switch r.URL.Scheme {
+	case Scheme:
+		if r.URL.Host == "" {
+			return "", "", "", fmt.Errorf("scgi: missing host in %q", r.URL)
+		}
+		uri = r.URL.RequestURI()
+		if r.URL.Path == "" {
+			uri = defaultRequestURI
+		}
+		return "tcp", r.URL.Host, uri, nil
+	case UnixScheme:
+		if r.URL.Path == "" {
+			return "", "", "", fmt.Errorf("scgi: missing socket path in %q", r.URL)
+		}
+		return "unix", r.URL.Path, defaultRequestURI, nil
+	default:
+		return "", "", "", fmt.Errorf("scgi: unsupported URL scheme %q", r.URL.Scheme)
+	}
+}
+
+// encodeRequest frames the request as an SCGI netstring of headers followed by
+// the request body.
+func encodeRequest(r *http.Request, uri string, body []byte) []byte {
+	var h bytes.Buffer
+	writeHeader := func(k, v string) {
+		h.WriteString(k)
+		h.WriteByte(0)
+		h.WriteString(v)
+		h.WriteByte(0)
+	}
+
+	// CONTENT_LENGTH must always be the first header, followed by SCGI
+	writeHeader("CONTENT_LENGTH", strconv.Itoa(len(body)))
+	writeHeader("SCGI", "1")
+	writeHeader("REQUEST_METHOD", r.Method)
+	writeHeader("REQUEST_URI", uri)
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		writeHeader("CONTENT_TYPE", ct)
+	}
+
+	var b bytes.Buffer
+	b.Grow(h.Len() + len(body) + 16)
+	b.WriteString(strconv.Itoa(h.Len()))
+	b.WriteByte(':')
+	b.Write(h.Bytes())
+	b.WriteByte(',')
+	b.Write(body)
+
+	return b.Bytes()
+}
+
+// readResponse parses the CGI style response returned by the SCGI server. The
+// Status header is optional and defaults to 200 OK as per the CGI specification.
+func readResponse(br *bufio.Reader, r *http.Request) (*http.Response, error) {
+	mh, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SCGI response headers: %w", err)
+	}
+
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		Header:        http.Header(mh),
+		Body:          io.NopCloser(br),
+		ContentLength: -1,
+		Request:       r,
+	}
+
+	if status := mh.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+		resp.StatusCode, err = strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("malformed SCGI response status %q", status)
+		}
+		resp.Status = status
+		resp.Header.Del("Status")
+	}
+
+	if cl := mh.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("malformed SCGI response content length %q", cl)
+		}
+		resp.ContentLength = n
+		resp.Body = io.NopCloser(io.LimitReader(br, n))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,170 @@
+package scgi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSCGI accepts a single connection on l, decodes the SCGI request and
+// replies with the provided response.
+func serveSCGI(t *testing.T, l net.Listener, resp string) <-chan map[string]string {
+	t.Helper()
+	got := make(chan map[string]string, 1)
+
+	go func() {
+		defer close(got)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		lenStr, err := br.ReadString(':')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSuffix(lenStr, ":"))
+		raw := make([]byte, n+1)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return
+		}
+
+		headers := make(map[string]string)
+		parts := bytes.Split(raw[:n], []byte{0})
+		for i := 0; i+1 < len(parts); i += 2 {
+			headers[string(parts[i])] = string(parts[i+1])
+		}
+		cl, _ := strconv.Atoi(headers["CONTENT_LENGTH"])
+		body := make([]byte, cl)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return
+		}
+		headers["body"] = string(body)
+
+		_, _ = conn.Write([]byte(resp))
+		got <- headers
+	}()
+
+	return got
+}
+
+func TestTransport_RoundTripTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	const xml = "<methodResponse/>"
+	got := serveSCGI(t, l, fmt.Sprintf("Status: 200 OK\r\nContent-Type: text/xml\r\nContent-Length: %d\r\n\r\n%s", len(xml), xml))
+
+	req, err := http.NewRequest(http.MethodPost, "scgi://"+l.Addr().String(), strings.NewReader("<methodCall/>"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "text/xml")
+
+	tr := &Transport{Timeout: time.Second}
+	resp, err := tr.RoundTrip(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/xml", resp.Header.Get("Content-Type"))
+	assert.Equal(t, xml, string(body))
+
+	headers := <-got
+	assert.Equal(t, "1", headers["SCGI"])
+	assert.Equal(t, "POST", headers["REQUEST_METHOD"])
+	assert.Equal(t, "/RPC2", headers["REQUEST_URI"])
+	assert.Equal(t, "text/xml", headers["CONTENT_TYPE"])
+	assert.Equal(t, "<methodCall/>", headers["body"])
+}
+
+func TestTransport_RoundTripUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "rpc.socket")
+	l, err := net.Listen("unix", sock)
+	assert.Nil(t, err)
+	defer l.Close()
+
+	// No Status or Content-Length, the body runs until the connection is closed
+	got := serveSCGI(t, l, "Content-Type: text/xml\r\n\r\n<methodResponse/>")
+
+	req, err := http.NewRequest(http.MethodPost, "scgi+unix://"+sock, strings.NewReader("<methodCall/>"))
+	assert.Nil(t, err)
+
+	tr := &Transport{Timeout: time.Second}
+	resp, err := tr.RoundTrip(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "<methodResponse/>", string(body))
+	assert.Equal(t, "<methodCall/>", (<-got)["body"])
+}
+
+func TestTransport_RoundTripStatus(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	serveSCGI(t, l, "Status: 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+
+	req, err := http.NewRequest(http.MethodPost, "scgi://"+l.Addr().String()+"/RPC2", strings.NewReader(""))
+	assert.Nil(t, err)
+
+	resp, err := (&Transport{Timeout: time.Second}).RoundTrip(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404 Not Found", resp.Status)
+}
+
+func TestTransport_RoundTripTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	// Accept the connection but never answer
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, "scgi://"+l.Addr().String(), strings.NewReader(""))
+	assert.Nil(t, err)
+
+	start := time.Now()
+	_, err = (&Transport{Timeout: 50 * time.Millisecond}).RoundTrip(req)
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestTransport_RoundTripUnsupportedScheme(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/RPC2", nil)
+	assert.Nil(t, err)
+
+	_, err = (&Transport{}).RoundTrip(req)
+	assert.NotNil(t, err)
+}
+
+func TestIsSCGI(t *testing.T) {
+	assert.True(t, IsSCGI("scgi://127.0.0.1:5000"))
+	assert.True(t, IsSCGI("scgi+unix:///run/rtorrent/rpc.socket"))
+	assert.False(t, IsSCGI("https://127.0.0.1/RPC2"))
+}
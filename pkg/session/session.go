@@ -0,0 +1,769 @@
+// Package session provides a source of rTorrent download information which
+// reads rTorrent's session directory from disk, so that downloads can still be
+// reported when XML-RPC is disabled or rTorrent doesn't answer.
+//
+// For every download rTorrent keeps its metainfo in <hash>.torrent, its state
+// in <hash>.torrent.rtorrent and its progress in
+// <hash>.torrent.libtorrent_resume, all of them bencoded. The session files are
+// only saved periodically, every 20 minutes by default, and transfer rates and
+// peers aren't saved at all, so the values read from disk lag behind those
+// rTorrent reports.
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/bencode"
+)
+
+const (
+	// lockFile is created in the session directory by a running rTorrent.
+	lockFile = "rtorrent.lock"
+
+	// Suffixes of the session files of a download, after its info hash.
+	torrentSuffix  = ".torrent"
+	rtorrentSuffix = ".torrent.rtorrent"
+	resumeSuffix   = ".torrent.libtorrent_resume"
+)
+
+// errDownloadDetails is returned by DownloadWithDetails.
+var errDownloadDetails = errors.New("download details aren't available from a session directory, as no download is ever active")
+
+// torrentFileRE matches the names of the metainfo files of the session
+// directory, named after the info hash of their download.
+var torrentFileRE = regexp.MustCompile(`^[0-9A-Fa-f]{40}\.torrent$`)
+
+// A Source reads download information from an rTorrent session directory. Its
+// methods mirror those of rtorrentrpc.DownloadService, for the d.* and t.*
+// commands whose values are saved in the session files.
+type Source struct {
+	dir string
+
+	mu sync.Mutex
+	// files caches the decoded session files, which are only decoded again
+	// once they changed.
+	files map[string]cachedFile
+}
+
+// A cachedFile is a decoded session file along with the modification time and
+// size it had when it was decoded.
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	value   any
+}
+
+// New creates a Source which reads the session directory at dir.
+func New(dir string) *Source {
+	return &Source{
+		dir:   dir,
+		files: make(map[string]cachedFile),
+	}
+}
+
+// ClientVersion reports the session directory in place of the version of
+// rTorrent. It fails when the directory can't be read, so that the session
+// directory is reported as down the same way rTorrent would be.
+func (s *Source) ClientVersion() (string, error) {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("session directory %q is not a directory", s.dir)
+	}
+	return "session:" + s.dir, nil
+}
+
+// StartupTime retrieves the time rTorrent started at, in seconds since the
+// Unix epoch, from the lock file it creates in the session directory.
+func (s *Source) StartupTime() (int, error) {
+	fi, err := os.Stat(filepath.Join(s.dir, lockFile))
+	if err != nil {
+		return 0, fmt.Errorf("cannot tell when rTorrent started: %w", err)
+	}
+	return int(fi.ModTime().Unix()), nil
+}
+
+// All retrieves the info hashes of every download.
+func (s *Source) All() ([]string, error) {
+	return s.view("")
+}
+
+// Started retrieves the info hashes of the started downloads.
+func (s *Source) Started() ([]string, error) {
+	return s.view("started")
+}
+
+// Stopped retrieves the info hashes of the stopped downloads.
+func (s *Source) Stopped() ([]string, error) {
+	return s.view("stopped")
+}
+
+// Complete retrieves the info hashes of the complete downloads.
+func (s *Source) Complete() ([]string, error) {
+	return s.view("complete")
+}
+
+// Incomplete retrieves the info hashes of the incomplete downloads.
+func (s *Source) Incomplete() ([]string, error) {
+	return s.view("incomplete")
+}
+
+// Hashing retrieves the info hashes of the downloads being hashed.
+func (s *Source) Hashing() ([]string, error) {
+	return s.view("hashing")
+}
+
+// Seeding retrieves the info hashes of the started complete downloads.
+func (s *Source) Seeding() ([]string, error) {
+	return s.view("seeding")
+}
+
+// Leeching retrieves the info hashes of the started incomplete downloads.
+func (s *Source) Leeching() ([]string, error) {
+	return s.view("leeching")
+}
+
+// Active retrieves the info hashes of the downloads transferring data. Rates
+// aren't saved in the session files, so no download is ever active.
+func (s *Source) Active() ([]string, error) {
+	return s.view("active")
+}
+
+// view retrieves the info hashes of the downloads in the named view.
+func (s *Source) view(name string) ([]string, error) {
+	downloads, err := s.downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(downloads))
+	for _, d := range downloads {
+		if d.inView(name) {
+			hashes = append(hashes, d.hash)
+		}
+	}
+	return hashes, nil
+}
+
+// ViewSizes retrieves the number of downloads in each of the provided views.
+// An empty view name refers to the default view containing all downloads.
+func (s *Source) ViewSizes(views []string) ([]int, error) {
+	downloads, err := s.downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]int, len(views))
+	for i, v := range views {
+		for _, d := range downloads {
+			if d.inView(v) {
+				sizes[i]++
+			}
+		}
+	}
+	return sizes, nil
+}
+
+// DownloadWithDetails fails, as rtorrentrpc.DownloadService retrieves the
+// details of the downloads in the active view and rates aren't saved in the
+// session files, so that no download is ever active. Use AllWithDetails to
+// retrieve the details of every download.
+func (s *Source) DownloadWithDetails(_ []string) ([][]any, error) {
+	return nil, errDownloadDetails
+}
+
+// AllWithDetails retrieves a row with the value of each of the provided d.*
+// commands for every download. An error is returned for commands whose values
+// aren't saved in the session files.
+func (s *Source) AllWithDetails(commands []string) ([][]any, error) {
+	values := make([]func(*download) any, 0, len(commands))
+	for _, cmd := range commands {
+		v, err := downloadCommand(cmd)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	downloads, err := s.downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]any, 0, len(downloads))
+	for _, d := range downloads {
+		row := make([]any, 0, len(values))
+		for _, v := range values {
+			row = append(row, v(d))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// TrackersWithDetails retrieves the trackers of each of the provided downloads
+// along with the value of each of the provided t.* commands. The outer slice is
+// in the same order as infoHashes.
+func (s *Source) TrackersWithDetails(infoHashes []string, commands []string) ([][][]any, error) {
+	values := make([]func(tracker) any, 0, len(commands))
+	for _, cmd := range commands {
+		v, err := trackerCommand(cmd)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	downloads, err := s.downloads()
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*download, len(downloads))
+	for _, d := range downloads {
+		byHash[d.hash] = d
+	}
+
+	trackers := make([][][]any, 0, len(infoHashes))
+	for _, h := range infoHashes {
+		d, ok := byHash[strings.ToUpper(h)]
+		if !ok {
+			return nil, fmt.Errorf("unknown download %q", h)
+		}
+
+		tt := make([][]any, 0, len(d.trackers))
+		for _, t := range d.trackers {
+			row := make([]any, 0, len(values))
+			for _, v := range values {
+				row = append(row, v(t))
+			}
+			tt = append(tt, row)
+		}
+		trackers = append(trackers, tt)
+	}
+	return trackers, nil
+}
+
+// BaseFilename retrieves the name of the download.
+func (s *Source) BaseFilename(infoHash string) (string, error) {
+	d, err := s.download(infoHash)
+	if err != nil {
+		return "", err
+	}
+	return d.name, nil
+}
+
+// DownloadRate retrieves the download rate of the download, which is always 0
+// as rates aren't saved in the session files.
+func (s *Source) DownloadRate(infoHash string) (int, error) {
+	_, err := s.download(infoHash)
+	return 0, err
+}
+
+// DownloadTotal retrieves the number of bytes downloaded by the download.
+func (s *Source) DownloadTotal(infoHash string) (int, error) {
+	d, err := s.download(infoHash)
+	if err != nil {
+		return 0, err
+	}
+	return int(d.stateInt("total_downloaded")), nil
+}
+
+// UploadRate retrieves the upload rate of the download, which is always 0 as
+// rates aren't saved in the session files.
+func (s *Source) UploadRate(infoHash string) (int, error) {
+	_, err := s.download(infoHash)
+	return 0, err
+}
+
+// UploadTotal retrieves the number of bytes uploaded by the download.
+func (s *Source) UploadTotal(infoHash string) (int, error) {
+	d, err := s.download(infoHash)
+	if err != nil {
+		return 0, err
+	}
+	return int(d.stateInt("total_uploaded")), nil
+}
+
+// download returns the download with the provided info hash.
+func (s *Source) download(infoHash string) (*download, error) {
+	downloads, err := s.downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range downloads {
+		if strings.EqualFold(d.hash, infoHash) {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown download %q", infoHash)
+}
+
+// downloads reads every download of the session directory, sorted by info
+// hash. Downloads whose session files can't be decoded are logged and skipped,
+// so that a single corrupt file doesn't hide the others.
+func (s *Source) downloads() ([]*download, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the files still in the session directory are kept in the cache
+	files := make(map[string]cachedFile, len(s.files))
+	defer func() {
+		s.files = files
+	}()
+
+	downloads := make([]*download, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !torrentFileRE.MatchString(e.Name()) {
+			continue
+		}
+
+		hash := strings.ToUpper(strings.TrimSuffix(e.Name(), torrentSuffix))
+		d, err := s.readDownload(filepath.Join(s.dir, e.Name()), hash, files)
+		if err != nil {
+			log.Printf("[ERROR] skipping download %s of session directory %q: %v", hash, s.dir, err)
+			continue
+		}
+		downloads = append(downloads, d)
+	}
+
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].hash < downloads[j].hash
+	})
+	return downloads, nil
+}
+
+// readDownload reads the session files of the download whose metainfo is at
+// path. Its state and progress files are optional, as rTorrent only writes
+// them on its next session save.
+func (s *Source) readDownload(path, hash string, files map[string]cachedFile) (*download, error) {
+	v, err := s.readFile(path, files, func(b []byte) (any, error) {
+		return parseMetainfo(b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, errors.New("metainfo file disappeared")
+	}
+
+	d := &download{
+		hash:     hash,
+		metainfo: v.(*metainfo),
+	}
+
+	for _, f := range []struct {
+		suffix string
+		dict   *map[string]any
+	}{
+		{rtorrentSuffix, &d.state},
+		{resumeSuffix, &d.resume},
+	} {
+		v, err := s.readFile(strings.TrimSuffix(path, torrentSuffix)+f.suffix, files, func(b []byte) (any, error) {
+			return bencode.DecodeDict(b)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			*f.dict = v.(map[string]any)
+		}
+	}
+
+	d.trackers = d.readTrackers()
+	return d, nil
+}
+
+// readFile returns the decoded session file at path, decoding it with parse
+// unless it is cached and didn't change. The value is nil if the file doesn't
+// exist.
+func (s *Source) readFile(path string, files map[string]cachedFile, parse func([]byte) (any, error)) (any, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := s.files[path]; ok && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		files[path] = f
+		return f.value, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", filepath.Base(path), err)
+	}
+
+	files[path] = cachedFile{modTime: fi.ModTime(), size: fi.Size(), value: v}
+	return v, nil
+}
+
+// A metainfo holds the parts of a .torrent file the download commands use, the
+// rest, most notably the piece hashes, is dropped once decoded.
+type metainfo struct {
+	name         string
+	size         int64
+	files        int64
+	chunkSize    int64
+	private      bool
+	creationDate int64
+	trackers     []string
+}
+
+// parseMetainfo decodes a .torrent file.
+func parseMetainfo(b []byte) (*metainfo, error) {
+	dict, err := bencode.DecodeDict(b)
+	if err != nil {
+		return nil, err
+	}
+
+	info, ok := dict["info"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing info dictionary")
+	}
+
+	m := &metainfo{}
+	m.name, _ = info["name"].(string)
+	m.chunkSize, _ = info["piece length"].(int64)
+	m.creationDate, _ = dict["creation date"].(int64)
+	if p, _ := info["private"].(int64); p == 1 {
+		m.private = true
+	}
+
+	if files, ok := info["files"].([]any); ok {
+		for _, f := range files {
+			fd, _ := f.(map[string]any)
+			length, _ := fd["length"].(int64)
+			m.size += length
+			m.files++
+		}
+	} else {
+		m.size, _ = info["length"].(int64)
+		m.files = 1
+	}
+
+	if m.chunkSize <= 0 {
+		return nil, errors.New("missing piece length")
+	}
+
+	// announce-list supersedes announce when present
+	if tiers, ok := dict["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			urls, _ := tier.([]any)
+			for _, u := range urls {
+				if url, ok := u.(string); ok {
+					m.trackers = append(m.trackers, url)
+				}
+			}
+		}
+	} else if url, ok := dict["announce"].(string); ok {
+		m.trackers = append(m.trackers, url)
+	}
+
+	return m, nil
+}
+
+// A download is read from the session files of a download.
+type download struct {
+	hash string
+	*metainfo
+
+	// state is the content of the .torrent.rtorrent file, resume that of the
+	// .torrent.libtorrent_resume file.
+	state  map[string]any
+	resume map[string]any
+
+	trackers []tracker
+}
+
+// A tracker is a tracker of a download.
+type tracker struct {
+	url     string
+	enabled bool
+}
+
+// readTrackers returns the trackers of the metainfo, along with any added to
+// the download since, with their enabled state from the resume file.
+func (d *download) readTrackers() []tracker {
+	saved, _ := d.resume["trackers"].(map[string]any)
+
+	seen := make(map[string]bool, len(d.metainfo.trackers))
+	trackers := make([]tracker, 0, len(d.metainfo.trackers))
+	add := func(url string) {
+		if seen[url] {
+			return
+		}
+		seen[url] = true
+
+		t := tracker{url: url, enabled: true}
+		if s, ok := saved[url].(map[string]any); ok {
+			if enabled, ok := s["enabled"].(int64); ok {
+				t.enabled = enabled != 0
+			}
+		}
+		trackers = append(trackers, t)
+	}
+
+	for _, url := range d.metainfo.trackers {
+		add(url)
+	}
+
+	extra := make([]string, 0, len(saved))
+	for url := range saved {
+		extra = append(extra, url)
+	}
+	sort.Strings(extra)
+	for _, url := range extra {
+		add(url)
+	}
+
+	return trackers
+}
+
+// stateInt returns the integer saved under key in the state file, 0 if missing.
+func (d *download) stateInt(key string) int64 {
+	n, _ := d.state[key].(int64)
+	return n
+}
+
+// stateString returns the string saved under key in the state file, empty if
+// missing.
+func (d *download) stateString(key string) string {
+	s, _ := d.state[key].(string)
+	return s
+}
+
+// started reports whether the download is started.
+func (d *download) started() bool {
+	return d.stateInt("state") == 1
+}
+
+// complete reports whether the download is complete.
+func (d *download) complete() bool {
+	return d.stateInt("complete") == 1
+}
+
+// chunks returns the number of chunks of the download.
+func (d *download) chunks() int64 {
+	return (d.size + d.chunkSize - 1) / d.chunkSize
+}
+
+// completedChunks returns the number of chunks of the download which are done.
+// The resume file holds either the number of chunks, when they are all done,
+// or a bitfield of the chunks done.
+func (d *download) completedChunks() int64 {
+	switch bitfield := d.resume["bitfield"].(type) {
+	case int64:
+		return bitfield
+	case string:
+		var n int64
+		for i := 0; i < len(bitfield); i++ {
+			n += int64(bits.OnesCount8(bitfield[i]))
+		}
+		return n
+	}
+
+	if d.complete() {
+		return d.chunks()
+	}
+	return d.stateInt("chunks_done")
+}
+
+// completedBytes returns the number of bytes of the download which are done.
+func (d *download) completedBytes() int64 {
+	done := d.completedChunks()
+	if done >= d.chunks() {
+		return d.size
+	}
+
+	completed := done * d.chunkSize
+	// The last chunk is usually shorter than the others
+	if d.lastChunkDone() {
+		completed -= d.chunks()*d.chunkSize - d.size
+	}
+	return min(completed, d.size)
+}
+
+// lastChunkDone reports whether the bitfield of the resume file has the last
+// chunk done, chunks are numbered from the most significant bit of each byte.
+func (d *download) lastChunkDone() bool {
+	bitfield, ok := d.resume["bitfield"].(string)
+	last := d.chunks() - 1
+	if !ok || last < 0 || last/8 >= int64(len(bitfield)) {
+		return false
+	}
+	return bitfield[last/8]&(0x80>>(last%8)) != 0
+}
+
+// views returns the names of the views the download was added to.
+func (d *download) views() []string {
+	saved, _ := d.state["views"].([]any)
+
+	views := make([]string, 0, len(saved))
+	for _, v := range saved {
+		if s, ok := v.(string); ok {
+			views = append(views, s)
+		}
+	}
+	return views
+}
+
+// inView reports whether the download is in the named view. The built-in views
+// are computed the same way rTorrent filters them.
+func (d *download) inView(name string) bool {
+	switch name {
+	case "", "default", "main", "name":
+		return true
+	case "started":
+		return d.started()
+	case "stopped":
+		return !d.started()
+	case "complete":
+		return d.complete()
+	case "incomplete":
+		return !d.complete()
+	case "hashing":
+		return d.stateInt("hashing") != 0
+	case "seeding":
+		return d.started() && d.complete()
+	case "leeching":
+		return d.started() && !d.complete()
+	case "active":
+		return false
+	}
+
+	for _, v := range d.views() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// basePath returns the path of the file or directory of the download.
+func (d *download) basePath() string {
+	dir := d.stateString("directory")
+	// rTorrent saves the directory of multi-file downloads with their name
+	if d.files > 1 || filepath.Base(dir) == d.name {
+		return dir
+	}
+	return filepath.Join(dir, d.name)
+}
+
+// ratio returns the ratio of the download in thousandths, as d.ratio= does.
+func (d *download) ratio() int64 {
+	done := d.completedBytes()
+	if done == 0 {
+		return 0
+	}
+	return d.stateInt("total_uploaded") * 1000 / done
+}
+
+// downloadCommands are the d.* commands which can be retrieved from the session
+// files, besides d.custom=key.
+var downloadCommands = map[string]func(*download) any{
+	"d.hash=":               func(d *download) any { return d.hash },
+	"d.name=":               func(d *download) any { return d.name },
+	"d.base_filename=":      func(d *download) any { return d.name },
+	"d.base_path=":          func(d *download) any { return d.basePath() },
+	"d.directory=":          func(d *download) any { return d.stateString("directory") },
+	"d.size_bytes=":         func(d *download) any { return d.size },
+	"d.size_files=":         func(d *download) any { return d.files },
+	"d.size_chunks=":        func(d *download) any { return d.chunks() },
+	"d.chunk_size=":         func(d *download) any { return d.chunkSize },
+	"d.completed_chunks=":   func(d *download) any { return d.completedChunks() },
+	"d.completed_bytes=":    func(d *download) any { return d.completedBytes() },
+	"d.left_bytes=":         func(d *download) any { return d.size - d.completedBytes() },
+	"d.complete=":           func(d *download) any { return d.stateInt("complete") },
+	"d.state=":              func(d *download) any { return d.stateInt("state") },
+	"d.hashing=":            func(d *download) any { return d.stateInt("hashing") },
+	"d.priority=":           func(d *download) any { return d.stateInt("priority") },
+	"d.state_changed=":      func(d *download) any { return d.stateInt("state_changed") },
+	"d.timestamp.started=":  func(d *download) any { return d.stateInt("timestamp.started") },
+	"d.timestamp.finished=": func(d *download) any { return d.stateInt("timestamp.finished") },
+	"d.creation_date=":      func(d *download) any { return d.creationDate },
+	"d.up.total=":           func(d *download) any { return d.stateInt("total_uploaded") },
+	"d.down.total=":         func(d *download) any { return d.stateInt("total_downloaded") },
+	"d.ratio=":              func(d *download) any { return d.ratio() },
+	"d.tied_to_file=":       func(d *download) any { return d.stateString("tied_to_file") },
+	"d.custom1=":            func(d *download) any { return d.stateString("custom1") },
+	"d.custom2=":            func(d *download) any { return d.stateString("custom2") },
+	"d.custom3=":            func(d *download) any { return d.stateString("custom3") },
+	"d.custom4=":            func(d *download) any { return d.stateString("custom4") },
+	"d.custom5=":            func(d *download) any { return d.stateString("custom5") },
+	"d.views=": func(d *download) any {
+		views := d.views()
+		v := make([]any, 0, len(views))
+		for _, s := range views {
+			v = append(v, s)
+		}
+		return v
+	},
+	"d.is_private=": func(d *download) any {
+		if d.private {
+			return int64(1)
+		}
+		return int64(0)
+	},
+	// Rates aren't saved, downloads are reported as idle
+	"d.up.rate=":   func(*download) any { return int64(0) },
+	"d.down.rate=": func(*download) any { return int64(0) },
+}
+
+// downloadCommand returns the function retrieving the value of cmd.
+func downloadCommand(cmd string) (func(*download) any, error) {
+	if key, ok := strings.CutPrefix(cmd, "d.custom="); ok && key != "" {
+		return func(d *download) any {
+			custom, _ := d.state["custom"].(map[string]any)
+			s, _ := custom[key].(string)
+			return s
+		}, nil
+	}
+
+	v, ok := downloadCommands[cmd]
+	if !ok {
+		return nil, fmt.Errorf("command %s isn't saved in the session directory", cmd)
+	}
+	return v, nil
+}
+
+// trackerCommand returns the function retrieving the value of the t.* command
+// cmd.
+func trackerCommand(cmd string) (func(tracker) any, error) {
+	switch cmd {
+	case "t.url=":
+		return func(t tracker) any { return t.url }, nil
+	case "t.is_enabled=":
+		return func(t tracker) any {
+			if t.enabled {
+				return int64(1)
+			}
+			return int64(0)
+		}, nil
+	default:
+		return nil, fmt.Errorf("command %s isn't saved in the session directory", cmd)
+	}
+}
@@ -0,0 +1,230 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The downloads of testdata/session, whose files were bencoded the way
+// rTorrent saves them. A fourth download has a corrupt metainfo file.
+const (
+	// hash1 is a started single-file download which is complete.
+	hash1 = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA1"
+	// hash2 is a started multi-file download with two of its four chunks,
+	// the last one included, done.
+	hash2 = "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB2"
+	// hash3 was just added, rTorrent didn't save its state yet.
+	hash3 = "CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC3"
+)
+
+// copySession copies testdata/session to a temporary directory and returns its
+// path.
+func copySession(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	entries, err := os.ReadDir(filepath.Join("testdata", "session"))
+	assert.Nil(t, err)
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join("testdata", "session", e.Name()))
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, e.Name()), b, 0o600))
+	}
+	return dir
+}
+
+func TestSource_Views(t *testing.T) {
+	s := New(filepath.Join("testdata", "session"))
+
+	all, err := s.All()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash1, hash2, hash3}, all)
+
+	started, err := s.Started()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash1, hash2}, started)
+
+	stopped, err := s.Stopped()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash3}, stopped)
+
+	seeding, err := s.Seeding()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash1}, seeding)
+
+	leeching, err := s.Leeching()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash2}, leeching)
+
+	active, err := s.Active()
+	assert.Nil(t, err)
+	assert.Empty(t, active)
+
+	sizes, err := s.ViewSizes([]string{
+		"", "main", "started", "stopped", "complete", "incomplete", "hashing", "seeding", "leeching", "active", "archive", "unknown",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 3, 2, 1, 1, 2, 0, 1, 1, 0, 1, 0}, sizes)
+}
+
+func TestSource_AllWithDetails(t *testing.T) {
+	s := New(filepath.Join("testdata", "session"))
+
+	rows, err := s.AllWithDetails([]string{
+		"d.hash=",
+		"d.name=",
+		"d.base_path=",
+		"d.size_bytes=",
+		"d.size_files=",
+		"d.size_chunks=",
+		"d.completed_chunks=",
+		"d.completed_bytes=",
+		"d.left_bytes=",
+		"d.up.total=",
+		"d.ratio=",
+		"d.up.rate=",
+		"d.custom1=",
+		"d.custom=seedtime",
+		"d.views=",
+		"d.is_private=",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]any{
+		{
+			hash1, "ubuntu.iso", "/data/linux/ubuntu.iso", int64(10000), int64(1), int64(3), int64(3), int64(10000), int64(0),
+			int64(50000), int64(5000), int64(0), "linux", "3600", []any{"archive"}, int64(1),
+		},
+		{
+			hash2, "album", "/data/music/album", int64(8000), int64(2), int64(4), int64(2), int64(3904), int64(4096),
+			int64(1000), int64(256), int64(0), "music", "", []any{}, int64(0),
+		},
+		{
+			hash3, "new.txt", "new.txt", int64(100), int64(1), int64(1), int64(0), int64(0), int64(100),
+			int64(0), int64(0), int64(0), "", "", []any{}, int64(0),
+		},
+	}, rows)
+
+	_, err = s.AllWithDetails([]string{"d.hash=", "d.peers_connected="})
+	assert.EqualError(t, err, "command d.peers_connected= isn't saved in the session directory")
+}
+
+func TestSource_DownloadWithDetails(t *testing.T) {
+	s := New(filepath.Join("testdata", "session"))
+
+	// No download is ever in the active view, which fails rather than report
+	// no details
+	rows, err := s.DownloadWithDetails([]string{"d.hash=", "d.name="})
+	assert.ErrorIs(t, err, errDownloadDetails)
+	assert.Nil(t, rows)
+}
+
+func TestSource_TrackersWithDetails(t *testing.T) {
+	s := New(filepath.Join("testdata", "session"))
+
+	trackers, err := s.TrackersWithDetails([]string{hash2, hash1}, []string{"t.url=", "t.is_enabled="})
+	assert.Nil(t, err)
+	assert.Equal(t, [][][]any{
+		{
+			{"http://a.example.com/ann", int64(1)},
+			{"udp://b.example.net:1337", int64(0)},
+			{"dht://", int64(1)},
+		},
+		{
+			{"http://tracker.example.org/announce", int64(1)},
+		},
+	}, trackers)
+
+	_, err = s.TrackersWithDetails([]string{hash1}, []string{"t.scrape_complete="})
+	assert.NotNil(t, err)
+
+	_, err = s.TrackersWithDetails([]string{"0000000000000000000000000000000000000000"}, []string{"t.url="})
+	assert.NotNil(t, err)
+}
+
+func TestSource_Download(t *testing.T) {
+	s := New(filepath.Join("testdata", "session"))
+
+	name, err := s.BaseFilename(hash1)
+	assert.Nil(t, err)
+	assert.Equal(t, "ubuntu.iso", name)
+
+	down, err := s.DownloadTotal(hash1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, down)
+
+	up, err := s.UploadTotal(hash2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, up)
+
+	rate, err := s.UploadRate(hash2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rate)
+
+	_, err = s.DownloadRate("0000000000000000000000000000000000000000")
+	assert.NotNil(t, err)
+}
+
+func TestSource_System(t *testing.T) {
+	dir := copySession(t)
+	s := New(dir)
+
+	version, err := s.ClientVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "session:"+dir, version)
+
+	_, err = s.StartupTime()
+	assert.NotNil(t, err)
+
+	started := time.Unix(1700000000, 0)
+	lock := filepath.Join(dir, lockFile)
+	assert.Nil(t, os.WriteFile(lock, []byte("host:+1234\n"), 0o600))
+	assert.Nil(t, os.Chtimes(lock, started, started))
+
+	startupTime, err := s.StartupTime()
+	assert.Nil(t, err)
+	assert.Equal(t, 1700000000, startupTime)
+
+	_, err = New(filepath.Join(dir, "missing")).ClientVersion()
+	assert.NotNil(t, err)
+	_, err = New(lock).ClientVersion()
+	assert.NotNil(t, err)
+}
+
+func TestSource_Changes(t *testing.T) {
+	dir := copySession(t)
+	s := New(dir)
+
+	complete, err := s.Complete()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash1}, complete)
+
+	// rTorrent saved the session after hash2 completed and hash1 was removed
+	resume := filepath.Join(dir, hash2+resumeSuffix)
+	assert.Nil(t, os.WriteFile(resume, []byte("d8:bitfieldi4ee"), 0o600))
+	state := filepath.Join(dir, hash2+rtorrentSuffix)
+	assert.Nil(t, os.WriteFile(state, []byte("d8:completei1e5:statei1ee"), 0o600))
+	for _, suffix := range []string{torrentSuffix, rtorrentSuffix, resumeSuffix} {
+		assert.Nil(t, os.Remove(filepath.Join(dir, hash1+suffix)))
+	}
+
+	complete, err = s.Complete()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{hash2}, complete)
+
+	rows, err := s.AllWithDetails([]string{"d.hash=", "d.completed_bytes="})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]any{{hash2, int64(8000)}, {hash3, int64(0)}}, rows)
+
+	s.mu.Lock()
+	for path := range s.files {
+		assert.NotContains(t, path, hash1)
+	}
+	s.mu.Unlock()
+
+	_, err = New(filepath.Join(dir, "missing")).All()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// An Authenticator adds credentials to the requests sent to rTorrent.
+type Authenticator interface {
+	// Authorize adds the credentials to r.
+	Authorize(r *http.Request) error
+
+	// Challenge is given the 401 response of the server to r, and reports
+	// whether r should be sent again with the credentials it now knows of,
+	// e.g. to answer a new Digest nonce.
+	Challenge(r *http.Request, resp *http.Response) bool
+}
+
+// NewAuthenticator returns the Authenticator of the scheme selected by opts,
+// nil when requests aren't authenticated. The secret files are read once so
+// that a missing or empty file is reported early.
+func NewAuthenticator(opts Options) (Authenticator, error) {
+	scheme := opts.AuthScheme()
+	if scheme == "" {
+		if opts.Password != "" || opts.PasswordFile != "" || opts.BearerTokenFile != "" {
+			return nil, errors.New("a password or bearer token file is set without an authentication scheme")
+		}
+		return nil, nil
+	}
+
+	switch scheme {
+	case AuthBasic, AuthDigest:
+		creds, err := newCredentials(opts)
+		if err != nil {
+			return nil, err
+		}
+		if scheme == AuthDigest {
+			return &digestAuth{creds: creds}, nil
+		}
+		return &basicAuth{creds: creds}, nil
+
+	case AuthBearer:
+		if opts.Username != "" || opts.Password != "" || opts.PasswordFile != "" {
+			return nil, errors.New("bearer authentication doesn't use a username or password")
+		}
+		if opts.BearerTokenFile == "" {
+			return nil, errors.New("bearer authentication requires a bearer token file")
+		}
+		token := &secretFile{name: "bearer token file", path: opts.BearerTokenFile}
+		if _, err := token.read(); err != nil {
+			return nil, err
+		}
+		return &bearerAuth{token: token}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown authentication scheme %q, must be one of %s, %s or %s", scheme, AuthBasic, AuthDigest, AuthBearer)
+	}
+}
+
+// ValidateHeader checks that a static header may be set on the requests to
+// rTorrent. Authorization is reserved to the authentication scheme when auth
+// is set. Errors never include the value, which may be a secret.
+func ValidateHeader(name, value string, auth bool) error {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("value of header %q must not contain line breaks", name)
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case "Host", "Content-Length", "Content-Type", "Transfer-Encoding", "Connection":
+		return fmt.Errorf("header %q is set by the exporter", name)
+	case "Authorization":
+		if auth {
+			return errors.New("the Authorization header can't be set along with an authentication scheme")
+		}
+	}
+	return nil
+}
+
+// isTokenChar reports whether r may appear in an HTTP token, RFC 9110 5.6.2.
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+	}
+}
+
+// credentials are the username and password of Basic and Digest
+// authentication.
+type credentials struct {
+	username string
+	password string
+	file     *secretFile
+}
+
+func newCredentials(opts Options) (credentials, error) {
+	switch {
+	case opts.BearerTokenFile != "":
+		return credentials{}, fmt.Errorf("a bearer token file requires the %s authentication scheme", AuthBearer)
+	case opts.Username == "":
+		return credentials{}, fmt.Errorf("%s authentication requires a username", opts.AuthScheme())
+	case opts.Password != "" && opts.PasswordFile != "":
+		return credentials{}, errors.New("password and password file are mutually exclusive")
+	case opts.Password == "" && opts.PasswordFile == "":
+		return credentials{}, fmt.Errorf("%s authentication requires a password or password file", opts.AuthScheme())
+	}
+
+	creds := credentials{username: opts.Username, password: opts.Password}
+	if opts.PasswordFile != "" {
+		creds.file = &secretFile{name: "password file", path: opts.PasswordFile}
+		if _, err := creds.file.read(); err != nil {
+			return credentials{}, err
+		}
+	}
+	return creds, nil
+}
+
+// secret returns the password, read from the password file if any.
+func (c credentials) secret() (string, error) {
+	if c.file != nil {
+		return c.file.read()
+	}
+	return c.password, nil
+}
+
+// basicAuth implements HTTP Basic authentication, RFC 7617.
+type basicAuth struct {
+	creds credentials
+}
+
+func (a *basicAuth) Authorize(r *http.Request) error {
+	password, err := a.creds.secret()
+	if err != nil {
+		return err
+	}
+	r.SetBasicAuth(a.creds.username, password)
+	return nil
+}
+
+// Challenge never retries, the credentials sent were simply rejected.
+func (a *basicAuth) Challenge(*http.Request, *http.Response) bool {
+	return false
+}
+
+// bearerAuth sends a token read from a file, such as one maintained by an
+// OAuth2 sidecar, RFC 6750.
+type bearerAuth struct {
+	token *secretFile
+}
+
+func (a *bearerAuth) Authorize(r *http.Request) error {
+	token, err := a.token.read()
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Challenge never retries, a rotated token is read on the next request.
+func (a *bearerAuth) Challenge(*http.Request, *http.Response) bool {
+	return false
+}
@@ -0,0 +1,240 @@
+package transport
+
+import (
+	"crypto/md5" //nolint:gosec // HTTP Digest's default algorithm
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// post sends body to url through rt, and returns the response status.
+func post(t *testing.T, rt http.RoundTripper, url, body string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	resp, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, req.Header.Get("Authorization"), "the request given must not be modified")
+	return resp.StatusCode
+}
+
+func TestNew_NoAuth(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	opts := Options{Headers: map[string]string{"X-Shared-Secret": "hunter2"}}
+	assert.False(t, opts.AuthEnabled())
+	assert.Nil(t, get(t, ts.URL, opts))
+	assert.NotContains(t, header, "Authorization")
+	assert.Equal(t, "hunter2", header.Get("X-Shared-Secret"))
+
+	// An Authorization header may be set by hand when no scheme is in use
+	opts = Options{Headers: map[string]string{"Authorization": "Token abc"}}
+	assert.Nil(t, get(t, ts.URL, opts))
+	assert.Equal(t, "Token abc", header.Get("Authorization"))
+}
+
+func TestNew_BasicAuth(t *testing.T) {
+	var user, password string
+	var ok bool
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		user, password, ok = r.BasicAuth()
+	}))
+	defer ts.Close()
+
+	for _, scheme := range []string{"", AuthBasic} {
+		ok = false
+		assert.Nil(t, get(t, ts.URL, Options{Auth: scheme, Username: "admin", Password: "secret"}))
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", password)
+	}
+}
+
+func TestNew_BearerAuth(t *testing.T) {
+	var header string
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	rt, err := New(ts.URL, Options{Auth: AuthBearer, BearerTokenFile: path})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, post(t, rt, ts.URL, ""))
+	assert.Equal(t, "Bearer first", header)
+
+	// A rotated token is used from the next request on
+	assert.Nil(t, os.WriteFile(path, []byte("rotated"), 0o600))
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	assert.Equal(t, http.StatusOK, post(t, rt, ts.URL, ""))
+	assert.Equal(t, "Bearer rotated", header)
+}
+
+// digestServer is an HTTP Digest protected server, which issues a new nonce
+// whenever stale is set.
+type digestServer struct {
+	algorithm string
+	password  string
+
+	mu       sync.Mutex
+	nonce    int
+	stale    bool
+	requests int
+	nc       []string
+	bodies   []string
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+
+	stale := s.stale
+	if stale {
+		s.nonce++
+		s.stale = false
+	}
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+
+	var params map[string]string
+	if chs := parseChallenges(r.Header.Get("Authorization")); len(chs) == 1 && chs[0].scheme == "Digest" {
+		params = chs[0].params
+	}
+	if params != nil && params["nonce"] == nonce && params["response"] == s.response(r.Method, params) {
+		s.nc = append(s.nc, params["nc"])
+		return
+	}
+
+	w.Header().Add("WWW-Authenticate", `Basic realm="rtorrent"`)
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="rtorrent", qop="auth-int, auth", algorithm=%s, nonce="%s", opaque="xyz", stale=%v`,
+		s.algorithm, nonce, stale && params != nil))
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// response computes the expected response to the digest parameters.
+func (s *digestServer) response(method string, p map[string]string) string {
+	h := func(parts ...string) string {
+		var hh hash.Hash = md5.New() //nolint:gosec // see import
+		if strings.HasPrefix(s.algorithm, "SHA-256") {
+			hh = sha256.New()
+		}
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	ha1 := h(p["username"], p["realm"], s.password)
+	if strings.HasSuffix(s.algorithm, "-sess") {
+		ha1 = h(ha1, p["nonce"], p["cnonce"])
+	}
+	return h(ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], h(method, p["uri"]))
+}
+
+func TestNew_DigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "MD5-sess", "SHA-256", "SHA-256-sess"} {
+		t.Run(algorithm, func(t *testing.T) {
+			s := &digestServer{algorithm: algorithm, password: "secret"}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+
+			rt, err := New(ts.URL+"/RPC2?x=1", Options{Auth: AuthDigest, Username: "admin", Password: "secret"})
+			assert.Nil(t, err)
+
+			// The first request is challenged and sent again with its body
+			assert.Equal(t, http.StatusOK, post(t, rt, ts.URL+"/RPC2?x=1", "<methodCall/>"))
+			assert.Equal(t, []string{"<methodCall/>", "<methodCall/>"}, s.bodies)
+
+			// The nonce is reused with an increasing count
+			assert.Equal(t, http.StatusOK, post(t, rt, ts.URL+"/RPC2?x=1", ""))
+			assert.Equal(t, 3, s.requests)
+			assert.Equal(t, []string{"00000001", "00000002"}, s.nc)
+
+			// A stale nonce is replaced
+			s.stale = true
+			assert.Equal(t, http.StatusOK, post(t, rt, ts.URL+"/RPC2?x=1", ""))
+			assert.Equal(t, 5, s.requests)
+			assert.Equal(t, []string{"00000001", "00000002", "00000001"}, s.nc)
+		})
+	}
+}
+
+func TestNew_DigestAuthWrongPassword(t *testing.T) {
+	s := &digestServer{algorithm: "MD5", password: "secret"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	rt, err := New(ts.URL, Options{Auth: AuthDigest, Username: "admin", Password: "wrong"})
+	assert.Nil(t, err)
+
+	// The challenge is answered once, rejected credentials aren't retried
+	assert.Equal(t, http.StatusUnauthorized, post(t, rt, ts.URL, ""))
+	assert.Equal(t, 2, s.requests)
+	assert.Equal(t, http.StatusUnauthorized, post(t, rt, ts.URL, ""))
+	assert.Equal(t, 3, s.requests)
+}
+
+func TestNew_AuthErrors(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	assert.Nil(t, os.WriteFile(token, []byte("token"), 0o600))
+
+	tests := map[string]Options{
+		"unknown scheme":            {Auth: "ntlm", Username: "admin", Password: "secret"},
+		"password without username": {Password: "secret"},
+		"digest without username":   {Auth: AuthDigest},
+		"digest without password":   {Auth: AuthDigest, Username: "admin"},
+		"basic with token file":     {Username: "admin", Password: "secret", BearerTokenFile: token},
+		"bearer without token file": {Auth: AuthBearer},
+		"bearer with username":      {Auth: AuthBearer, BearerTokenFile: token, Username: "admin"},
+		"missing token file":        {Auth: AuthBearer, BearerTokenFile: filepath.Join(dir, "missing")},
+		"invalid header name":       {Headers: map[string]string{"X Secret": "value"}},
+		"header value with newline": {Headers: map[string]string{"X-Secret": "value\r\nX-Other: value"}},
+		"host header":               {Headers: map[string]string{"host": "example.org"}},
+		"authorization with scheme": {Username: "admin", Password: "secret", Headers: map[string]string{"Authorization": "Token abc"}},
+		"token file without scheme": {BearerTokenFile: token},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New("http://127.0.0.1/RPC2", opts)
+			assert.NotNil(t, err)
+		})
+	}
+
+	_, err := New("scgi://127.0.0.1:5000", Options{Headers: map[string]string{"X-Secret": "value"}})
+	assert.NotNil(t, err)
+}
+
+func TestParseChallenges(t *testing.T) {
+	got := parseChallenges(`Newauth realm="apps", type=1, title="Login to \"apps\"", Basic realm="simple", Negotiate abc==, Digest nonce=x`)
+	assert.Equal(t, []challenge{
+		{scheme: "Newauth", params: map[string]string{"realm": "apps", "type": "1", "title": `Login to "apps"`}},
+		{scheme: "Basic", params: map[string]string{"realm": "simple"}},
+		{scheme: "Negotiate", params: map[string]string{}},
+		{scheme: "Digest", params: map[string]string{"nonce": "x"}},
+	}, got)
+	assert.Empty(t, parseChallenges(""))
+}
@@ -0,0 +1,294 @@
+package transport
+
+import (
+	"crypto/md5" //nolint:gosec // MD5 is the default algorithm of HTTP Digest authentication
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestAuth implements HTTP Digest authentication, RFC 7616, with the MD5 and
+// SHA-256 algorithms and their -sess variants, and the "auth" quality of
+// protection.
+//
+// Nothing is sent until the server's first challenge gives a nonce, which is
+// then reused for the following requests with an increasing nonce count until
+// the server rejects it as stale.
+type digestAuth struct {
+	creds credentials
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+}
+
+// A digestChallenge holds the parameters of a WWW-Authenticate: Digest header.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string // "auth", or empty for RFC 2069 servers
+	stale     bool
+}
+
+func (a *digestAuth) Authorize(r *http.Request) error {
+	a.mu.Lock()
+	c := a.challenge
+	a.nc++
+	nc := a.nc
+	a.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	password, err := a.creds.secret()
+	if err != nil {
+		return err
+	}
+	cnonce, err := newCnonce()
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", c.authorization(a.creds.username, password, r.Method, r.URL.RequestURI(), nc, cnonce))
+	return nil
+}
+
+// Challenge retries when the server sent a nonce r didn't use, or flagged the
+// nonce r used as stale. Otherwise the credentials themselves were rejected.
+func (a *digestAuth) Challenge(r *http.Request, resp *http.Response) bool {
+	var c *digestChallenge
+	for _, h := range resp.Header.Values("WWW-Authenticate") {
+		for _, ch := range parseChallenges(h) {
+			if c == nil && strings.EqualFold(ch.scheme, "Digest") {
+				c = newDigestChallenge(ch.params)
+			}
+		}
+	}
+	if c == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	if a.challenge == nil || a.challenge.nonce != c.nonce {
+		a.challenge, a.nc = c, 0
+	}
+	a.mu.Unlock()
+
+	used := ""
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		for _, ch := range parseChallenges(auth) {
+			used = ch.params["nonce"]
+		}
+	}
+	return used != c.nonce || c.stale
+}
+
+// newDigestChallenge returns the challenge of the parameters, nil if it uses
+// an unsupported algorithm or quality of protection.
+func newDigestChallenge(params map[string]string) *digestChallenge {
+	c := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+		stale:     strings.EqualFold(params["stale"], "true"),
+	}
+	if c.nonce == "" || c.hash() == nil {
+		return nil
+	}
+
+	if qop, ok := params["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.qop == "" {
+			return nil
+		}
+	}
+	return c
+}
+
+// hash returns a new hash of the challenge's algorithm, nil if unsupported.
+func (c *digestChallenge) hash() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(c.algorithm), "-sess")) {
+	case "", "MD5":
+		return md5.New() //nolint:gosec // see import
+	case "SHA-256":
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// h hashes the parts joined by colons and returns the hex digest.
+func (c *digestChallenge) h(parts ...string) string {
+	h := c.hash()
+	_, _ = h.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// authorization returns the Authorization header answering the challenge.
+func (c *digestChallenge) authorization(username, password, method, uri string, nc uint32, cnonce string) string {
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := c.h(username, c.realm, password)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = c.h(ha1, c.nonce, cnonce)
+	}
+	ha2 := c.h(method, uri)
+
+	var response string
+	if c.qop == "" {
+		response = c.h(ha1, c.nonce, ha2)
+	} else {
+		response = c.h(ha1, c.nonce, ncValue, cnonce, c.qop, ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, response=%s`,
+		quote(username), quote(c.realm), quote(c.nonce), quote(uri), quote(response))
+	if c.algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", c.algorithm)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%s", quote(c.opaque))
+	}
+	if c.qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", c.qop, ncValue, quote(cnonce))
+	}
+	return b.String()
+}
+
+// newCnonce returns a random client nonce.
+func newCnonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate digest cnonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// quote returns s as an HTTP quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// A challenge is an authentication scheme along with its parameters, as found
+// in WWW-Authenticate and Authorization headers.
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenges parses the comma separated challenges of a header, RFC 9110
+// 11.6.1. Parameter names are lower cased, token68 credentials are skipped.
+func parseChallenges(h string) []challenge {
+	var challenges []challenge
+	p := &headerParser{s: h}
+
+	for {
+		p.skip(" \t,")
+		scheme := p.token()
+		if scheme == "" {
+			return challenges
+		}
+		ch := challenge{scheme: scheme, params: map[string]string{}}
+
+		for {
+			p.skip(" \t,")
+			start := p.i
+			name := p.token()
+			p.skip(" \t")
+			if name == "" || !p.consume('=') {
+				// The next challenge starts, or token68 credentials
+				p.i = start
+				if name == "" {
+					p.skipToken68()
+				}
+				break
+			}
+			p.skip(" \t")
+			if p.peek() == '"' {
+				ch.params[strings.ToLower(name)] = p.quoted()
+				continue
+			}
+			if value := p.token(); value != "" {
+				ch.params[strings.ToLower(name)] = value
+			} else {
+				// token68 credentials ending with padding, e.g. abc==
+				p.skipToken68()
+			}
+		}
+		challenges = append(challenges, ch)
+	}
+}
+
+// A headerParser scans the tokens and quoted-strings of a header value.
+type headerParser struct {
+	s string
+	i int
+}
+
+func (p *headerParser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *headerParser) consume(c byte) bool {
+	if p.peek() == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *headerParser) skip(chars string) {
+	for p.i < len(p.s) && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *headerParser) token() string {
+	start := p.i
+	for p.i < len(p.s) && isTokenChar(rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// skipToken68 skips anything up to the next comma.
+func (p *headerParser) skipToken68() {
+	for p.i < len(p.s) && p.s[p.i] != ',' {
+		p.i++
+	}
+}
+
+func (p *headerParser) quoted() string {
+	var b strings.Builder
+	p.i++ // opening quote
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '"':
+			return b.String()
+		case c == '\\' && p.i < len(p.s):
+			b.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
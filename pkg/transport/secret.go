@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A secretFile reads a secret, such as a password or a bearer token, from a
+// file like a Docker or Kubernetes secret. The file is read again whenever its
+// modification time or size changes, so that a rotated secret is used from the
+// next request on.
+type secretFile struct {
+	// name describes the secret in errors, e.g. "password file".
+	name string
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	secret  string
+}
+
+// read returns the secret held by the file, without its trailing newline.
+// Errors never include the secret.
+func (f *secretFile) read() (string, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", f.name, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.secret, nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", f.name, err)
+	}
+	secret := strings.TrimRight(string(b), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s %q is empty", f.name, f.path)
+	}
+
+	f.modTime, f.size, f.secret = fi.ModTime(), fi.Size(), secret
+	return secret, nil
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_PasswordFile(t *testing.T) {
+	var password string
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		_, password, _ = r.BasicAuth()
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	opts := Options{Username: "admin", PasswordFile: path}
+	assert.True(t, opts.AuthEnabled())
+	assert.Nil(t, get(t, ts.URL, opts))
+	assert.Equal(t, "first", password)
+
+	rt, err := New(ts.URL, opts)
+	assert.Nil(t, err)
+	client := &http.Client{Transport: rt}
+
+	// A rotated secret is used from the next request on
+	assert.Nil(t, os.WriteFile(path, []byte("rotated"), 0o600))
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	resp, err := client.Get(ts.URL)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "rotated", password)
+
+	assert.Nil(t, os.Remove(path))
+	_, err = client.Get(ts.URL)
+	assert.ErrorContains(t, err, "cannot read password file")
+}
+
+func TestNew_PasswordFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	assert.Nil(t, os.WriteFile(empty, []byte("\n"), 0o600))
+
+	tests := map[string]Options{
+		"missing file":           {Username: "admin", PasswordFile: filepath.Join(dir, "missing")},
+		"empty file":             {Username: "admin", PasswordFile: empty},
+		"password and file both": {Username: "admin", Password: "secret", PasswordFile: empty},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New("http://127.0.0.1/RPC2", opts)
+			assert.NotNil(t, err)
+		})
+	}
+
+	_, err := New("scgi://127.0.0.1:5000", Options{PasswordFile: empty})
+	assert.NotNil(t, err)
+}
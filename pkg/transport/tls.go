@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseFingerprint parses the hex encoded SHA-256 fingerprint of a
+// certificate, its bytes may be separated by colons as printed by
+// `openssl x509 -noout -fingerprint -sha256`.
+func ParseFingerprint(fp string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", fp)
+	}
+	return b, nil
+}
+
+// newTLSConfig builds the TLS settings of the connection to rTorrent.
+//
+// Pinned fingerprints are checked on top of the usual verification when a CA
+// file is given, any certificate of the verified chain may then be pinned.
+// Otherwise they replace it, so that a self-signed certificate can be trusted
+// without disabling verification altogether, and only rTorrent's own
+// certificate may be pinned.
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("client certificate and key files must be set together")
+	}
+	if opts.Insecure && (opts.CAFile != "" || len(opts.Fingerprints) > 0) {
+		return nil, errors.New("insecure can't be combined with a CA file or pinned fingerprints")
+	}
+
+	cfg := &tls.Config{
+		//nolint:gosec // we don't care that this may be true, that's the point
+		InsecureSkipVerify: opts.Insecure,
+		ServerName:         opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		b, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in CA file %q", opts.CAFile)
+		}
+	}
+
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.Fingerprints) > 0 {
+		pins := make([][]byte, 0, len(opts.Fingerprints))
+		for _, fp := range opts.Fingerprints {
+			pin, err := ParseFingerprint(fp)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, pin)
+		}
+
+		if opts.CAFile == "" {
+			// The pins are verified in place of the chain
+			cfg.InsecureSkipVerify = true
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs, pins)
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyPins checks that the connection is to a certificate with one of the
+// pinned fingerprints.
+func verifyPins(cs tls.ConnectionState, pins [][]byte) error {
+	var certs []*x509.Certificate
+	switch {
+	case len(cs.VerifiedChains) > 0:
+		for _, chain := range cs.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+	case len(cs.PeerCertificates) > 0:
+		// Without a verified chain, only the certificate whose key the server
+		// proved to hold can be trusted
+		certs = cs.PeerCertificates[:1]
+	}
+
+	for _, cert := range certs {
+		fp := sha256.Sum256(cert.Raw)
+		for _, pin := range pins {
+			if bytes.Equal(fp[:], pin) {
+				return nil
+			}
+		}
+	}
+	return errors.New("rTorrent's certificate doesn't match any of the pinned fingerprints")
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePEM writes the PEM block to a file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, b []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	assert.Nil(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0o600))
+	return path
+}
+
+// newClientCert creates a CA and a client certificate it signed, and returns
+// the CA along with the paths of the client certificate and key files.
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "rtorrent-exporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	return ca, writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+// get sends a request to url through a transport built with opts.
+func get(t *testing.T, url string, opts Options) error {
+	t.Helper()
+
+	rt, err := New(url, opts)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: rt}).Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestNew_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+	fp := sha256.Sum256(ts.Certificate().Raw)
+	pin := hex.EncodeToString(fp[:])
+
+	tests := []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{"system roots", Options{}, false},
+		{"insecure", Options{Insecure: true}, true},
+		{"CA file", Options{CAFile: caFile}, true},
+		{"server name", Options{CAFile: caFile, ServerName: "example.com"}, true},
+		{"wrong server name", Options{CAFile: caFile, ServerName: "rtorrent.example.org"}, false},
+		{"pinned", Options{Fingerprints: []string{pin}}, true},
+		{"pinned with CA file", Options{CAFile: caFile, Fingerprints: []string{strings.ToUpper(pin)}}, true},
+		{"wrong pin", Options{Fingerprints: []string{strings.Repeat("00", sha256.Size)}}, false},
+		{"wrong pin with CA file", Options{CAFile: caFile, Fingerprints: []string{strings.Repeat("00", sha256.Size)}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := get(t, ts.URL, tt.opts)
+			assert.Equal(t, tt.ok, err == nil, err)
+		})
+	}
+}
+
+func TestNew_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	clientCA, certFile, keyFile := newClientCert(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+		MinVersion: tls.VersionTLS12,
+	}
+	ts.TLS.ClientCAs.AddCert(clientCA)
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+
+	assert.Nil(t, get(t, ts.URL, Options{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	assert.NotNil(t, get(t, ts.URL, Options{CAFile: caFile}))
+}
+
+func TestNew_TLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := newClientCert(t, dir)
+	pin := strings.Repeat("ab", sha256.Size)
+
+	tests := map[string]struct {
+		addr string
+		opts Options
+	}{
+		"cert without key":      {"https://127.0.0.1/RPC2", Options{CertFile: certFile}},
+		"key without cert":      {"https://127.0.0.1/RPC2", Options{KeyFile: keyFile}},
+		"missing cert":          {"https://127.0.0.1/RPC2", Options{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile}},
+		"missing CA":            {"https://127.0.0.1/RPC2", Options{CAFile: filepath.Join(dir, "missing.crt")}},
+		"CA without cert":       {"https://127.0.0.1/RPC2", Options{CAFile: keyFile}},
+		"invalid fingerprint":   {"https://127.0.0.1/RPC2", Options{Fingerprints: []string{"ab:cd"}}},
+		"insecure with pin":     {"https://127.0.0.1/RPC2", Options{Insecure: true, Fingerprints: []string{pin}}},
+		"insecure with CA file": {"https://127.0.0.1/RPC2", Options{Insecure: true, CAFile: certFile}},
+		"SCGI with CA file":     {"scgi://127.0.0.1:5000", Options{CAFile: certFile}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(tt.addr, tt.opts)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	want := make([]byte, sha256.Size)
+	want[0], want[31] = 0xab, 0x01
+
+	got, err := ParseFingerprint("AB" + strings.Repeat(":00", 30) + ":01")
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = ParseFingerprint("not hex")
+	assert.NotNil(t, err)
+	_, err = ParseFingerprint(strings.Repeat("ab", 20))
+	assert.NotNil(t, err)
+}
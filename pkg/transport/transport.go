@@ -0,0 +1,201 @@
+// Package transport builds the http.RoundTripper used to talk to an rTorrent
+// XML-RPC server, either over HTTP(S) or directly over SCGI.
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aauren/rtorrent-exporter/pkg/scgi"
+)
+
+// Authentication schemes of Options.Auth.
+const (
+	AuthBasic  = "basic"
+	AuthDigest = "digest"
+	AuthBearer = "bearer"
+)
+
+// Options configures the http.RoundTripper returned by New.
+type Options struct {
+	// Auth is the authentication scheme, one of AuthBasic, AuthDigest or
+	// AuthBearer. When empty, HTTP Basic authentication is used if a username
+	// is set, and no authentication at all otherwise.
+	Auth string
+
+	// Username and Password are the credentials of HTTP Basic and Digest
+	// authentication.
+	Username string
+	Password string
+
+	// PasswordFile holds the password in place of Password, it is read again
+	// whenever it changes so that rotated secrets apply without a restart.
+	PasswordFile string
+
+	// BearerTokenFile holds the token of bearer authentication, it is read
+	// again whenever it changes.
+	BearerTokenFile string
+
+	// Headers are added to every request, e.g. a shared secret expected by a
+	// reverse proxy.
+	Headers map[string]string
+
+	// Insecure allows using XML-RPC with a non-CA signed certificate.
+	Insecure bool
+
+	// CAFile holds the CAs rTorrent's certificate is verified against, in
+	// place of the system's.
+	CAFile string
+
+	// CertFile and KeyFile hold the client certificate presented to rTorrent.
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the host name rTorrent's certificate is verified
+	// for.
+	ServerName string
+
+	// Fingerprints are the SHA-256 fingerprints rTorrent's certificate is
+	// pinned to, see ParseFingerprint.
+	Fingerprints []string
+
+	// Timeout bounds dialing rTorrent, or the whole exchange for SCGI.
+	Timeout time.Duration
+}
+
+// AuthScheme returns the authentication scheme in use, empty when requests
+// aren't authenticated.
+func (o Options) AuthScheme() string {
+	switch {
+	case o.Auth != "":
+		return o.Auth
+	case o.Username != "":
+		return AuthBasic
+	default:
+		return ""
+	}
+}
+
+// AuthEnabled reports whether requests are authenticated.
+func (o Options) AuthEnabled() bool {
+	return o.AuthScheme() != ""
+}
+
+// TLSEnabled reports whether any TLS setting is configured.
+func (o Options) TLSEnabled() bool {
+	return o.Insecure || o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.ServerName != "" || len(o.Fingerprints) > 0
+}
+
+// New creates a http.RoundTripper for the rTorrent XML-RPC server at addr.
+// SCGI addresses (scgi:// and scgi+unix://) are spoken to directly, all other
+// addresses go through an HTTP transport.
+func New(addr string, opts Options) (http.RoundTripper, error) {
+	if scgi.IsSCGI(addr) {
+		if opts.AuthEnabled() || opts.Password != "" || opts.PasswordFile != "" || opts.BearerTokenFile != "" ||
+			len(opts.Headers) > 0 || opts.TLSEnabled() {
+			return nil, fmt.Errorf("authentication, header and TLS options only apply to HTTP(S) addresses, not SCGI")
+		}
+		return &scgi.Transport{
+			Timeout: opts.Timeout,
+		}, nil
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := NewAuthenticator(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range opts.Headers {
+		if err := ValidateHeader(name, value, auth != nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &authRoundTripper{
+		Auth:    auth,
+		Headers: opts.Headers,
+		Transport: &http.Transport{
+			DialContext:     (&net.Dialer{Timeout: opts.Timeout}).DialContext,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+var _ http.RoundTripper = &authRoundTripper{}
+
+// An authRoundTripper is a http.RoundTripper which adds the static headers and
+// the credentials of its Authenticator to each HTTP request.
+type authRoundTripper struct {
+	// Auth is nil when requests aren't authenticated.
+	Auth      Authenticator
+	Headers   map[string]string
+	Transport *http.Transport
+}
+
+// RoundTrip sends the request with the headers and credentials added. If the
+// server challenges the credentials and the Authenticator can answer the
+// challenge, the request is sent once more.
+func (rt *authRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	req, err := rt.prepare(r, r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.Transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || rt.Auth == nil {
+		return resp, err
+	}
+
+	if !rt.Auth.Challenge(req, resp) {
+		return resp, nil
+	}
+	// The body was consumed by the first attempt
+	body := r.Body
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.GetBody == nil {
+			return resp, nil
+		}
+		if body, err = r.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if req, err = rt.prepare(r, body); err != nil {
+		return nil, err
+	}
+	return rt.Transport.RoundTrip(req)
+}
+
+// prepare returns a copy of r with body, as a RoundTripper mustn't modify the
+// requests it is given, along with the headers and credentials.
+func (rt *authRoundTripper) prepare(r *http.Request, body io.ReadCloser) (*http.Request, error) {
+	req := r.Clone(r.Context())
+	req.Body = body
+
+	for name, value := range rt.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if rt.Auth != nil {
+		if err := rt.Auth.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// CloseIdleConnections closes any idle connections held by the underlying
+// transport.
+func (rt *authRoundTripper) CloseIdleConnections() {
+	rt.Transport.CloseIdleConnections()
+}
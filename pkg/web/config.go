@@ -0,0 +1,318 @@
+// Package web serves the exporter's telemetry listener, optionally over TLS
+// and behind HTTP Basic authentication, as configured by a web configuration
+// file in the format of the Prometheus exporter-toolkit.
+package web
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Client certificate policies of TLSConfig.ClientAuthType, named after
+	// their tls.ClientAuthType.
+	NoClientCert               = "NoClientCert"
+	RequestClientCert          = "RequestClientCert"
+	RequireAnyClientCert       = "RequireAnyClientCert"
+	VerifyClientCertIfGiven    = "VerifyClientCertIfGiven"
+	RequireAndVerifyClientCert = "RequireAndVerifyClientCert"
+
+	// defaultMinVersion is the minimum TLS version when none is configured,
+	// the same as the exporter-toolkit's.
+	defaultMinVersion = "TLS12"
+)
+
+var (
+	// clientAuthTypes maps the client certificate policies to their
+	// tls.ClientAuthType.
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		NoClientCert:               tls.NoClientCert,
+		RequestClientCert:          tls.RequestClientCert,
+		RequireAnyClientCert:       tls.RequireAnyClientCert,
+		VerifyClientCertIfGiven:    tls.VerifyClientCertIfGiven,
+		RequireAndVerifyClientCert: tls.RequireAndVerifyClientCert,
+	}
+
+	// tlsVersions maps the names of TLS versions to their tls constant.
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+)
+
+// Config is the root of the web configuration file. Only the settings
+// described here are supported, any other setting of the exporter-toolkit
+// format is rejected rather than silently ignored.
+type Config struct {
+	// TLSServerConfig serves the telemetry listener over TLS when set.
+	TLSServerConfig *TLSConfig `yaml:"tls_server_config"`
+
+	HTTPServerConfig HTTPConfig `yaml:"http_server_config"`
+
+	// Users maps usernames to the bcrypt hash of their password, HTTP Basic
+	// authentication is required when any is set.
+	Users map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig holds the TLS settings of the telemetry listener. The files are
+// read again whenever they change on disk.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientAuthType is one of the client certificate policies, NoClientCert
+	// when empty.
+	ClientAuthType string `yaml:"client_auth_type"`
+	// ClientCAFile holds the CAs client certificates are verified against.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAllowedSANs restricts the verified client certificates to those
+	// with one of these DNS names, IP addresses, email addresses or URIs.
+	ClientAllowedSANs []string `yaml:"client_allowed_sans"`
+
+	// MinVersion and MaxVersion are one of TLS10, TLS11, TLS12 or TLS13,
+	// MinVersion defaults to TLS12.
+	MinVersion string `yaml:"min_version"`
+	MaxVersion string `yaml:"max_version"`
+
+	// CipherSuites are the names of the cipher suites allowed up to TLS 1.2,
+	// Go's defaults are used when empty.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// HTTPConfig holds the HTTP settings of the telemetry listener.
+type HTTPConfig struct {
+	// HTTP2 is enabled unless set to false, it is only negotiated over TLS.
+	HTTP2 *bool `yaml:"http2"`
+
+	// Headers are added to every response.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// http2 reports whether HTTP/2 is enabled.
+func (c HTTPConfig) http2() bool {
+	return c.HTTP2 == nil || *c.HTTP2
+}
+
+// Load reads and validates the web configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses and validates a web configuration. Unknown settings are
+// reported as errors.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the configuration can be served, it doesn't read the
+// files it refers to.
+func (c *Config) Validate() error {
+	var errs []error
+
+	for user, hash := range c.Users {
+		if user == "" {
+			errs = append(errs, errors.New("basic_auth_users: username must not be empty"))
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			// The error isn't wrapped, as it could quote the hash
+			errs = append(errs, fmt.Errorf("basic_auth_users: user %q doesn't have a valid bcrypt hash", user))
+		}
+	}
+
+	for name := range c.HTTPServerConfig.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			errs = append(errs, fmt.Errorf("http_server_config.headers: invalid header name %q", name))
+		}
+	}
+
+	if t := c.TLSServerConfig; t != nil {
+		errs = append(errs, t.validate()...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate returns every problem found in the TLS settings.
+func (t *TLSConfig) validate() []error {
+	var errs []error
+	errorf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("tls_server_config."+format, args...))
+	}
+
+	if t.CertFile == "" {
+		errorf("cert_file: must be set")
+	}
+	if t.KeyFile == "" {
+		errorf("key_file: must be set")
+	}
+
+	authType := t.clientAuthType()
+	if _, ok := clientAuthTypes[authType]; !ok {
+		errorf("client_auth_type: unknown client auth type %q", authType)
+	}
+	verifies := authType == VerifyClientCertIfGiven || authType == RequireAndVerifyClientCert
+	if verifies && t.ClientCAFile == "" {
+		errorf("client_ca_file: must be set with client_auth_type %s", authType)
+	}
+	if !verifies && t.ClientCAFile != "" {
+		errorf("client_ca_file: requires client_auth_type %s or %s", VerifyClientCertIfGiven, RequireAndVerifyClientCert)
+	}
+	if !verifies && len(t.ClientAllowedSANs) > 0 {
+		errorf("client_allowed_sans: requires client_auth_type %s or %s", VerifyClientCertIfGiven, RequireAndVerifyClientCert)
+	}
+
+	minVersion, minOK := tlsVersions[t.minVersion()]
+	if !minOK {
+		errorf("min_version: unknown TLS version %q", t.MinVersion)
+	}
+	if t.MaxVersion != "" {
+		maxVersion, ok := tlsVersions[t.MaxVersion]
+		switch {
+		case !ok:
+			errorf("max_version: unknown TLS version %q", t.MaxVersion)
+		case minOK && maxVersion < minVersion:
+			errorf("max_version: must not be lower than min_version")
+		}
+	}
+
+	for _, name := range t.CipherSuites {
+		if _, ok := cipherSuite(name); !ok {
+			errorf("cipher_suites: unknown or insecure cipher suite %q", name)
+		}
+	}
+
+	return errs
+}
+
+// clientAuthType returns the client certificate policy, with its default.
+func (t *TLSConfig) clientAuthType() string {
+	if t.ClientAuthType == "" {
+		return NoClientCert
+	}
+	return t.ClientAuthType
+}
+
+// minVersion returns the minimum TLS version, with its default.
+func (t *TLSConfig) minVersion() string {
+	if t.MinVersion == "" {
+		return defaultMinVersion
+	}
+	return t.MinVersion
+}
+
+// files returns the files the TLS settings refer to.
+func (t *TLSConfig) files() []string {
+	files := []string{t.CertFile, t.KeyFile}
+	if t.ClientCAFile != "" {
+		files = append(files, t.ClientCAFile)
+	}
+	return files
+}
+
+// cipherSuite returns the ID of the named cipher suite, insecure cipher suites
+// aren't allowed.
+func cipherSuite(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+	return 0, false
+}
+
+// build reads the files of the TLS settings and returns the tls.Config of the
+// telemetry listener.
+func (t *TLSConfig) build(http2 bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.clientAuthType()],
+		MinVersion:   tlsVersions[t.minVersion()],
+		MaxVersion:   tlsVersions[t.MaxVersion],
+		NextProtos:   []string{"http/1.1"},
+	}
+	if http2 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	for _, name := range t.CipherSuites {
+		id, _ := cipherSuite(name)
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	if t.ClientCAFile != "" {
+		b, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA file: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in client CA file %q", t.ClientCAFile)
+		}
+	}
+
+	if len(t.ClientAllowedSANs) > 0 {
+		cfg.VerifyPeerCertificate = t.verifySANs
+	}
+
+	return cfg, nil
+}
+
+// verifySANs rejects verified client certificates which don't hold any of the
+// allowed SANs. It is only called once the chain was verified, so the leaf is
+// the first certificate of the first chain.
+func (t *TLSConfig) verifySANs(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		// VerifyClientCertIfGiven without a client certificate
+		return nil
+	}
+
+	leaf := chains[0][0]
+	sans := slices.Clone(leaf.DNSNames)
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, san := range sans {
+		if slices.Contains(t.ClientAllowedSANs, san) {
+			return nil
+		}
+	}
+	return errors.New("client certificate doesn't hold any of the allowed SANs")
+}
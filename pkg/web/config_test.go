@@ -0,0 +1,97 @@
+package web
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// secretHash is the bcrypt hash of "secret" at the minimum cost.
+const secretHash = "$2a$04$TK3G6YeKTQL2GT0S.BtboubyF1.wohtzjjplSUGzDeCpfTgf5xSWq"
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+  client_allowed_sans: [prometheus.example.org]
+  min_version: TLS13
+  cipher_suites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]
+http_server_config:
+  http2: false
+  headers:
+    X-Frame-Options: deny
+basic_auth_users:
+  prometheus: ` + secretHash + `
+`))
+	assert.Nil(t, err)
+
+	http2 := false
+	assert.Equal(t, &Config{
+		TLSServerConfig: &TLSConfig{
+			CertFile:          "server.crt",
+			KeyFile:           "server.key",
+			ClientAuthType:    RequireAndVerifyClientCert,
+			ClientCAFile:      "ca.crt",
+			ClientAllowedSANs: []string{"prometheus.example.org"},
+			MinVersion:        "TLS13",
+			CipherSuites:      []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		},
+		HTTPServerConfig: HTTPConfig{
+			HTTP2:   &http2,
+			Headers: map[string]string{"X-Frame-Options": "deny"},
+		},
+		Users: map[string]string{"prometheus": secretHash},
+	}, c)
+	assert.Equal(t, []string{"server.crt", "server.key", "ca.crt"}, c.TLSServerConfig.files())
+}
+
+func TestParseEmpty(t *testing.T) {
+	c, err := Parse(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &Config{}, c)
+	assert.True(t, c.HTTPServerConfig.http2())
+}
+
+func TestParseErrors(t *testing.T) {
+	server := "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n"
+
+	tests := map[string]string{
+		"unknown setting":       "tls_server_config:\n  cert: inline\n",
+		"missing key file":      "tls_server_config:\n  cert_file: server.crt\n",
+		"unknown auth type":     server + "  client_auth_type: Sometimes\n",
+		"verify without CA":     server + "  client_auth_type: RequireAndVerifyClientCert\n",
+		"CA without verify":     server + "  client_ca_file: ca.crt\n",
+		"SANs without verify":   server + "  client_allowed_sans: [a]\n",
+		"unknown min version":   server + "  min_version: SSL3\n",
+		"unknown max version":   server + "  max_version: TLS14\n",
+		"max below min":         server + "  min_version: TLS13\n  max_version: TLS12\n",
+		"insecure cipher suite": server + "  cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]\n",
+		"invalid hash":          "basic_auth_users:\n  prometheus: secret\n",
+		"empty username":        "basic_auth_users:\n  \"\": " + secretHash + "\n",
+		"invalid header":        "http_server_config:\n  headers:\n    \"X Bad\": value\n",
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(in))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParseDoesNotLeakHashes(t *testing.T) {
+	_, err := Parse([]byte("basic_auth_users:\n  prometheus: not-a-bcrypt-hash\n"))
+	assert.ErrorContains(t, err, `user "prometheus"`)
+	assert.NotContains(t, err.Error(), "not-a-bcrypt-hash")
+}
+
+func TestTLSConfig_defaults(t *testing.T) {
+	c := &TLSConfig{CertFile: "server.crt", KeyFile: "server.key"}
+	assert.Empty(t, c.validate())
+	assert.Equal(t, NoClientCert, c.clientAuthType())
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsVersions[c.minVersion()])
+}
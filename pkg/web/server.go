@@ -0,0 +1,257 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// realm is the HTTP Basic authentication realm of the telemetry listener.
+	realm = "rtorrent-exporter"
+
+	// maxCachedLogins bounds the number of verified logins which are cached,
+	// the cache is emptied once full.
+	maxCachedLogins = 100
+)
+
+// dummyHash is compared with the password of unknown users, so that they take
+// as long to be rejected as known users with a wrong password. It is the
+// bcrypt hash of a random password at the default cost.
+var dummyHash = []byte("$2a$10$NRCang334pqcsXTv3g0dMuRVhpy1B9ITxjfJOcpUPhW4XUPljDIC6")
+
+// A Server serves HTTP handlers according to a web configuration file. The
+// file, along with the certificates it refers to, is read again whenever it
+// changes on disk, a change which can't be loaded is logged and the previous
+// configuration kept.
+type Server struct {
+	path string
+
+	mu  sync.Mutex
+	cfg *Config
+	tls *tls.Config
+	// files holds the state of the files cfg and tls were read from.
+	files map[string]fileState
+
+	// logins caches the outcome of verifying the bcrypt hash of each login,
+	// keyed by the SHA-256 of the username, hash and password.
+	logins map[[sha256.Size]byte]bool
+}
+
+// A fileState is the modification time and size of a file, a missing file has
+// a zero fileState.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// New creates a Server configured by the web configuration file at path. An
+// empty path serves plain HTTP without authentication.
+func New(path string) (*Server, error) {
+	s := &Server{
+		path:   path,
+		cfg:    &Config{},
+		logins: make(map[[sha256.Size]byte]bool),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TLSEnabled reports whether the telemetry listener is served over TLS.
+func (s *Server) TLSEnabled() bool {
+	cfg, _ := s.current()
+	return cfg.TLSServerConfig != nil
+}
+
+// AuthEnabled reports whether HTTP Basic authentication is required.
+func (s *Server) AuthEnabled() bool {
+	cfg, _ := s.current()
+	return len(cfg.Users) > 0
+}
+
+// ListenAndServe listens on srv's address and serves srv's handler, see Serve.
+func (s *Server) ListenAndServe(srv *http.Server) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(srv, ln)
+}
+
+// Serve serves srv's handler on ln, over TLS when configured and behind HTTP
+// Basic authentication when users are configured. Whether TLS is used is
+// decided once, changing it requires a restart.
+func (s *Server) Serve(srv *http.Server, ln net.Listener) error {
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	srv.Handler = s.Handler(h)
+
+	cfg, _ := s.current()
+	if cfg.TLSServerConfig == nil {
+		return srv.Serve(ln)
+	}
+
+	if !cfg.HTTPServerConfig.http2() {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, tlsConfig := s.current()
+			return tlsConfig, nil
+		},
+	}
+	return srv.ServeTLS(ln, "", "")
+}
+
+// Handler wraps h with the response headers and HTTP Basic authentication of
+// the current configuration.
+func (s *Server) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, _ := s.current()
+
+		for name, value := range cfg.HTTPServerConfig.Headers {
+			w.Header().Set(name, value)
+		}
+
+		if len(cfg.Users) > 0 && !s.authorized(cfg, r) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Authenticate returns the user whose credentials r carries, it fails when
+// HTTP Basic authentication isn't enabled.
+func (s *Server) Authenticate(r *http.Request) (string, bool) {
+	cfg, _ := s.current()
+	if len(cfg.Users) == 0 || !s.authorized(cfg, r) {
+		return "", false
+	}
+
+	user, _, _ := r.BasicAuth()
+	return user, true
+}
+
+// authorized reports whether r carries the credentials of one of the users of
+// cfg.
+func (s *Server) authorized(cfg *Config, r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	hash, known := cfg.Users[user]
+	if !known {
+		hash = string(dummyHash)
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+
+	s.mu.Lock()
+	valid, cached := s.logins[key]
+	s.mu.Unlock()
+
+	if !cached {
+		valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+
+		s.mu.Lock()
+		if len(s.logins) >= maxCachedLogins {
+			s.logins = make(map[[sha256.Size]byte]bool)
+		}
+		s.logins[key] = valid
+		s.mu.Unlock()
+	}
+
+	// Unknown users are rejected even if they guessed the dummy password
+	return valid && known
+}
+
+// current returns the current configuration and TLS settings, reading them
+// again first if any of their files changed.
+func (s *Server) current() (*Config, *tls.Config) {
+	if s.path == "" {
+		return s.cfg, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.files))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	files := statFiles(paths)
+	if !maps.Equal(files, s.files) {
+		if err := s.load(); err != nil {
+			log.Printf("[ERROR] failed reloading web configuration %q, keeping the current one: %v", s.path, err)
+			// Don't try again until the files change once more
+			s.files = files
+		} else {
+			log.Printf("reloaded web configuration %q", s.path)
+		}
+	}
+
+	return s.cfg, s.tls
+}
+
+// load reads the web configuration file and the files it refers to. s is only
+// updated if they are all valid.
+func (s *Server) load() error {
+	cfg, err := Load(s.path)
+	if err != nil {
+		return fmt.Errorf("cannot load web configuration %q: %w", s.path, err)
+	}
+
+	if s.files != nil && (cfg.TLSServerConfig == nil) != (s.cfg.TLSServerConfig == nil) {
+		return errors.New("enabling or disabling TLS requires a restart")
+	}
+
+	paths := []string{s.path}
+	var tlsConfig *tls.Config
+	if t := cfg.TLSServerConfig; t != nil {
+		if tlsConfig, err = t.build(cfg.HTTPServerConfig.http2()); err != nil {
+			return fmt.Errorf("invalid web configuration %q: %w", s.path, err)
+		}
+		paths = append(paths, t.files()...)
+	}
+
+	s.cfg = cfg
+	s.tls = tlsConfig
+	s.files = statFiles(paths)
+	return nil
+}
+
+// statFiles returns the state of each of the files at paths.
+func statFiles(paths []string) map[string]fileState {
+	files := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		var fs fileState
+		if fi, err := os.Stat(path); err == nil {
+			fs = fileState{modTime: fi.ModTime(), size: fi.Size()}
+		}
+		files[path] = fs
+	}
+	return files
+}
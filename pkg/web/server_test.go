@@ -0,0 +1,300 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A testCert is a certificate along with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCert creates a certificate for cn signed by parent, or self-signed when
+// parent is nil.
+func newCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and its key as PEM to the cert and key files.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	if keyFile == "" {
+		return
+	}
+
+	b, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0o600))
+}
+
+// tlsClient returns a client trusting ca and presenting client, if not nil.
+func (c *testCert) tlsClient(client *testCert, maxVersion uint16) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+
+	cfg := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+// writeConfig writes the web configuration to a file in dir and returns its
+// path.
+func writeConfig(t *testing.T, dir, cfg string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "web.yml")
+	assert.Nil(t, os.WriteFile(path, []byte(cfg), 0o600))
+	return path
+}
+
+// touch moves the modification time of the files forward, so that their
+// change is noticed even within the resolution of the file system.
+func touch(t *testing.T, paths ...string) {
+	t.Helper()
+
+	later := time.Now().Add(time.Minute)
+	for _, path := range paths {
+		assert.Nil(t, os.Chtimes(path, later, later))
+	}
+}
+
+// serve serves a handler reporting the served TLS version with s, and returns
+// its URL.
+func serve(t *testing.T, s *Server, scheme string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	srv := &http.Server{
+		ReadHeaderTimeout: time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				_, _ = io.WriteString(w, tls.VersionName(r.TLS.Version))
+			}
+		}),
+	}
+	go func() {
+		if err := s.Serve(srv, ln); !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("failed serving: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return scheme + "://" + ln.Addr().String()
+}
+
+func TestNew_Plain(t *testing.T) {
+	s, err := New("")
+	assert.Nil(t, err)
+	assert.False(t, s.TLSEnabled())
+	assert.False(t, s.AuthEnabled())
+
+	resp, err := http.Get(serve(t, s, "http"))
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := New(filepath.Join(dir, "missing.yml"))
+	assert.NotNil(t, err)
+
+	_, err = New(writeConfig(t, dir, "tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n"))
+	assert.ErrorContains(t, err, "cannot load server certificate")
+}
+
+func TestServer_BasicAuth(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+http_server_config:
+  headers:
+    X-Content-Type-Options: nosniff
+basic_auth_users:
+  prometheus: `+secretHash+`
+`)
+	s, err := New(path)
+	assert.Nil(t, err)
+	assert.True(t, s.AuthEnabled())
+
+	h := s.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	get := func(username, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("prometheus", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	// The cached login is still accepted
+	assert.Equal(t, http.StatusOK, get("prometheus", "secret").Code)
+
+	w = get("prometheus", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="rtorrent-exporter"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, get("unknown", "secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("", "").Code)
+
+	// Users are read again once the configuration changes
+	assert.Nil(t, os.WriteFile(path, []byte("basic_auth_users:\n  admin: "+secretHash+"\n"), 0o600))
+	touch(t, path)
+	assert.Equal(t, http.StatusUnauthorized, get("prometheus", "secret").Code)
+	assert.Equal(t, http.StatusOK, get("admin", "secret").Code)
+
+	// An invalid configuration keeps the previous one
+	assert.Nil(t, os.WriteFile(path, []byte("basic_auth_users:\n  admin: secret\n"), 0o600))
+	touch(t, path)
+	assert.Equal(t, http.StatusOK, get("admin", "secret").Code)
+}
+
+func TestServer_TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	server.write(t, certFile, keyFile)
+
+	s, err := New(writeConfig(t, dir, `
+tls_server_config:
+  cert_file: `+certFile+`
+  key_file: `+keyFile+`
+  min_version: TLS13
+`))
+	assert.Nil(t, err)
+	assert.True(t, s.TLSEnabled())
+	url := serve(t, s, "https")
+
+	resp, err := ca.tlsClient(nil, 0).Get(url)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "TLS 1.3", string(body))
+
+	_, err = ca.tlsClient(nil, tls.VersionTLS12).Get(url)
+	assert.NotNil(t, err)
+
+	// A renewed certificate is served once written to disk
+	renewed := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	renewed.write(t, certFile, keyFile)
+	touch(t, certFile, keyFile)
+
+	resp, err = ca.tlsClient(nil, 0).Get(url)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, renewed.cert.SerialNumber, resp.TLS.PeerCertificates[0].SerialNumber)
+}
+
+func TestServer_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	server.write(t, certFile, keyFile)
+
+	clientCA := newCert(t, "client-ca", nil, x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(dir, "client-ca.crt")
+	clientCA.write(t, caFile, "")
+
+	s, err := New(writeConfig(t, dir, `
+tls_server_config:
+  cert_file: `+certFile+`
+  key_file: `+keyFile+`
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: `+caFile+`
+  client_allowed_sans: [prometheus.example.org]
+`))
+	assert.Nil(t, err)
+	url := serve(t, s, "https")
+
+	allowed := newCert(t, "prometheus.example.org", clientCA, x509.ExtKeyUsageClientAuth)
+	resp, err := ca.tlsClient(allowed, 0).Get(url)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for name, client := range map[string]*testCert{
+		"no certificate":   nil,
+		"disallowed SAN":   newCert(t, "other.example.org", clientCA, x509.ExtKeyUsageClientAuth),
+		"untrusted issuer": newCert(t, "prometheus.example.org", ca, x509.ExtKeyUsageClientAuth),
+		"self-signed":      newCert(t, "prometheus.example.org", nil, x509.ExtKeyUsageClientAuth),
+	} {
+		_, err := ca.tlsClient(client, 0).Get(url)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestServer_TLSCannotBeToggled(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "basic_auth_users:\n  admin: "+secretHash+"\n")
+	s, err := New(path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.WriteFile(path, []byte("tls_server_config:\n  cert_file: a.crt\n  key_file: a.key\n"), 0o600))
+	touch(t, path)
+	assert.False(t, s.TLSEnabled())
+	assert.True(t, s.AuthEnabled())
+}